@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gusaki/jsonsearch/pkg/jsondb"
+)
+
+// GroupBy is a comma separated list of key paths to group by.
+type GroupBy []string
+
+// Aggregates is a comma separated list of aggregates in the form of
+// <func>[:<keypath>] [as <name>].
+type Aggregates []jsondb.Aggregate
+
+func (g *GroupBy) String() string {
+	return fmt.Sprint(*g)
+}
+
+func (g *GroupBy) Set(value string) error {
+	for _, path := range strings.Split(value, ",") {
+		tPath := strings.TrimSpace(path)
+		if tPath == "" {
+			continue
+		}
+		*g = append(*g, tPath)
+	}
+	return nil
+}
+
+func (a *Aggregates) String() string {
+	return fmt.Sprint(*a)
+}
+
+func (a *Aggregates) Set(value string) error {
+	for _, s := range strings.Split(value, ",") {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		agg, err := jsondb.ParseAggregate(s)
+		if err != nil {
+			return err
+		}
+		*a = append(*a, agg)
+	}
+	return nil
+}
+
+// runAggregate prints the aggregated groups and
+// returns the process exit code.
+func runAggregate(ctx context.Context, jsonDb *jsondb.JsonDB, q jsondb.AggregateQuery, p *Printer, t *timeoutFlag) int {
+	result, err := jsonDb.AggregateContext(ctx, q)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, t.error(err))
+		return searchExitCode(err)
+	}
+	return printRows(p, result.Columns(), result.Rows())
+}
@@ -4,13 +4,28 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"os"
 	"strings"
+
+	"github.com/gusaki/jsonsearch/pkg/jsondb"
 )
 
 type DBFiles []string
 type IndexBy []string
 type KeyRelations []string
+type SortKeys []jsondb.SortKey
+
+// Schemas maps database names to their JSON Schema.
+type Schemas map[string]string
+
+// Validation is the -validate mode.
+type Validation struct {
+	mode jsondb.ValidationMode
+}
+
+// Fields is the projection applied to the search results.
+type Fields struct {
+	projection *jsondb.Projection
+}
 
 func (f *DBFiles) String() string {
 	return fmt.Sprint(*f)
@@ -26,9 +41,6 @@ func (f *DBFiles) Set(value string) error {
 			log.Println("Error empty dbfiles parameter")
 			continue
 		}
-		if _, err := os.Stat(tFname); err != nil {
-			return err
-		}
 		// Append only if the value was not already present
 		found := false
 		for _, v := range *f {
@@ -80,10 +92,85 @@ func (k *KeyRelations) Set(value string) error {
 			log.Println("Error empty indexby parameter found at pos", n)
 			continue
 		}
-		if strings.Index(tReln, ":") == -1 {
-			return errors.New("invalid relationship format")
+		if _, err := jsondb.ParseRelationship(tReln); err != nil {
+			return err
+		}
+		*k = append(*k, tReln)
+	}
+	return nil
+}
+
+func (s *SortKeys) String() string {
+	return fmt.Sprint(*s)
+}
+
+func (s *SortKeys) Set(value string) error {
+	keys, err := jsondb.ParseSort(value)
+	if err != nil {
+		return err
+	}
+	*s = append(*s, keys...)
+	return nil
+}
+
+func (f *Fields) String() string {
+	if f.projection == nil {
+		return ""
+	}
+	return fmt.Sprint(*f.projection)
+}
+
+func (f *Fields) Set(value string) error {
+	p, err := jsondb.ParseProjection(value)
+	if err != nil {
+		return err
+	}
+	if f.projection == nil {
+		f.projection = &jsondb.Projection{}
+	}
+	f.projection.Include = append(f.projection.Include, p.Include...)
+	f.projection.Exclude = append(f.projection.Exclude, p.Exclude...)
+	f.projection.Computed = append(f.projection.Computed, p.Computed...)
+	return nil
+}
+
+func (s *Schemas) String() string {
+	return fmt.Sprint(*s)
+}
+
+func (s *Schemas) Set(value string) error {
+	if *s == nil {
+		*s = make(Schemas)
+	}
+	for _, schema := range strings.Split(value, ",") {
+		tSchema := strings.TrimSpace(schema)
+		if tSchema == "" {
+			continue
+		}
+		i := strings.Index(tSchema, "=")
+		if i <= 0 || i == len(tSchema)-1 {
+			return errors.New("expected <db>=<schema file>")
 		}
-		*k = append(*k, reln)
+		(*s)[strings.TrimSpace(tSchema[0:i])] = strings.TrimSpace(tSchema[i+1:])
+	}
+	return nil
+}
+
+func (v *Validation) String() string {
+	if v.mode == jsondb.ValidateWarn {
+		return "warn"
+	}
+	return "strict"
+}
+
+func (v *Validation) Set(value string) error {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "strict":
+		v.mode = jsondb.ValidateStrict
+	case "warn":
+		v.mode = jsondb.ValidateWarn
+	default:
+		return errors.New("expected strict or warn")
 	}
 	return nil
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gusaki/jsonsearch/pkg/jsondb"
+)
+
+var checkCommand = &command{
+	name: "check",
+	summary: "Check the referential integrity of -relationships and the uniqueness of primary and -unique keys.\n" +
+		"Exits with 1 when violations are found.",
+	examples: []string{
+		"jsonsearch check -dbfiles org.json,tickets.json -relationships org._id:tickets.org_id",
+		"jsonsearch check -dbfiles org.json,tickets.json -unique org._id,tickets._id",
+	},
+	setup: func(fs *flag.FlagSet) func(args []string) int {
+		var l loader
+		var unique IndexBy
+
+		l.register(fs)
+		fs.Var(&unique, "unique", "Comma separated list of keys whose values must be unique."+
+			" In the form of <db>.<key>.\nExample: organizations._id,tickets._id")
+
+		return func(args []string) int {
+			if len(args) > 0 {
+				return usageError(fs, "Unexpected arguments:", strings.Join(args, " "))
+			}
+			for _, key := range unique {
+				if li := strings.LastIndex(key, "."); li <= 0 || li == len(key)-1 {
+					return usageError(fs, "Invalid -unique key:", key)
+				}
+			}
+			jsonDb, code := l.load(fs)
+			if jsonDb == nil {
+				return code
+			}
+			defer jsonDb.Close()
+			return runCheck(jsonDb, l.relations, unique)
+		}
+	},
+}
+
+// runCheck prints the referential integrity report for the configured
+// relationships and unique keys and returns the process exit code.
+func runCheck(jsonDb *jsondb.JsonDB, relations KeyRelations, unique IndexBy) int {
+	violations, err := checkIntegrity(jsonDb, relations, unique)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return searchExitCode(err)
+	}
+	for _, v := range violations {
+		fmt.Println(v)
+	}
+	if len(violations) > 0 {
+		fmt.Fprintf(os.Stderr, "%d integrity violation(s) found\n", len(violations))
+		return exitNotFound
+	}
+	fmt.Fprintln(os.Stderr, "No integrity violations found")
+	return exitFound
+}
+
+// checkIntegrity returns the violations of the relationships and the
+// duplicate values of the unique keys. Index keys are not expected to be
+// unique, e.g. tickets.status.
+func checkIntegrity(jsonDb *jsondb.JsonDB, relations KeyRelations, unique IndexBy) ([]jsondb.Violation, error) {
+	report, err := jsonDb.CheckIntegrity(relations)
+	if err != nil {
+		return nil, err
+	}
+	// the primary keys of the relationships were already checked
+	checked := make(map[string]bool)
+	for _, key := range report.PrimaryKeys {
+		checked[key] = true
+	}
+	for _, key := range unique {
+		if checked[key] {
+			continue
+		}
+		checked[key] = true
+		li := strings.LastIndex(key, ".")
+		dups, err := jsonDb.DuplicateKeys(key[0:li], key[li+1:])
+		if err != nil {
+			return nil, err
+		}
+		report.Violations = append(report.Violations, dups...)
+	}
+	return report.Violations, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"testing"
+
+	"github.com/gusaki/jsonsearch/pkg/jsondb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckIntegrity(t *testing.T) {
+	dir := t.TempDir()
+	orgs := filepath.Join(dir, "orgs.json")
+	tickets := filepath.Join(dir, "tickets.json")
+	assert.Equal(t, ioutil.WriteFile(orgs, []byte(`[{"_id": 1}, {"_id": 1}, {"_id": 2}]`), 0644), nil)
+	assert.Equal(t, ioutil.WriteFile(tickets, []byte(`[{"_id": 1, "org_id": 1}, {"_id": 1, "org_id": 1}, {"_id": 2, "org_id": 3}]`), 0644), nil)
+	jsonDb, err := jsondb.Load([]string{orgs, tickets})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, jsonDb.BuildIndex("tickets", "org_id"), nil)
+
+	tests := []struct {
+		name      string
+		relations KeyRelations
+		unique    IndexBy
+		kinds     []jsondb.ViolationKind
+	}{
+		{"Relationship", KeyRelations{"orgs._id:tickets.org_id"}, nil,
+			[]jsondb.ViolationKind{jsondb.DuplicateKey, jsondb.DanglingReference}},
+		{"Unique primary key", KeyRelations{"orgs._id:tickets.org_id"}, IndexBy{"orgs._id"},
+			[]jsondb.ViolationKind{jsondb.DuplicateKey, jsondb.DanglingReference}},
+		{"Unique keys", nil, IndexBy{"orgs._id", "tickets._id", "orgs._id"},
+			[]jsondb.ViolationKind{jsondb.DuplicateKey, jsondb.DuplicateKey}},
+		{"Index keys are not unique", nil, nil, []jsondb.ViolationKind{}},
+	}
+	for _, test := range tests {
+		log.Println("Test: ", test.name)
+		violations, err := checkIntegrity(jsonDb, test.relations, test.unique)
+		assert.Equal(t, err, nil)
+		kinds := make([]jsondb.ViolationKind, len(violations))
+		for n, v := range violations {
+			kinds[n] = v.Kind
+		}
+		assert.Equal(t, kinds, test.kinds)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gusaki/jsonsearch/pkg/jsondb"
+)
+
+// completer completes the words of queries and meta-commands from the
+// database names, the key paths of the databases and the indexed
+// values of the keys.
+type completer struct {
+	db *jsondb.JsonDB
+	// keys caches the key paths of each database, mu guards keys
+	// against the reloads of -watch
+	mu   sync.Mutex
+	keys map[string][]string
+}
+
+func newCompleter(db *jsondb.JsonDB) *completer {
+	return &completer{db: db, keys: make(map[string][]string)}
+}
+
+// completionKeywords are offered where a clause may start.
+var completionKeywords = []string{
+	"where", "join", "left join", "select", "group by", "agg", "sort by", "limit", "offset",
+}
+
+func (c *completer) dbKeys(dbname string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys, ok := c.keys[dbname]
+	if !ok {
+		keys, _ = c.db.Keys(dbname)
+		c.keys[dbname] = keys
+	}
+	return keys
+}
+
+// reloaded forgets the key paths of a reloaded database.
+func (c *completer) reloaded(dbname string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.keys, dbname)
+}
+
+// matching returns the candidates starting with prefix, each prefixed
+// with lead.
+func matching(candidates []string, prefix, lead string) []string {
+	var matches []string
+	for _, cand := range candidates {
+		if strings.HasPrefix(cand, prefix) {
+			matches = append(matches, lead+cand)
+		}
+	}
+	return matches
+}
+
+// quoteValue quotes the values the query language would split.
+func quoteValue(v string) string {
+	if v == "" || strings.ContainsAny(v, " \t'\"") {
+		if strings.Contains(v, "\"") {
+			return "'" + v + "'"
+		}
+		return "\"" + v + "\""
+	}
+	return v
+}
+
+// completionWords splits the line into words on white space and
+// commas like the query language, quoted text is part of a word along
+// with its quotes. It returns the words before the word ending the
+// line and the start of that word, which may be an unterminated quote.
+func completionWords(line string) ([]string, int) {
+	var words []string
+	var b strings.Builder
+	var quote rune
+	start, inWord := 0, false
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			b.WriteRune(c)
+			if c == quote {
+				quote = 0
+			}
+		case c == ' ' || c == '\t' || c == ',':
+			if inWord {
+				words = append(words, b.String())
+				b.Reset()
+				inWord = false
+			}
+			start = i + 1
+		default:
+			if c == '"' || c == '\'' {
+				quote = c
+			}
+			b.WriteRune(c)
+			inWord = true
+		}
+	}
+	return words, start
+}
+
+// matchingValues returns the values completing word quoted when needed.
+// A word starting with a quote completes the values holding its prefix
+// in the same quotes.
+func matchingValues(values []string, word string) []string {
+	if word == "" || (word[0] != '"' && word[0] != '\'') {
+		var quoted []string
+		for _, v := range values {
+			quoted = append(quoted, quoteValue(v))
+		}
+		return matching(quoted, word, "")
+	}
+	quote := word[0:1]
+	var matches []string
+	for _, v := range values {
+		if strings.HasPrefix(v, word[1:]) && !strings.Contains(v, quote) {
+			matches = append(matches, quote+v+quote)
+		}
+	}
+	return matches
+}
+
+// Complete returns the start of the word ending the line and the
+// candidates replacing it.
+func (c *completer) Complete(line string) (int, []string) {
+
+	words, start := completionWords(line)
+	word := line[start:]
+
+	// meta-commands
+	if strings.HasPrefix(strings.TrimSpace(line), ".") {
+		if len(words) == 0 {
+			var metas []string
+			for _, h := range replHelp {
+				metas = append(metas, strings.Fields(h)[0])
+			}
+			return start, matching(metas, word, "")
+		}
+		switch {
+		case len(words) == 1 && words[0] == ".keys":
+			return start, matching(c.db.Names(), word, "")
+		case len(words) == 1 && words[0] == ".format":
+			return start, matching(outputFormats, word, "")
+		}
+		return start, nil
+	}
+
+	// the databases of the query, the from database first
+	var from string
+	var dbs []string
+	for n, w := range words {
+		lw := strings.ToLower(w)
+		if (lw == "from" || lw == "join") && n+1 < len(words) {
+			if lw == "from" {
+				from = words[n+1]
+			}
+			dbs = append(dbs, words[n+1])
+		}
+	}
+
+	// the clause of the word and the words of the clause before it
+	clause, args := "", words
+	for n := len(words) - 1; n >= 0; n-- {
+		lw := strings.ToLower(words[n])
+		if lw == "from" || lw == "where" || lw == "join" || lw == "select" ||
+			lw == "group" || lw == "agg" || lw == "sort" || lw == "order" ||
+			lw == "limit" || lw == "offset" {
+			clause, args = lw, words[n+1:]
+			break
+		}
+	}
+	if (clause == "group" || clause == "sort" || clause == "order") && len(args) > 0 &&
+		strings.ToLower(args[0]) == "by" {
+		args = args[1:]
+	} else if clause == "group" || clause == "sort" || clause == "order" {
+		return start, matching([]string{"by"}, word, "")
+	}
+
+	keywords := completionKeywords
+	if from == "" {
+		keywords = []string{"from"}
+	}
+	switch clause {
+	case "":
+		return start, matching(keywords, strings.ToLower(word), "")
+	case "from", "join":
+		if len(args) == 0 {
+			return start, matching(c.db.Names(), word, "")
+		}
+		if clause == "join" && len(args) == 1 {
+			return start, matching(append([]string{"as", "on"}, keywords...), word, "")
+		}
+	case "where":
+		switch {
+		case len(args) == 0:
+			return start, matching(c.dbKeys(from), word, "")
+		case len(args) == 1:
+			return start, matching([]string{"="}, word, "")
+		case len(args) == 2 && args[1] == "=":
+			values, _ := c.db.IndexedValues(from, args[0])
+			return start, matchingValues(values, word)
+		}
+	case "select":
+		if len(dbs) > 1 {
+			// join columns are prefixed with the database name
+			var cols []string
+			for _, dbname := range dbs {
+				for _, k := range c.dbKeys(dbname) {
+					cols = append(cols, dbname+"."+k)
+				}
+			}
+			return start, matching(cols, word, "")
+		}
+		return start, append(matching(c.dbKeys(from), word, ""), matching(keywords, word, "")...)
+	case "group":
+		return start, append(matching(c.dbKeys(from), word, ""), matching(keywords, word, "")...)
+	case "sort", "order":
+		prefix := strings.TrimPrefix(word, "-")
+		return start, matching(c.dbKeys(from), prefix, word[0:len(word)-len(prefix)])
+	case "agg":
+		if i := strings.Index(word, ":"); i != -1 {
+			return start, matching(c.dbKeys(from), word[i+1:], word[0:i+1])
+		}
+		var funcs []string
+		for f := jsondb.Count; f <= jsondb.Max; f++ {
+			if f == jsondb.Count {
+				funcs = append(funcs, f.String())
+				continue
+			}
+			funcs = append(funcs, f.String()+":")
+		}
+		return start, append(matching(funcs, word, ""), matching(keywords, word, "")...)
+	case "limit", "offset":
+		if len(args) == 0 {
+			return start, nil
+		}
+	}
+	return start, matching(keywords, strings.ToLower(word), "")
+}
+
+// commonPrefix returns the longest prefix of the candidates.
+func commonPrefix(candidates []string) string {
+	if len(candidates) == 0 {
+		return ""
+	}
+	sorted := append([]string{}, candidates...)
+	sort.Strings(sorted)
+	first, last := sorted[0], sorted[len(sorted)-1]
+	n := 0
+	for n < len(first) && n < len(last) && first[n] == last[n] {
+		n++
+	}
+	return first[0:n]
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"testing"
+
+	"github.com/gusaki/jsonsearch/pkg/jsondb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComplete(t *testing.T) {
+	dir := t.TempDir()
+	tickets := filepath.Join(dir, "tickets.json")
+	users := filepath.Join(dir, "users.json")
+	assert.Equal(t, ioutil.WriteFile(tickets, []byte(`[
+		{"_id": 1, "subject": "A problem in Japan", "status": "open", "submitter_id": 1},
+		{"_id": 2, "subject": "A problem in Peru", "status": "pending", "submitter_id": 2},
+		{"_id": 3, "subject": "Say \"hello\"", "status": "open", "submitter_id": 1}]`), 0644), nil)
+	assert.Equal(t, ioutil.WriteFile(users, []byte(`[{"_id": 1, "name": "Rose"}]`), 0644), nil)
+	jsonDb, err := jsondb.Load([]string{tickets, users})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, jsonDb.BuildIndex("tickets", "subject"), nil)
+	assert.Equal(t, jsonDb.BuildIndex("tickets", "status"), nil)
+	c := newCompleter(jsonDb)
+
+	tests := []struct {
+		name        string
+		line        string
+		start       int
+		completions []string
+	}{
+		{"From", "fr", 0, []string{"from"}},
+		{"Databases", "from t", 5, []string{"tickets"}},
+		{"Keywords", "from tickets s", 13, []string{"select", "sort by"}},
+		{"Where keys", "from tickets where s", 19, []string{"status", "subject", "submitter_id"}},
+		{"Equals", "from tickets where status ", 26, []string{"="}},
+		{"Values", "from tickets where status = o", 28, []string{"open"}},
+		{"Quoted values", "from tickets where subject = ", 29,
+			[]string{`"A problem in Japan"`, `"A problem in Peru"`, `'Say "hello"'`}},
+		{"Quoted value prefix", `from tickets where subject = "A problem i`, 29,
+			[]string{`"A problem in Japan"`, `"A problem in Peru"`}},
+		{"Quoted value after a space", `from tickets where subject = "A problem in J`, 29,
+			[]string{`"A problem in Japan"`}},
+		{"Single quoted value", `from tickets where subject = 'A problem in P`, 29,
+			[]string{`'A problem in Peru'`}},
+		{"Value holding the quote", `from tickets where subject = "Say`, 29, nil},
+		{"Single quoted value holding a quote", `from tickets where subject = 'Say "`, 29,
+			[]string{`'Say "hello"'`}},
+		{"Clause after a quoted value", `from tickets where subject = "A problem in Peru" sort by -st`, 57,
+			[]string{"-status"}},
+		{"Quoted keywords are values", `from tickets where subject = "sort by" s`, 39,
+			[]string{"select", "sort by"}},
+		{"Select list", "from tickets select _id,su", 24, []string{"subject", "submitter_id"}},
+		{"Join columns", "from tickets join users on tickets.submitter_id:users._id select users.n", 65,
+			[]string{"users.name"}},
+		{"Group by", "from tickets group ", 19, []string{"by"}},
+		{"Aggregate functions", "from tickets group by status agg co", 33, []string{"count"}},
+		{"Aggregate keys", "from tickets group by status agg count,max:_", 39, []string{"max:_id"}},
+		{"Limit", "from tickets limit ", 19, nil},
+		{"Meta-commands", ".f", 0, []string{".format"}},
+		{"Meta-command arguments", ".format y", 8, []string{"yaml"}},
+		{"Keys of a database", ".keys u", 6, []string{"users"}},
+	}
+	for _, test := range tests {
+		log.Println("Test: ", test.name)
+		start, completions := c.Complete(test.line)
+		assert.Equal(t, start, test.start)
+		assert.Equal(t, completions, test.completions)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gusaki/jsonsearch/pkg/jsondb"
+)
+
+var exportCommand = &command{
+	name: "export",
+	summary: "Export the records of a database to a file or stdout, as ndjson by default.\n" +
+		"With -keypath only the records holding -searchvalue are exported.",
+	examples: []string{
+		"jsonsearch export -dbfiles tickets.json -searchdb tickets -output csv -out tickets.csv",
+		"jsonsearch export -dbfiles users.json -searchdb users -keypath role -searchvalue admin \\\n" +
+			"      -fields _id,name,email",
+	},
+	setup: func(fs *flag.FlagSet) func(args []string) int {
+		var l loader
+		var o outputFlags
+		var t timeoutFlag
+		var dbname, keyPath, value, out string
+		var sortKeys SortKeys
+		var fields Fields
+
+		l.register(fs)
+		fs.StringVar(&dbname, "searchdb", "", "Name of database to export")
+		fs.StringVar(&keyPath, "keypath", "", "Dot separated path to the JSON key, exports all the records when empty")
+		fs.StringVar(&value, "searchvalue", "", "Search value")
+		fs.Var(&sortKeys, "sort", "Comma separated list of key paths to sort the records by."+
+			"\nPrefix a key path with - to sort in descending order")
+		fs.Var(&fields, "fields", "Comma separated list of fields of the records to export, like search -fields")
+		fs.StringVar(&out, "out", "", "File to write, stdout when empty")
+		t.register(fs)
+		o.register(fs, false)
+
+		return func(args []string) int {
+			if len(args) > 0 {
+				return usageError(fs, "Unexpected arguments:", strings.Join(args, " "))
+			}
+			if strings.TrimSpace(dbname) == "" {
+				return usageError(fs, "Missing required argument: -searchdb")
+			}
+			if o.format == "" {
+				o.format = "ndjson"
+			}
+			printer, code := o.printer(fs)
+			if printer == nil {
+				return code
+			}
+			jsonDb, code := l.load(fs)
+			if jsonDb == nil {
+				return code
+			}
+			defer jsonDb.Close()
+			// the export of a database only holds its own records, the
+			// related databases are not searched
+			ctx, cancel := t.context(context.Background())
+			defer cancel()
+			result, err := jsonDb.QueryContext(ctx, jsondb.Query{
+				From:   dbname,
+				Key:    keyPath,
+				Value:  value,
+				Fields: fields.projection,
+				Sort:   sortKeys,
+			})
+			if err != nil {
+				fmt.Fprintln(os.Stderr, t.error(err))
+				return searchExitCode(err)
+			}
+
+			if out != "" {
+				f, err := os.Create(out)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					return exitFailed
+				}
+				defer f.Close()
+				printer.w = f
+				printer.color = false
+			}
+			if err := printer.Records(result.Page.Results); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return exitFailed
+			}
+			if len(result.Page.Results) == 0 {
+				return exitNotFound
+			}
+			return exitFound
+		}
+	},
+}
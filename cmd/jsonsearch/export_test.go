@@ -0,0 +1,28 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExport(t *testing.T) {
+	// the records of the related databases are not exported
+	out := filepath.Join(t.TempDir(), "tickets.ndjson")
+	code := exportCommand.run([]string{
+		"-dbfiles", "../../pkg/jsondb/testdata/organizations.json,../../pkg/jsondb/testdata/tickets.json",
+		"-relationships", "organizations._id:tickets.organization_id",
+		"-searchdb", "tickets", "-keypath", "organization_id", "-searchvalue", "101",
+		"-fields", "_id,organization_id", "-sort", "_id", "-out", out,
+	})
+	assert.Equal(t, code, exitFound)
+	b, err := ioutil.ReadFile(out)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(b), `{"_id":"27c447d9-cfda-4415-9a72-d5aa12942cf1","organization_id":101}
+{"_id":"89255552-e9a2-433b-970a-af194b3a39dd","organization_id":101}
+{"_id":"b07a8c20-2ee5-493b-9ebf-f6321b95966e","organization_id":101}
+{"_id":"c22aaced-7faa-4b5c-99e5-1a209500ff16","organization_id":101}
+`)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// highlighter prints colorized JSON like colorjson and highlights the
+// values at the matched paths.
+type highlighter struct {
+	indent    int
+	key       *color.Color
+	str       *color.Color
+	boolean   *color.Color
+	number    *color.Color
+	null      *color.Color
+	highlight *color.Color
+	paths     map[string]bool
+}
+
+func newHighlighter(paths []string) *highlighter {
+	h := &highlighter{
+		indent:    4,
+		key:       color.New(color.FgWhite),
+		str:       color.New(color.FgGreen),
+		boolean:   color.New(color.FgYellow),
+		number:    color.New(color.FgCyan),
+		null:      color.New(color.FgMagenta),
+		highlight: color.New(color.FgBlack, color.BgYellow, color.Bold),
+		paths:     make(map[string]bool),
+	}
+	for _, p := range paths {
+		h.paths[p] = true
+	}
+	return h
+}
+
+func (h *highlighter) Marshal(v interface{}) string {
+	var b strings.Builder
+	h.write(&b, v, "", 0)
+	return b.String()
+}
+
+func (h *highlighter) scalar(v interface{}) (string, *color.Color) {
+	switch val := v.(type) {
+	case nil:
+		return "null", h.null
+	case bool:
+		return strconv.FormatBool(val), h.boolean
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), h.number
+	case string:
+		s, _ := json.Marshal(val)
+		return string(s), h.str
+	}
+	return fmt.Sprint(v), nil
+}
+
+func (h *highlighter) write(b *strings.Builder, v interface{}, path string, depth int) {
+	pad := strings.Repeat(" ", h.indent*(depth+1))
+	end := strings.Repeat(" ", h.indent*depth)
+	switch val := v.(type) {
+	case map[string]interface{}:
+		if len(val) == 0 {
+			b.WriteString("{}")
+			return
+		}
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b.WriteString("{\n")
+		for n, k := range keys {
+			kpath := k
+			if path != "" {
+				kpath = path + "." + k
+			}
+			ks, _ := json.Marshal(k)
+			b.WriteString(pad + h.key.Sprint(string(ks)) + ": ")
+			h.write(b, val[k], kpath, depth+1)
+			if n < len(keys)-1 {
+				b.WriteString(",")
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString(end + "}")
+	case []interface{}:
+		if len(val) == 0 {
+			b.WriteString("[]")
+			return
+		}
+		b.WriteString("[\n")
+		for n, e := range val {
+			b.WriteString(pad)
+			h.write(b, e, fmt.Sprintf("%s[%d]", path, n), depth+1)
+			if n < len(val)-1 {
+				b.WriteString(",")
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString(end + "]")
+	default:
+		s, c := h.scalar(val)
+		if h.paths[path] {
+			c = h.highlight
+		}
+		if c == nil {
+			b.WriteString(s)
+			return
+		}
+		b.WriteString(c.Sprint(s))
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHighlighter(t *testing.T) {
+	noColor := color.NoColor
+	color.NoColor = false
+	defer func() { color.NoColor = noColor }()
+
+	rec := map[string]interface{}{
+		"_id":  1.0,
+		"name": "West",
+		"tags": []interface{}{"East", "West"},
+		"org":  map[string]interface{}{"name": "West", "active": true, "parent": nil},
+		"none": map[string]interface{}{},
+	}
+	h := newHighlighter([]string{"tags[1]", "org.name"})
+	out := h.Marshal(rec)
+
+	// only the values at the paths are highlighted
+	west := h.highlight.Sprint(`"West"`)
+	assert.Equal(t, strings.Count(out, west), 2)
+	assert.Equal(t, strings.Contains(out, h.key.Sprint(`"tags"`)+": [\n"+
+		"        "+h.str.Sprint(`"East"`)+",\n"+
+		"        "+west+"\n    ]"), true)
+	assert.Equal(t, strings.Contains(out, h.key.Sprint(`"name"`)+": "+h.str.Sprint(`"West"`)+",\n"), true)
+	assert.Equal(t, strings.Contains(out, h.key.Sprint(`"name"`)+": "+west+",\n"), true)
+	assert.Equal(t, strings.Contains(out, h.number.Sprint("1")), true)
+	assert.Equal(t, strings.Contains(out, h.boolean.Sprint("true")), true)
+	assert.Equal(t, strings.Contains(out, h.null.Sprint("null")), true)
+
+	// without colors the output is the indented JSON of the record
+	color.NoColor = true
+	expected, _ := json.MarshalIndent(rec, "", "    ")
+	assert.Equal(t, newHighlighter([]string{"name"}).Marshal(rec), string(expected))
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/gusaki/jsonsearch/pkg/jsondb"
+)
+
+var indexCommand = &command{
+	name: "index",
+	args: "[build|verify|clear]",
+	summary: "Build the indexes of -indexby and -relationships and print their sizes.\n" +
+		"build also saves the indexes of each database file to an index file next to it,\n" +
+		"e.g. tickets.json.idx, which the commands load instead of rebuilding the indexes\n" +
+		"while the database file is unchanged. verify checks the index files against the\n" +
+		"database files and clear removes them.",
+	examples: []string{
+		"jsonsearch index -dbfiles org.json,tickets.json -indexby org._id \\\n" +
+			"      -relationships org._id:tickets.org_id",
+		"jsonsearch index build -dbfiles org.json,tickets.json -indexby org._id,tickets.org_id",
+		"jsonsearch index verify -dbfiles org.json,tickets.json",
+	},
+	setup: func(fs *flag.FlagSet) func(args []string) int {
+		var l loader
+		var o outputFlags
+
+		l.register(fs)
+		o.register(fs, false)
+
+		return func(args []string) int {
+			action := ""
+			if len(args) > 0 {
+				// the flags may follow the action
+				action = args[0]
+				if err := fs.Parse(args[1:]); err != nil {
+					return exitUsage
+				}
+				if fs.NArg() > 0 {
+					return usageError(fs, "Unexpected arguments:", strings.Join(fs.Args(), " "))
+				}
+			}
+			printer, code := o.printer(fs)
+			if printer == nil {
+				return code
+			}
+			switch action {
+			case "":
+				jsonDb, code := l.load(fs)
+				if jsonDb == nil {
+					return code
+				}
+				defer jsonDb.Close()
+				return printIndexes(printer, jsonDb)
+			case "build":
+				return buildIndexFiles(fs, &l, printer)
+			case "verify":
+				return verifyIndexFiles(fs, &l, printer)
+			case "clear":
+				return clearIndexFiles(fs, &l, printer)
+			}
+			return usageError(fs, "Unknown action:", action)
+		}
+	},
+}
+
+// printIndexes prints the built indexes of the database.
+func printIndexes(p *Printer, jsonDb *jsondb.JsonDB) int {
+	var rows [][]interface{}
+	for _, info := range jsonDb.Indexes() {
+		rows = append(rows, []interface{}{
+			info.DB, info.Key, float64(info.Values), float64(info.Entries),
+		})
+	}
+	return printRows(p, []string{"db", "key", "values", "entries"}, rows)
+}
+
+// buildIndexFiles builds the indexes and saves the index file of each
+// database file. Databases without indexes and databases whose indexes
+// cannot be saved, e.g. objects or databases kept on disk, are skipped.
+func buildIndexFiles(fs *flag.FlagSet, l *loader, p *Printer) int {
+	jsonDb, code := l.load(fs)
+	if jsonDb == nil {
+		return code
+	}
+	defer jsonDb.Close()
+	indexed := make(map[string]bool)
+	for _, info := range jsonDb.Indexes() {
+		indexed[info.DB] = true
+	}
+	code = exitFound
+	var rows [][]interface{}
+	for _, name := range jsonDb.Names() {
+		if !indexed[name] {
+			fmt.Fprintf(os.Stderr, "Skipping %s: no indexes\n", name)
+			continue
+		}
+		source, _ := jsonDb.Source(name)
+		keys, err := jsonDb.SaveIndexes(name)
+		if errors.Is(err, jsondb.ErrNoSourceFile) || errors.Is(err, jsondb.ErrUnsupportedStore) {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", name, err)
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot save the indexes of %s: %v\n", name, err)
+			code = exitLoad
+			continue
+		}
+		rows = append(rows, []interface{}{name, jsondb.IndexFile(source.Path), strings.Join(keys, ",")})
+	}
+	if err := p.Rows([]string{"db", "index file", "keys"}, rows); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailed
+	}
+	return code
+}
+
+// verifyIndexFiles checks the index file of each database file, the
+// exit code is not found unless every index file is valid.
+func verifyIndexFiles(fs *flag.FlagSet, l *loader, p *Printer) int {
+	if len(l.dbfiles) == 0 {
+		return usageError(fs, "Missing required argument: -dbfiles")
+	}
+	code := exitFound
+	var rows [][]interface{}
+	for _, fname := range l.dbfiles {
+		keys, err := jsondb.VerifyIndexFile(fname)
+		status := "valid"
+		switch {
+		case err == nil:
+		case os.IsNotExist(err):
+			status = "missing"
+		case errors.Is(err, jsondb.ErrStaleIndexFile), errors.Is(err, jsondb.ErrIndexFileVersion):
+			status = "stale"
+		default:
+			log.Println("Error verifying the index file of", fname, err)
+			return exitLoad
+		}
+		if err != nil {
+			code = exitNotFound
+		}
+		rows = append(rows, []interface{}{fname, status, strings.Join(keys, ",")})
+	}
+	if err := p.Rows([]string{"file", "status", "keys"}, rows); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailed
+	}
+	return code
+}
+
+// clearIndexFiles removes the index file of each database file.
+func clearIndexFiles(fs *flag.FlagSet, l *loader, p *Printer) int {
+	if len(l.dbfiles) == 0 {
+		return usageError(fs, "Missing required argument: -dbfiles")
+	}
+	var rows [][]interface{}
+	for _, fname := range l.dbfiles {
+		status := "removed"
+		if err := jsondb.ClearIndexFile(fname); os.IsNotExist(err) {
+			status = "missing"
+		} else if err != nil {
+			log.Println("Error removing the index file of", fname, err)
+			return exitLoad
+		}
+		rows = append(rows, []interface{}{fname, status})
+	}
+	return printRows(p, []string{"file", "status"}, rows)
+}
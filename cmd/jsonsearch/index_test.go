@@ -0,0 +1,33 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gusaki/jsonsearch/pkg/jsondb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildIndexFiles(t *testing.T) {
+	// objects and databases without indexes are skipped
+	dir := t.TempDir()
+	tickets := filepath.Join(dir, "tickets.json")
+	users := filepath.Join(dir, "users.json")
+	config := filepath.Join(dir, "config.json")
+	assert.Equal(t, ioutil.WriteFile(tickets, []byte(`[{"_id": 1, "status": "open"}]`), 0644), nil)
+	assert.Equal(t, ioutil.WriteFile(users, []byte(`[{"_id": 1, "name": "Rose"}]`), 0644), nil)
+	assert.Equal(t, ioutil.WriteFile(config, []byte(`{"version": {"_id": 1}}`), 0644), nil)
+	code := indexCommand.run([]string{
+		"build", "-dbfiles", tickets + "," + users + "," + config,
+		"-indexby", "tickets.status,config._id", "-output", "ndjson",
+	})
+	assert.Equal(t, code, exitFound)
+	_, err := os.Stat(jsondb.IndexFile(tickets))
+	assert.Equal(t, err, nil)
+	_, err = os.Stat(jsondb.IndexFile(users))
+	assert.Equal(t, os.IsNotExist(err), true)
+	_, err = os.Stat(jsondb.IndexFile(config))
+	assert.Equal(t, os.IsNotExist(err), true)
+}
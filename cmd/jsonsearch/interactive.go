@@ -1,14 +1,17 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strings"
 
-	"github.com/TylerBrock/colorjson"
 	"github.com/gusaki/jsonsearch/pkg/jsondb"
 )
 
@@ -42,43 +45,287 @@ func ClearScreen() {
 	}
 }
 
-func readLine() string {
-	reader := bufio.NewReader(os.Stdin)
-	str, _ := reader.ReadString('\n')
-	return strings.TrimSpace(str)
+// defaultPageSize is the number of results shown per page in
+// interactive mode when -limit is not set.
+const defaultPageSize = 10
+
+// historyFile is the name of the history file in the home directory.
+const historyFile = ".jsonsearch_history"
+
+var replCommand = &command{
+	name: "repl",
+	summary: "Run queries interactively, showing the results a page at a time.\n" +
+		"Queries are written in the query language of the query command, lines\n" +
+		"starting with a dot are meta-commands, .help lists them. The history is\n" +
+		"kept in ~/" + historyFile + ".",
+	examples: []string{
+		"jsonsearch repl -dbfiles org.json,tickets.json,users.json \\\n" +
+			"      -indexby org._id,tickets._id -relationships org._id:users.org_id",
+	},
+	setup: func(fs *flag.FlagSet) func(args []string) int {
+		var l loader
+		var o outputFlags
+		var t timeoutFlag
+		var w watchFlag
+		var limit int
+
+		l.register(fs)
+		fs.IntVar(&limit, "limit", defaultPageSize, "Number of results per page on a terminal")
+		t.register(fs)
+		o.register(fs, true)
+		w.register(fs)
+
+		return func(args []string) int {
+			if len(args) > 0 {
+				return usageError(fs, "Unexpected arguments:", strings.Join(args, " "))
+			}
+			printer, code := o.printer(fs)
+			if printer == nil {
+				return code
+			}
+			if code := w.check(fs, &l); code != 0 {
+				return code
+			}
+			jsonDb, code := l.load(fs)
+			if jsonDb == nil {
+				return code
+			}
+			defer jsonDb.Close()
+			if limit <= 0 {
+				limit = defaultPageSize
+			}
+			histFile := ""
+			if home, err := os.UserHomeDir(); err == nil {
+				histFile = filepath.Join(home, historyFile)
+			}
+			r := &repl{
+				db:        jsonDb,
+				relations: l.relations,
+				printer:   printer,
+				output:    o,
+				pageSize:  limit,
+				timeout:   t,
+				editor:    newLineEditor(histFile),
+			}
+			completer := newCompleter(jsonDb)
+			r.editor.complete = completer.Complete
+
+			// queries running during a reload keep the databases they
+			// started with
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			w.start(ctx, jsonDb, func(e jsondb.ReloadEvent) {
+				fmt.Fprintln(os.Stderr, reloadMessage(e))
+				if e.Err == nil {
+					completer.reloaded(e.DB)
+				}
+			})
+			r.run()
+			return exitFound
+		}
+	},
 }
 
-func runInteractive(jsonDb *jsondb.JsonDB, relations KeyRelations) {
+// repl reads queries and meta-commands until .quit or the end of the
+// input.
+type repl struct {
+	db        *jsondb.JsonDB
+	relations KeyRelations
+	printer   *Printer
+	output    outputFlags
+	pageSize  int
+	timeout   timeoutFlag
+	editor    *lineEditor
+}
+
+// replHelp lists the meta-commands.
+var replHelp = []string{
+	".dbs              list the databases",
+	".keys <db>        list the key paths of a database",
+	".index            list the indexes",
+	".relations        list the relationships",
+	".format [format]  show or set the output format, one of " + strings.Join(outputFormats, ", "),
+	".help             show this help",
+	".quit             exit, also Ctrl-D",
+}
+
+func (r *repl) run() {
+	if r.editor.terminal {
+		fmt.Println("Enter a query such as 'from users where _id = 1', .help for help or .quit to exit")
+	}
 	for {
-		ClearScreen()
-		fmt.Println(">> Press CTRL-C to terminate the program <<")
-		fmt.Print("Enter the JSON name to search: ")
-		dbname := readLine()
-		fmt.Print("Enter the name of the key to lookup: ")
-		key := readLine()
-		fmt.Print("Enter the value to lookup: ")
-		value := readLine()
-		results, err := jsonDb.Search(dbname, key, value, relations)
+		line, err := r.editor.ReadLine("jsonsearch> ")
+		if err == errInterrupted {
+			continue
+		}
 		if err != nil {
-			fmt.Println(">>> ", err)
-			fmt.Print("Press enter to continue...")
-			readLine()
+			return
+		}
+		r.editor.AddHistory(line)
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
 			continue
+		case strings.HasPrefix(line, "."):
+			if !r.meta(line) {
+				return
+			}
+		default:
+			r.query(line)
+		}
+	}
+}
+
+// meta runs a meta-command, it returns false on .quit.
+func (r *repl) meta(line string) bool {
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case ".quit", ".exit":
+		return false
+	case ".help":
+		for _, h := range replHelp {
+			fmt.Println(h)
+		}
+		fmt.Println("Queries are in the form of:")
+		fmt.Println("  from <db> [where <keypath> = <value>] [[left] join <db> [as <alias>] [on <relationship>]]")
+		fmt.Println("  [select <fields or columns>] [group by <keypaths>] [agg <aggregates>]")
+		fmt.Println("  [sort by <keys>] [limit <n>] [offset <n>]")
+	case ".dbs":
+		var rows [][]interface{}
+		for _, name := range r.db.Names() {
+			records, _ := r.db.Len(name)
+			rows = append(rows, []interface{}{name, float64(records)})
+		}
+		printRows(r.printer, []string{"db", "records"}, rows)
+	case ".keys":
+		if len(fields) != 2 {
+			fmt.Fprintln(os.Stderr, "Usage: .keys <db>")
+			break
+		}
+		keys, err := r.db.Keys(fields[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			break
+		}
+		rows := make([][]interface{}, len(keys))
+		for n, k := range keys {
+			rows[n] = []interface{}{k}
 		}
-		PrintResults(results)
-		fmt.Print("Press enter to continue...")
-		readLine()
+		printRows(r.printer, []string{"key"}, rows)
+	case ".index":
+		printIndexes(r.printer, r.db)
+	case ".relations":
+		for _, reln := range r.relations {
+			fmt.Println(reln)
+		}
+	case ".format":
+		if len(fields) == 1 {
+			format := r.output.format
+			if format == "" {
+				format = "json for search results and table for joins and aggregates"
+			}
+			fmt.Println(format)
+			break
+		}
+		p, err := NewPrinter(fields[1], r.output.noColor)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			break
+		}
+		p.Annotate = r.output.annotate
+		r.output.format = fields[1]
+		r.printer = p
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %s, .help lists the commands\n", fields[0])
 	}
+	return true
 }
 
-func PrintResults(results []interface{}) {
+// query runs the query and prints its result.
+func (r *repl) query(line string) {
+	q, err := jsondb.ParseQuery(line)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	q.Relations = r.relations
+
+	// Ctrl-C cancels the query and the printing of its results instead
+	// of ending the session, -timeout only limits the query
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	defer signal.Stop(sig)
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	qctx, qcancel := r.timeout.context(ctx)
+	defer qcancel()
+	result, err := r.db.QueryContext(qctx, q)
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "Query cancelled")
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, r.timeout.error(err))
+		return
+	}
+	if result.Page == nil {
+		printRows(r.printer, result.Columns, result.Rows)
+		return
+	}
+	r.pageResults(ctx, result.Page)
+}
+
+// pageResults prints the results one page at a time on a terminal,
+// waiting for the user between pages. Queries read from a file or pipe
+// print all their results.
+func (r *repl) pageResults(ctx context.Context, page *jsondb.Page) {
+	results := page.Results
 	if len(results) == 0 {
-		fmt.Println("Data not found")
+		PrintResults(r.printer, results, nil)
+		return
+	}
+	size := r.pageSize
+	if !r.editor.terminal || size <= 0 {
+		size = len(results)
 	}
-	f := colorjson.NewFormatter()
-	f.Indent = 4
-	for _, r := range results {
-		s, _ := f.Marshal(r)
-		fmt.Println(string(s))
+	for start := 0; start < len(results) && ctx.Err() == nil; start += size {
+		end := start + size
+		if end > len(results) {
+			end = len(results)
+		}
+		var matches [][]string
+		if len(page.Matches) == len(results) {
+			matches = page.Matches[start:end]
+		}
+		if err := PrintResults(r.printer, results[start:end], matches); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+		if end == len(results) {
+			break
+		}
+		prompt := fmt.Sprintf("-- %d-%d of %d results, press enter for more or q to stop --", start+1, end, len(results))
+		line, err := r.editor.ReadLine(prompt)
+		if err != nil || strings.ToLower(strings.TrimSpace(line)) == "q" {
+			break
+		}
+	}
+}
+
+// PrintResults prints the search results and their matched fields, or
+// a message to stderr when there are none.
+func PrintResults(p *Printer, results []interface{}, matches [][]string) error {
+	if len(results) == 0 {
+		fmt.Fprintln(os.Stderr, "Data not found")
+		return nil
 	}
+	return p.Matches(results, matches)
 }
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/gusaki/jsonsearch/pkg/jsondb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPageResults(t *testing.T) {
+	results := make([]interface{}, 25)
+	for n := range results {
+		results[n] = map[string]interface{}{"_id": float64(n)}
+	}
+	// queries read from a pipe print all their results
+	p, b := testPrinter("ndjson")
+	r := &repl{
+		printer:  p,
+		pageSize: 10,
+		editor:   &lineEditor{in: bufio.NewReader(strings.NewReader(""))},
+	}
+	r.pageResults(context.Background(), &jsondb.Page{Results: results, Total: len(results)})
+	assert.Equal(t, strings.Count(b.String(), "\n"), 25)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gusaki/jsonsearch/pkg/jsondb"
+)
+
+var joinCommand = &command{
+	name:    "join",
+	summary: "Join databases on their relationships, one row per record of -from.",
+	examples: []string{
+		"jsonsearch join -dbfiles org.json,tickets.json -relationships org._id:tickets.org_id \\\n" +
+			"      -from tickets -join org -columns 'tickets.subject,org.name as organization' -output csv",
+	},
+	setup: func(fs *flag.FlagSet) func(args []string) int {
+		var l loader
+		var o outputFlags
+		var t timeoutFlag
+		var from string
+		var joins JoinList
+		var columns Columns
+
+		l.register(fs)
+		fs.StringVar(&from, "from", "", "Name of database to join from")
+		fs.Var(&joins, "join", "Comma separated list of joins in the form of"+
+			" [left] <db> [as <alias>] [on <relationship>]."+
+			"\nExample: organizations,left users as submitter on users._id:tickets.submitter_id")
+		fs.Var(&columns, "columns", "Comma separated list of join columns in the form of"+
+			" <alias>.<keypath> [as <name>]."+
+			"\nExample: tickets.subject,organizations.name as organization,submitter.name")
+		t.register(fs)
+		o.register(fs, false)
+
+		return func(args []string) int {
+			if len(args) > 0 {
+				return usageError(fs, "Unexpected arguments:", strings.Join(args, " "))
+			}
+			printer, code := o.printer(fs)
+			if printer == nil {
+				return code
+			}
+			jsonDb, code := l.load(fs)
+			if jsonDb == nil {
+				return code
+			}
+			defer jsonDb.Close()
+			ctx, cancel := t.context(context.Background())
+			defer cancel()
+			return runJoin(ctx, jsonDb, jsondb.JoinQuery{
+				From:      from,
+				Joins:     joins,
+				Columns:   columns,
+				Relations: l.relations,
+			}, printer, &t)
+		}
+	},
+}
+
+// JoinList is a comma separated list of joins in the form of
+// [left] <db> [as <alias>] [on <relationship>].
+type JoinList []jsondb.JoinSpec
+
+// Columns is a comma separated list of columns in the form of
+// <alias>.<key path> [as <name>].
+type Columns []jsondb.Column
+
+func (j *JoinList) String() string {
+	return fmt.Sprint(*j)
+}
+
+func (j *JoinList) Set(value string) error {
+	for _, join := range strings.Split(value, ",") {
+		if strings.TrimSpace(join) == "" {
+			continue
+		}
+		spec, err := jsondb.ParseJoin(join)
+		if err != nil {
+			return err
+		}
+		*j = append(*j, spec)
+	}
+	return nil
+}
+
+func (c *Columns) String() string {
+	return fmt.Sprint(*c)
+}
+
+func (c *Columns) Set(value string) error {
+	for _, col := range strings.Split(value, ",") {
+		if strings.TrimSpace(col) == "" {
+			continue
+		}
+		*c = append(*c, jsondb.ParseColumn(col))
+	}
+	return nil
+}
+
+// runJoin prints the joined rows and returns the
+// process exit code.
+func runJoin(ctx context.Context, jsonDb *jsondb.JsonDB, q jsondb.JoinQuery, p *Printer, t *timeoutFlag) int {
+	result, err := jsonDb.JoinContext(ctx, q)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, t.error(err))
+		return searchExitCode(err)
+	}
+	return printRows(p, result.Columns, result.Rows)
+}
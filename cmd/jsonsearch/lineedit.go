@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// errInterrupted is returned by ReadLine when the line is abandoned
+// with Ctrl-C.
+var errInterrupted = errors.New("interrupted")
+
+// maxHistory is the number of lines kept in the history file.
+const maxHistory = 1000
+
+// escTimeout is how long the rest of an escape sequence is waited for,
+// a lone ESC key is not followed by anything.
+const escTimeout = 50 * time.Millisecond
+
+// errTimeout is returned by readRune when no key is read in time.
+var errTimeout = errors.New("timeout")
+
+// lineEditor reads lines from stdin. On a terminal the line is edited
+// in raw mode with emacs style keys and the history is browsed with
+// the up and down keys, otherwise lines are read as they are.
+type lineEditor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	fd       int
+	terminal bool
+	history  []string
+	histFile string
+	// width is the number of columns of the terminal and row the row
+	// of the cursor below the first row of the prompt
+	width int
+	row   int
+	// keys receives the runes read from in while a line is edited,
+	// keyErr is the read error that ended them
+	keys   chan keyRead
+	keyErr error
+	// complete returns the start of the word to complete in the line
+	// before the cursor and its completions
+	complete func(line string) (int, []string)
+}
+
+func newLineEditor(histFile string) *lineEditor {
+	fd := int(os.Stdin.Fd())
+	e := &lineEditor{
+		in:       bufio.NewReader(os.Stdin),
+		out:      os.Stdout,
+		fd:       fd,
+		terminal: term.IsTerminal(fd),
+		histFile: histFile,
+	}
+	e.loadHistory()
+	return e
+}
+
+func (e *lineEditor) loadHistory() {
+	if e.histFile == "" {
+		return
+	}
+	f, err := os.Open(e.histFile)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.TrimSpace(line) != "" {
+			e.history = append(e.history, line)
+		}
+	}
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
+
+// AddHistory adds the line to the history and appends it to the
+// history file. Lines read from a file or pipe are not kept.
+func (e *lineEditor) AddHistory(line string) {
+	if !e.terminal || strings.TrimSpace(line) == "" {
+		return
+	}
+	if n := len(e.history); n > 0 && e.history[n-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if e.histFile == "" {
+		return
+	}
+	f, err := os.OpenFile(e.histFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
+
+// ReadLine prints the prompt and returns the line read, io.EOF at the
+// end of the input or on Ctrl-D on an empty line, and errInterrupted
+// on Ctrl-C. The prompt is only printed on a terminal.
+func (e *lineEditor) ReadLine(prompt string) (string, error) {
+	if !e.terminal {
+		line, err := e.in.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		return strings.TrimRight(line, "\r\n"), err
+	}
+	state, err := term.MakeRaw(e.fd)
+	if err != nil {
+		return "", err
+	}
+	defer term.Restore(e.fd, state)
+	e.width = 80
+	if width, _, err := term.GetSize(e.fd); err == nil && width > 0 {
+		e.width = width
+	}
+	return e.edit(prompt)
+}
+
+type keyRead struct {
+	r   rune
+	err error
+}
+
+// readRune returns the next rune typed, or errTimeout when a timeout
+// is given and no rune is typed in time. The runes are read by a
+// goroutine so that the wait can time out.
+func (e *lineEditor) readRune(timeout time.Duration) (rune, error) {
+	if e.keyErr != nil {
+		return 0, e.keyErr
+	}
+	if e.keys == nil {
+		e.keys = make(chan keyRead)
+		go func() {
+			for {
+				r, _, err := e.in.ReadRune()
+				e.keys <- keyRead{r, err}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+	var k keyRead
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case k = <-e.keys:
+		case <-timer.C:
+			return 0, errTimeout
+		}
+	} else {
+		k = <-e.keys
+	}
+	e.keyErr = k.err
+	return k.r, k.err
+}
+
+// runeWidth returns the number of columns a rune takes on a terminal:
+// none for combining marks, two for east asian wide runes.
+func runeWidth(r rune) int {
+	switch {
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	case r >= 0x1100 && r <= 0x115f,
+		r >= 0x2e80 && r <= 0xa4cf && r != 0x303f,
+		r >= 0xac00 && r <= 0xd7a3,
+		r >= 0xf900 && r <= 0xfaff,
+		r >= 0xfe30 && r <= 0xfe6f,
+		r >= 0xff00 && r <= 0xff60,
+		r >= 0xffe0 && r <= 0xffe6,
+		r >= 0x1f300 && r <= 0x1f64f,
+		r >= 0x1f900 && r <= 0x1f9ff,
+		r >= 0x20000 && r <= 0x3fffd:
+		return 2
+	}
+	return 1
+}
+
+// cell is a position on the terminal relative to the start of the
+// prompt.
+type cell struct {
+	row, col int
+}
+
+// layout returns the position of each rune of text on a terminal width
+// columns wide, followed by the position after the text. A rune that
+// does not fit on a row wraps to the next one.
+func layout(text []rune, width int) []cell {
+	cells := make([]cell, 0, len(text)+1)
+	var c cell
+	for _, r := range text {
+		w := runeWidth(r)
+		if c.col+w > width {
+			c = cell{c.row + 1, 0}
+		}
+		cells = append(cells, c)
+		c.col += w
+	}
+	if c.col >= width {
+		c = cell{c.row + 1, 0}
+	}
+	return append(cells, c)
+}
+
+// refresh redraws the line with the cursor at pos. The line wraps on
+// the rows below the prompt, which are redrawn from the first one.
+func (e *lineEditor) refresh(prompt string, buf []rune, pos int) {
+	width := e.width
+	if width <= 0 {
+		width = 80
+	}
+	text := append([]rune(prompt), buf...)
+	cells := layout(text, width)
+	end := cells[len(text)]
+	cursor := cells[utf8.RuneCountInString(prompt)+pos]
+
+	var b strings.Builder
+	if e.row > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", e.row)
+	}
+	fmt.Fprintf(&b, "\r\x1b[J%s", string(text))
+	if end.col == 0 && end.row > 0 {
+		// the terminal only wraps on the next rune written
+		b.WriteString("\r\n")
+	}
+	if n := end.row - cursor.row; n > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", n)
+	}
+	b.WriteString("\r")
+	if cursor.col > 0 {
+		fmt.Fprintf(&b, "\x1b[%dC", cursor.col)
+	}
+	e.row = cursor.row
+	io.WriteString(e.out, b.String())
+}
+
+// escape reads an escape sequence and returns the key it stands for.
+// A lone ESC, or a sequence that is not completed in time, is ignored.
+func (e *lineEditor) escape() rune {
+	r, err := e.readRune(escTimeout)
+	if err != nil || (r != '[' && r != 'O') {
+		return 0
+	}
+	r, err = e.readRune(escTimeout)
+	if err != nil {
+		return 0
+	}
+	if r >= '0' && r <= '9' {
+		// sequences like ESC [ 3 ~
+		code := r
+		for r != '~' && err == nil {
+			r, err = e.readRune(escTimeout)
+		}
+		switch code {
+		case '1', '7':
+			return keyHome
+		case '4', '8':
+			return keyEnd
+		case '3':
+			return keyDelete
+		}
+		return 0
+	}
+	switch r {
+	case 'A':
+		return keyUp
+	case 'B':
+		return keyDown
+	case 'C':
+		return keyRight
+	case 'D':
+		return keyLeft
+	case 'H':
+		return keyHome
+	case 'F':
+		return keyEnd
+	}
+	return 0
+}
+
+// maxCompletions is the number of completions listed.
+const maxCompletions = 100
+
+// completeWord replaces the word before the cursor with its completion,
+// or with the common prefix of its completions. The completions are
+// listed when the prefix does not complete the word any further.
+func (e *lineEditor) completeWord(prompt string, buf []rune, pos int) ([]rune, int) {
+	if e.complete == nil {
+		return buf, pos
+	}
+	line := string(buf[0:pos])
+	start, candidates := e.complete(line)
+	if len(candidates) == 0 {
+		return buf, pos
+	}
+	word := line[start:]
+	insert := commonPrefix(candidates)
+	if len(candidates) == 1 && !strings.HasSuffix(insert, ":") {
+		insert += " "
+	}
+	if insert == word {
+		list := candidates
+		if len(list) > maxCompletions {
+			list = list[0:maxCompletions]
+		}
+		fmt.Fprintf(e.out, "\r\n%s", strings.Join(list, "  "))
+		if len(candidates) > len(list) {
+			fmt.Fprintf(e.out, "  ... %d more", len(candidates)-len(list))
+		}
+		fmt.Fprint(e.out, "\r\n")
+		e.row = 0
+		return buf, pos
+	}
+	wordStart := utf8.RuneCountInString(line[0:start])
+	rest := append([]rune(insert), buf[pos:]...)
+	return append(buf[0:wordStart], rest...), wordStart + utf8.RuneCountInString(insert)
+}
+
+// keys decoded from escape sequences, in the unicode private use area
+const (
+	keyUp = 0xe000 + iota
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyDelete
+)
+
+func (e *lineEditor) edit(prompt string) (string, error) {
+
+	var buf []rune
+	pos := 0
+	// the history is browsed from its end, the edited line is kept
+	// in place of the line being browsed
+	hist := append(append([]string{}, e.history...), "")
+	hpos := len(hist) - 1
+
+	e.row = 0
+	e.refresh(prompt, buf, pos)
+	for {
+		r, err := e.readRune(0)
+		if err != nil {
+			e.refresh(prompt, buf, len(buf))
+			fmt.Fprint(e.out, "\r\n")
+			return "", err
+		}
+		if r == 27 {
+			r = e.escape()
+		}
+		switch r {
+		case '\r', '\n':
+			e.refresh(prompt, buf, len(buf))
+			fmt.Fprint(e.out, "\r\n")
+			return string(buf), nil
+		case 3: // Ctrl-C
+			e.refresh(prompt, buf, len(buf))
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(buf) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if pos < len(buf) {
+				buf = append(buf[0:pos], buf[pos+1:]...)
+			}
+		case keyDelete:
+			if pos < len(buf) {
+				buf = append(buf[0:pos], buf[pos+1:]...)
+			}
+		case 127, 8: // Backspace
+			if pos > 0 {
+				buf = append(buf[0:pos-1], buf[pos:]...)
+				pos--
+			}
+		case 1, keyHome: // Ctrl-A
+			pos = 0
+		case 5, keyEnd: // Ctrl-E
+			pos = len(buf)
+		case 2, keyLeft: // Ctrl-B
+			if pos > 0 {
+				pos--
+			}
+		case 6, keyRight: // Ctrl-F
+			if pos < len(buf) {
+				pos++
+			}
+		case 11: // Ctrl-K
+			buf = buf[0:pos]
+		case 21: // Ctrl-U
+			buf = append([]rune{}, buf[pos:]...)
+			pos = 0
+		case 23: // Ctrl-W
+			start := pos
+			for start > 0 && unicode.IsSpace(buf[start-1]) {
+				start--
+			}
+			for start > 0 && !unicode.IsSpace(buf[start-1]) {
+				start--
+			}
+			buf = append(buf[0:start], buf[pos:]...)
+			pos = start
+		case 9: // Tab
+			buf, pos = e.completeWord(prompt, buf, pos)
+		case 12: // Ctrl-L
+			ClearScreen()
+			e.row = 0
+		case 16, keyUp, 14, keyDown: // Ctrl-P, Ctrl-N
+			next := hpos - 1
+			if r == 14 || r == keyDown {
+				next = hpos + 1
+			}
+			if next < 0 || next >= len(hist) {
+				break
+			}
+			hist[hpos] = string(buf)
+			hpos = next
+			buf = []rune(hist[hpos])
+			pos = len(buf)
+		default:
+			if r < 32 || r == 0 {
+				break
+			}
+			buf = append(buf[0:pos], append([]rune{r}, buf[pos:]...)...)
+			pos++
+		}
+		e.refresh(prompt, buf, pos)
+	}
+}
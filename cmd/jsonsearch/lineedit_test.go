@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeTerminal is a screen of width columns that interprets the
+// output of the line editor: runes, carriage returns, line feeds and
+// the cursor up, forward and erase sequences.
+type fakeTerminal struct {
+	width    int
+	rows     [][]rune
+	row, col int
+	// wrap is set when a rune was written in the last column, the
+	// cursor wraps on the next rune like on xterm
+	wrap bool
+}
+
+func newFakeTerminal(width int) *fakeTerminal {
+	return &fakeTerminal{width: width, rows: [][]rune{nil}}
+}
+
+func (t *fakeTerminal) line(row int) []rune {
+	for len(t.rows) <= row {
+		t.rows = append(t.rows, nil)
+	}
+	for len(t.rows[row]) < t.width {
+		t.rows[row] = append(t.rows[row], ' ')
+	}
+	return t.rows[row]
+}
+
+func (t *fakeTerminal) put(r rune) {
+	w := runeWidth(r)
+	if t.wrap || t.col+w > t.width {
+		t.row, t.col, t.wrap = t.row+1, 0, false
+	}
+	line := t.line(t.row)
+	if w == 0 {
+		return
+	}
+	line[t.col] = r
+	if w == 2 {
+		// the second cell of a wide rune is not shown
+		line[t.col+1] = 0
+	}
+	t.col += w
+	if t.col == t.width {
+		t.col, t.wrap = t.width-1, true
+	}
+}
+
+func (t *fakeTerminal) Write(p []byte) (int, error) {
+	s := []rune(string(p))
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\r':
+			t.col, t.wrap = 0, false
+		case '\n':
+			t.row, t.wrap = t.row+1, false
+			t.line(t.row)
+		case 27:
+			j := i + 2
+			for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+				j++
+			}
+			n, err := strconv.Atoi(string(s[i+2 : j]))
+			if err != nil {
+				n = 1
+			}
+			switch s[j] {
+			case 'A':
+				t.row -= n
+			case 'C':
+				t.col += n
+			case 'D':
+				t.col -= n
+			case 'J':
+				for c := t.col; c < t.width; c++ {
+					t.line(t.row)[c] = ' '
+				}
+				t.rows = t.rows[0 : t.row+1]
+			case 'K':
+				for c := t.col; c < t.width; c++ {
+					t.line(t.row)[c] = ' '
+				}
+			}
+			t.wrap = false
+			i = j
+		default:
+			t.put(s[i])
+		}
+	}
+	return len(p), nil
+}
+
+// screen returns the rows of the screen without trailing spaces.
+func (t *fakeTerminal) screen() []string {
+	rows := make([]string, len(t.rows))
+	for n, row := range t.rows {
+		rows[n] = strings.TrimRight(strings.ReplaceAll(string(row), "\x00", ""), " ")
+	}
+	return rows
+}
+
+func TestLineEditor(t *testing.T) {
+	tests := []struct {
+		name    string
+		history []string
+		keys    string
+		line    string
+		err     error
+	}{
+		{"Type a line", nil, "from users\r", "from users", nil},
+		{"Insert at the start and end", nil, "users\x01from \x05 where\r", "from users where", nil},
+		{"Arrow keys", nil, "fom\x1b[D\x1b[Dr\x1b[C\x1b[Cx\r", "fromx", nil},
+		{"Home and end keys", nil, "b\x1b[Ha\x1b[Fc\x1bOHx\r", "xabc", nil},
+		{"Delete and backspace", nil, "abcd\x01\x1b[3~\x04\x05\x7f\x08\r", "", nil},
+		{"Kill words and lines", nil, "from users where\x17\x17x\x01\x06\x0b\r", "f", nil},
+		{"Kill to the start", nil, "from users\x02\x02\x15\r", "rs", nil},
+		{"Previous and next lines", []string{"one", "two"}, "\x1b[A\x1b[A\x10\x1b[Bx\x0e\x0e\r", "", nil},
+		{"Edit a previous line", []string{"one", "two"}, "\x10!\x10\x0e\r", "two!", nil},
+		{"Unknown sequences are ignored", nil, "a\x1b[Zb\x1b[5~c\x1bfd\r", "abcd", nil},
+		{"Wide runes", nil, "日本\x02語\x05x\r", "日語本x", nil},
+		{"Interrupt", nil, "from\x03", "", errInterrupted},
+		{"End of input", nil, "\x04", "", io.EOF},
+		{"End of input while editing", nil, "from", "", io.EOF},
+	}
+	for _, test := range tests {
+		log.Println("Test: ", test.name)
+		e := &lineEditor{
+			in:      bufio.NewReader(strings.NewReader(test.keys)),
+			out:     newFakeTerminal(20),
+			history: test.history,
+		}
+		line, err := e.edit("> ")
+		assert.Equal(t, err, test.err)
+		assert.Equal(t, line, test.line)
+	}
+}
+
+func TestLineEditorEscape(t *testing.T) {
+	r, w := io.Pipe()
+	e := &lineEditor{in: bufio.NewReader(r), out: newFakeTerminal(20)}
+	go func() {
+		// a lone ESC does not wait for the next key
+		io.WriteString(w, "ab\x1b")
+		time.Sleep(4 * escTimeout)
+		io.WriteString(w, "c\x1b")
+		time.Sleep(4 * escTimeout)
+		io.WriteString(w, "[Dd\r")
+	}()
+	line, err := e.edit("> ")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, line, "abc[Dd")
+}
+
+func TestLineEditorWrap(t *testing.T) {
+	term := newFakeTerminal(11)
+	e := &lineEditor{out: term, width: 11}
+	// each line is redrawn over the previous one
+	tests := []struct {
+		name   string
+		buf    string
+		pos    int
+		screen []string
+		cursor cell
+	}{
+		{"Short line", "from", 2, []string{"> from"}, cell{0, 4}},
+		{"Line filling the rows", "from users where _id", 20,
+			[]string{"> from user", "s where _id", ""}, cell{2, 0}},
+		{"Cursor on a wrapped row", "from users where _id", 9,
+			[]string{"> from user", "s where _id", ""}, cell{1, 0}},
+		{"Line shortened", "from", 4, []string{"> from"}, cell{0, 6}},
+		{"Wide rune wrapped", "abcdefgh日本", 10, []string{"> abcdefgh", "日本"}, cell{1, 4}},
+		{"Cursor on a wide rune", "abcdefgh日本", 8, []string{"> abcdefgh", "日本"}, cell{1, 0}},
+		{"Combining mark", "cafe\u0301", 5, []string{"> cafe"}, cell{0, 6}},
+		{"Empty line", "", 0, []string{">"}, cell{0, 2}},
+	}
+	for _, test := range tests {
+		log.Println("Test: ", test.name)
+		e.refresh("> ", []rune(test.buf), test.pos)
+		assert.Equal(t, term.screen(), test.screen)
+		assert.Equal(t, cell{term.row, term.col}, test.cursor)
+	}
+
+	// the line is ended below its last row
+	term = newFakeTerminal(11)
+	e = &lineEditor{
+		in:    bufio.NewReader(strings.NewReader("from users where _id = 1\x01\r")),
+		out:   term,
+		width: 11,
+	}
+	line, err := e.edit("> ")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, line, "from users where _id = 1")
+	assert.Equal(t, term.screen(), []string{"> from user", "s where _id", " = 1", ""})
+	assert.Equal(t, cell{term.row, term.col}, cell{3, 0})
+}
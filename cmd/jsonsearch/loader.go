@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gusaki/jsonsearch/pkg/jsondb"
+)
+
+// loader holds the flags shared by the commands to load the databases
+// and build their indexes.
+type loader struct {
+	dbfiles   DBFiles
+	indexKeys IndexBy
+	relations KeyRelations
+	schemas   Schemas
+	validate  Validation
+	snapshot  string
+	disk      bool
+}
+
+func (l *loader) register(fs *flag.FlagSet) {
+	fs.Var(&l.dbfiles, "dbfiles", "Comma separated list of filenames/filepaths")
+	fs.Var(&l.indexKeys, "indexby", "Comma separated list of index keys."+
+		" In the form of <filename.json_key>."+
+		"\nExample: organizations._id,tickets.id")
+	l.relations = make(KeyRelations, 0)
+	fs.Var(&l.relations, "relationships", "Comma separated list of relationships"+
+		" with each relationship delimited with a colon.\nRelationships are followed both ways,"+
+		" use > instead of a colon for a one way relationship."+
+		"\nExample: organizations._id:tickets.organization_id,users.organization_id>organizations._id")
+	fs.Var(&l.schemas, "schema", "Comma separated list of JSON Schemas (draft 2020-12) validating"+
+		" the records of a database.\nIn the form of <db>=<schema file>. Example: users=users.schema.json")
+	fs.Var(&l.validate, "validate", "What to do with records failing -schema: strict fails the load,"+
+		"\nwarn reports the errors and continues (default strict)")
+	fs.StringVar(&l.snapshot, "snapshot", "", "Snapshot written by jsonsearch snapshot to load instead of -dbfiles."+
+		"\nIts relationships are used without -relationships")
+	fs.BoolVar(&l.disk, "disk", false, "Keep the databases holding a list of records on disk, memory mapped,"+
+		"\ninstead of loading them in memory. Records are decoded when scanned")
+}
+
+// load loads the databases of -snapshot, or of -dbfiles and their valid
+// index files, and builds the indexes of -indexby and -relationships.
+// On failure it returns nil and the exit code.
+func (l *loader) load(fs *flag.FlagSet) (*jsondb.JsonDB, int) {
+
+	if len(l.dbfiles) == 0 && l.snapshot == "" {
+		return nil, usageError(fs, "Missing required argument: -dbfiles")
+	}
+	if l.snapshot != "" && (len(l.dbfiles) > 0 || len(l.schemas) > 0) {
+		return nil, usageError(fs, "-snapshot cannot be combined with -dbfiles or -schema")
+	}
+	if l.snapshot != "" && l.disk {
+		return nil, usageError(fs, "-disk cannot be combined with -snapshot")
+	}
+	for _, key := range l.indexKeys {
+		if strings.LastIndex(key, ".") == -1 {
+			return nil, usageError(fs, "Invalid format -indexby")
+		}
+	}
+
+	var jsonDb *jsondb.JsonDB
+	if l.snapshot != "" {
+		if jsonDb = l.openSnapshot(); jsonDb == nil {
+			return nil, exitLoad
+		}
+		if len(l.relations) == 0 {
+			l.relations = jsonDb.Relationships()
+		}
+	} else if jsonDb = l.loadFiles(); jsonDb == nil {
+		return nil, exitLoad
+	}
+
+	// process -indexby and create indexes
+	for _, key := range l.indexKeys {
+		li := strings.LastIndex(key, ".")
+		if err := jsonDb.BuildIndex(key[0:li], key[li+1:]); err != nil {
+			log.Println("Indexing has failed. This will make searches slow")
+		}
+	}
+
+	// process -relationships and create indexes
+	for _, reln := range l.relations {
+		rel, err := jsondb.ParseRelationship(reln)
+		if err != nil {
+			return nil, usageError(fs, "Invalid format -relationship")
+		}
+		if err = jsonDb.BuildIndex(rel.FromDB, rel.FromKey); err != nil {
+			log.Printf("Indexing has failed for %s.%s", rel.FromDB, rel.FromKey)
+		}
+		if err = jsonDb.BuildIndex(rel.ToDB, rel.ToKey); err != nil {
+			log.Printf("Indexing has failed for %s.%s", rel.ToDB, rel.ToKey)
+		}
+	}
+	jsonDb.SetRelationships(l.relations)
+	return jsonDb, exitFound
+}
+
+// openSnapshot opens the snapshot of -snapshot, it returns nil on
+// failure.
+func (l *loader) openSnapshot() *jsondb.JsonDB {
+	f, err := os.Open(l.snapshot)
+	if err != nil {
+		log.Println("Program terminated with an error:", err)
+		return nil
+	}
+	defer f.Close()
+	jsonDb, err := jsondb.Open(f)
+	if err != nil {
+		log.Println("Program terminated with an error:", err)
+		return nil
+	}
+	return jsonDb
+}
+
+// loadFiles loads the databases of -dbfiles, validating the records of
+// -schema, and their valid index files. It returns nil on failure.
+func (l *loader) loadFiles() *jsondb.JsonDB {
+
+	jsonDb, err := jsondb.LoadWithOptions(l.dbfiles, jsondb.LoadOptions{
+		Schemas:   l.schemas,
+		Validate:  l.validate.mode,
+		DiskStore: l.disk,
+	})
+	var serrs jsondb.SchemaErrors
+	if errors.As(err, &serrs) {
+		for _, e := range serrs {
+			fmt.Fprintln(os.Stderr, e)
+		}
+		fmt.Fprintf(os.Stderr, "%d schema validation error(s) found\n", len(serrs))
+		return nil
+	}
+	if err != nil {
+		log.Println("Program terminated with an error:", err)
+		return nil
+	}
+	if errs := jsonDb.ValidationErrors(); len(errs) > 0 {
+		for _, e := range errs {
+			fmt.Fprintln(os.Stderr, "warning:", e)
+		}
+		fmt.Fprintf(os.Stderr, "warning: %d schema validation error(s) found\n", len(errs))
+	}
+
+	// load the indexes saved by jsonsearch index build, the indexes
+	// still missing are built by load
+	for _, name := range jsonDb.Names() {
+		_, err := jsonDb.LoadIndexes(name)
+		if errors.Is(err, jsondb.ErrStaleIndexFile) || errors.Is(err, jsondb.ErrIndexFileVersion) {
+			log.Printf("The index file of %s is out of date, run jsonsearch index build", name)
+		}
+	}
+	return jsonDb
+}
+
+// watchFlag holds -watch, the interval of the checks of the database
+// files reloaded when they change.
+type watchFlag struct {
+	interval time.Duration
+}
+
+func (w *watchFlag) register(fs *flag.FlagSet) {
+	fs.DurationVar(&w.interval, "watch", 0, "Reload the -dbfiles changed on disk and rebuild their indexes, e.g. 2s."+
+		"\nA file is reloaded once unchanged for the duration, files are polled every duration"+
+		"\nwithout file system events (default off)")
+}
+
+// check returns the exit code of a usage error when -watch cannot be
+// used with the flags of the loader, zero otherwise.
+func (w *watchFlag) check(fs *flag.FlagSet, l *loader) int {
+	if w.interval > 0 && l.snapshot != "" {
+		return usageError(fs, "-watch cannot be combined with -snapshot")
+	}
+	return 0
+}
+
+// start watches the database files in the background until the context
+// is done, report is called with each reload.
+func (w *watchFlag) start(ctx context.Context, jsonDb *jsondb.JsonDB, report func(jsondb.ReloadEvent)) {
+	if w.interval <= 0 {
+		return
+	}
+	go jsonDb.Watch(ctx, w.interval, report)
+}
+
+// reloadMessage describes a reload of -watch.
+func reloadMessage(e jsondb.ReloadEvent) string {
+	if e.Err != nil {
+		return fmt.Sprintf("Reloading %s failed, keeping the loaded database: %v", e.DB, e.Err)
+	}
+	indexes := "no indexes"
+	if len(e.Indexes) > 0 {
+		indexes = "indexes " + strings.Join(e.Indexes, ", ")
+	}
+	return fmt.Sprintf("Reloaded %s from %s in %v, %s", e.DB, e.Path, e.Duration.Round(time.Millisecond), indexes)
+}
+
+// timeoutFlag holds -timeout, the maximum duration of the searches and
+// queries of a command.
+type timeoutFlag struct {
+	timeout time.Duration
+}
+
+func (t *timeoutFlag) register(fs *flag.FlagSet) {
+	fs.DurationVar(&t.timeout, "timeout", 0, "Maximum duration of a search or query, e.g. 500ms or 10s."+
+		"\nNo limit when zero")
+}
+
+// context returns the context of a search, done with the parent or
+// after -timeout.
+func (t *timeoutFlag) context(parent context.Context) (context.Context, context.CancelFunc) {
+	if t.timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, t.timeout)
+}
+
+// error returns the error of a search, reporting the timeout of
+// searches running out of time.
+func (t *timeoutFlag) error(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("search timed out after %v", t.timeout)
+	}
+	return err
+}
+
+// outputFlags holds the flags of the commands printing results.
+type outputFlags struct {
+	format   string
+	noColor  bool
+	annotate bool
+}
+
+// register adds -output and -no-color, and -matches for commands
+// printing search results.
+func (o *outputFlags) register(fs *flag.FlagSet, matches bool) {
+	fs.StringVar(&o.format, "output", "", "Output format: "+strings.Join(outputFormats, ", ")+
+		".\nDefaults to json for search results and table for joins and aggregates")
+	fs.BoolVar(&o.noColor, "no-color", false, "Disable colorized JSON output,"+
+		" JSON is only colorized when writing to a terminal")
+	if matches {
+		fs.BoolVar(&o.annotate, "matches", false, "Add the paths of the matched fields"+
+			" to the results in a _matches field")
+	}
+}
+
+// printer returns the printer of the output flags, or the usage exit
+// code for an unknown format.
+func (o *outputFlags) printer(fs *flag.FlagSet) (*Printer, int) {
+	p, err := NewPrinter(o.format, o.noColor)
+	if err != nil {
+		return nil, usageError(fs, err)
+	}
+	p.Annotate = o.annotate
+	return p, exitFound
+}
+
+// printPage prints a page of search results, and the cursor of the
+// next page to stderr.
+func printPage(p *Printer, page *jsondb.Page) int {
+	if err := PrintResults(p, page.Results, page.Matches); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailed
+	}
+	if page.NextCursor != "" {
+		fmt.Fprintf(os.Stderr, "Showing %d-%d of %d results, next page: -cursor %s\n",
+			page.Offset+1, page.Offset+len(page.Results), page.Total, page.NextCursor)
+	}
+	if len(page.Results) == 0 {
+		return exitNotFound
+	}
+	return exitFound
+}
@@ -1,135 +1,209 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/gusaki/jsonsearch/pkg/jsondb"
 )
 
-func main() {
+// Exit codes, like grep a search exits with 1 when nothing is found.
+const (
+	exitFound    = 0
+	exitNotFound = 1
+	exitUsage    = 2
+	exitLoad     = 3
+	exitFailed   = 4
+)
 
-	var dbfiles DBFiles
-	var indexKeys IndexBy
-	var keyRelns KeyRelations
-	var dbname string
-	var keyPath string
-	var value string
-	var interactive bool
-
-	flag.Var(&dbfiles, "dbfiles", "Comma separated list of filenames/filepaths")
-	flag.Var(
-		&indexKeys, "indexby", "Comma separated list of index keys."+
-			" In the form of <filename.json_key>."+
-			"\nExample: organizations._id,tickets.id")
-	keyRelns = make(KeyRelations, 0)
-	flag.Var(&keyRelns, "relationships", "Comma separated list of relationships\n"+
-		"with each relationship delimited with a colon."+
-		"\nExample: organizations._id:tickets.organization_id,users.organization_id:organizations._id")
-	flag.StringVar(&keyPath, "keypath", "", "Dot separated path to the JSON key")
-	flag.StringVar(&dbname, "searchdb", "", "Name of database to search")
-	flag.StringVar(&value, "searchvalue", "", "Search value")
-	flag.BoolVar(&interactive, "interactive", true, "Run in interactive mode")
-	flag.Usage = func() {
-		fmt.Println()
-		fmt.Println("-dbfiles value")
-		fmt.Println("\tComma separated list of filenames/filepaths")
-		fmt.Println("-indexby value")
-		fmt.Println("\tComma separated list of index keys. In the form of <filename.json_key>.")
-		fmt.Println("\t\tExample: organizations._id,tickets.id")
-		fmt.Println("-interactive")
-		fmt.Println("\tRun in interactive mode")
-		fmt.Println("-keypath string")
-		fmt.Println("\tDot separated path to the JSON key")
-		fmt.Println("-relationships value")
-		fmt.Println("\tComma separated list of relationships")
-		fmt.Println("\twith each relationship delimited with a colon.")
-		fmt.Println("\t\tExample: organizations._id:tickets.organization_id,users.organization_id:organizations._id")
-		fmt.Println("-searchdb string")
-		fmt.Println("\tName of database to search")
-		fmt.Println("-searchvalue string")
-		fmt.Println("\tSearch value")
-		fmt.Println()
-		fmt.Println("Usage examples:")
-		fmt.Println("Command line mode:")
-		fmt.Println("\tjsonsearch -dbfiles /home/u/org.json,/home/u/tickets.json,/home/u/users.json \\")
-		fmt.Println("\t-indexby org._id:tickets.id -relationships org._id:users.org_id \\")
-		fmt.Println("\t-searchdb org -keypath _id -value 101")
-		fmt.Println()
-		fmt.Println("Interactive Mode (also requires some initialization parameters)")
-		fmt.Println("\tjsonsearch -dbfiles /home/u/org.json,/home/u/tickets.json,/home/u/users.json \\")
-		fmt.Println("\t-indexby org._id:tickets.id -relationships org._id:users.org_id \\")
-		fmt.Println("\t-interactive")
-	}
-	flag.CommandLine.Usage = flag.Usage
-	flag.Parse()
+// command is a jsonsearch subcommand. setup registers the flags of the
+// command and returns the function running it with the remaining
+// arguments, which returns the process exit code.
+type command struct {
+	name     string
+	args     string
+	summary  string
+	examples []string
+	setup    func(fs *flag.FlagSet) func(args []string) int
+}
+
+// commands are listed in the help in this order.
+var commands []*command
 
-	if dbfiles == nil || len(dbfiles) == 0 {
-		fmt.Println("Missing required argument: -dbfiles")
-		flag.Usage()
-		os.Exit(1)
+func init() {
+	commands = []*command{
+		searchCommand,
+		queryCommand,
+		replCommand,
+		joinCommand,
+		indexCommand,
+		statsCommand,
+		schemaCommand,
+		checkCommand,
+		exportCommand,
+		snapshotCommand,
+		serveCommand,
 	}
+}
 
-	if !interactive {
-		if strings.TrimSpace(dbname) == "" || strings.TrimSpace(keyPath) == "" {
-			fmt.Println("Missing required argument(s): -keypath / -searchvalue")
-			flag.Usage()
-			os.Exit(1)
+func findCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
 		}
 	}
-	// process -dbfiles and load the database
-	jsonDb, err := jsondb.Load(dbfiles)
-	if err != nil {
-		log.Println("Program terminated with an error")
-		os.Exit(1)
+	return nil
+}
+
+// flagSet returns the flag set of the command with the generated help.
+func (c *command) flagSet() (*flag.FlagSet, func(args []string) int) {
+	fs := flag.NewFlagSet("jsonsearch "+c.name, flag.ContinueOnError)
+	run := c.setup(fs)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "%s\n\n", strings.TrimSpace("Usage: jsonsearch "+c.name+" [flags] "+c.args))
+		fmt.Fprintf(out, "%s\n\nFlags:\n", c.summary)
+		fs.PrintDefaults()
+		if len(c.examples) > 0 {
+			fmt.Fprintln(out, "\nExamples:")
+			for _, e := range c.examples {
+				fmt.Fprintf(out, "  %s\n", e)
+			}
+		}
 	}
+	return fs, run
+}
 
-	// process -indexby and create indexes
-	for _, key := range indexKeys {
-		li := strings.LastIndex(key, ".")
-		if li == -1 {
-			fmt.Println("Invalid format -indexby")
-			flag.Usage()
-			os.Exit(1)
+// run parses the arguments of the command and runs it.
+func (c *command) run(args []string) int {
+	fs, run := c.flagSet()
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitFound
 		}
-		dbname := key[0:li]
-		jsonkey := key[li+1:]
-		err = jsonDb.BuildIndex(dbname, jsonkey)
-		if err != nil {
-			log.Println("Indexing has failed. This will make searches slow")
+		return exitUsage
+	}
+	return run(fs.Args())
+}
+
+func usage(out io.Writer) {
+	fmt.Fprintln(out, "Usage: jsonsearch <command> [flags]")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-8s %s\n", c.name, strings.SplitN(c.summary, "\n", 2)[0])
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Run 'jsonsearch help <command>' for the flags of a command.")
+	fmt.Fprintln(out, "Without a command the flags are those of search when -searchdb")
+	fmt.Fprintln(out, "is given and of repl otherwise, -interactive runs repl.")
+}
+
+// usageError prints the error and the usage of the flag set and
+// returns the usage exit code.
+func usageError(fs *flag.FlagSet, a ...interface{}) int {
+	fmt.Fprintln(os.Stderr, a...)
+	fs.Usage()
+	return exitUsage
+}
+
+// usageErrors are the errors of searches and queries given invalid
+// arguments.
+var usageErrors = []error{
+	jsondb.ErrInvalidDatabase, jsondb.ErrInvalidAggregate, jsondb.ErrInvalidQuery,
+	jsondb.ErrInvalidSort, jsondb.ErrInvalidCursor, jsondb.ErrInvalidPage,
+	jsondb.ErrInvalidField, jsondb.ErrInvalidRelationship, jsondb.ErrInvalidJoin,
+	jsondb.ErrUnknownAlias, jsondb.ErrDuplicateAlias, jsondb.ErrAmbiguousJoin,
+	jsondb.ErrMissingRelationship, jsondb.ErrUnsupportedStore,
+}
+
+// searchExitCode returns the exit code of a search that failed with
+// err: not found, a usage error for invalid arguments, and failed for
+// the errors of a running search, such as a timeout or a database file
+// changed while it is open.
+func searchExitCode(err error) int {
+	if err == jsondb.ErrKeyValueNotFound {
+		return exitNotFound
+	}
+	for _, uerr := range usageErrors {
+		if errors.Is(err, uerr) {
+			return exitUsage
 		}
 	}
+	return exitFailed
+}
 
-	// process -relationships and create indexes
-	for _, reln := range keyRelns {
-		r := strings.Split(reln, ":")
-		for _, index := range r {
-			li := strings.LastIndex(index, ".")
-			if li == -1 {
-				fmt.Println("Invalid format -relationship")
-				flag.PrintDefaults()
-				os.Exit(1)
-			}
-			dbname := index[0:li]
-			jsonkey := index[li+1:]
-			err = jsonDb.BuildIndex(dbname, jsonkey)
-			if err != nil {
-				log.Printf("Indexing has failed for %s", index)
+// defaultCommand returns the command run when the arguments start with
+// a flag and its arguments: search when a database is searched and repl
+// otherwise. -interactive, which ran the repl before the commands, is
+// kept as an alias of repl and removed from the arguments.
+func defaultCommand(args []string) (*command, []string) {
+	interactive := false
+	search := false
+	rest := make([]string, 0, len(args))
+	for _, a := range args {
+		if !strings.HasPrefix(a, "-") {
+			rest = append(rest, a)
+			continue
+		}
+		kv := strings.SplitN(strings.TrimLeft(a, "-"), "=", 2)
+		switch kv[0] {
+		case "interactive":
+			interactive = true
+			if len(kv) == 2 {
+				interactive, _ = strconv.ParseBool(kv[1])
 			}
+			continue
+		case "searchdb":
+			search = true
 		}
+		rest = append(rest, a)
 	}
+	if search && !interactive {
+		return searchCommand, rest
+	}
+	return replCommand, rest
+}
+
+func main() {
 
-	if interactive {
-		runInteractive(jsonDb, keyRelns)
-		os.Exit(0)
+	args := os.Args[1:]
+	if len(args) == 0 {
+		usage(os.Stderr)
+		os.Exit(exitUsage)
+	}
+
+	name := args[0]
+	switch {
+	case name == "help" || name == "-h" || name == "-help" || name == "--help":
+		if len(args) > 1 {
+			if c := findCommand(args[1]); c != nil {
+				fs, _ := c.flagSet()
+				fs.SetOutput(os.Stdout)
+				fs.Usage()
+				os.Exit(exitFound)
+			}
+			fmt.Fprintf(os.Stderr, "Unknown command %q\n", args[1])
+			usage(os.Stderr)
+			os.Exit(exitUsage)
+		}
+		usage(os.Stdout)
+		os.Exit(exitFound)
+	case strings.HasPrefix(name, "-"):
+		c, args := defaultCommand(args)
+		os.Exit(c.run(args))
 	}
 
-	results, err := jsonDb.Search(dbname, keyPath, value, keyRelns)
-	if err != nil {
-		fmt.Println(err)
+	c := findCommand(name)
+	if c == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n", name)
+		usage(os.Stderr)
+		os.Exit(exitUsage)
 	}
-	PrintResults(results)
+	os.Exit(c.run(args[1:]))
 }
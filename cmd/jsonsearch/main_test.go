@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"testing"
+
+	"github.com/gusaki/jsonsearch/pkg/jsondb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		command string
+		rest    []string
+	}{
+		{"Search", []string{"-dbfiles", "users.json", "-searchdb", "users"}, "search",
+			[]string{"-dbfiles", "users.json", "-searchdb", "users"}},
+		{"Search with value", []string{"--searchdb=users"}, "search", []string{"--searchdb=users"}},
+		{"Repl", []string{"-dbfiles", "users.json"}, "repl", []string{"-dbfiles", "users.json"}},
+		{"Interactive alias", []string{"-dbfiles", "users.json", "-interactive"}, "repl",
+			[]string{"-dbfiles", "users.json"}},
+		{"Interactive alias with value", []string{"-interactive=true", "-dbfiles", "users.json"}, "repl",
+			[]string{"-dbfiles", "users.json"}},
+		{"Interactive disabled", []string{"-interactive=false", "-searchdb", "users"}, "search",
+			[]string{"-searchdb", "users"}},
+	}
+	for _, test := range tests {
+		log.Println("Test: ", test.name)
+		c, rest := defaultCommand(test.args)
+		assert.Equal(t, c.name, test.command)
+		assert.Equal(t, rest, test.rest)
+	}
+}
+
+func TestSearchExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"Not found", jsondb.ErrKeyValueNotFound, exitNotFound},
+		{"Unknown database", jsondb.ErrInvalidDatabase, exitUsage},
+		{"Invalid aggregate", fmt.Errorf("%w: sum", jsondb.ErrInvalidAggregate), exitUsage},
+		{"Invalid query", fmt.Errorf("%w: unexpected \"x\"", jsondb.ErrInvalidQuery), exitUsage},
+		{"Timeout", context.DeadlineExceeded, exitFailed},
+		{"Cancelled", context.Canceled, exitFailed},
+		{"Database file changed", fmt.Errorf("%w: users.json", jsondb.ErrStoreChanged), exitFailed},
+	}
+	for _, test := range tests {
+		log.Println("Test: ", test.name)
+		assert.Equal(t, searchExitCode(test.err), test.code)
+	}
+}
+
+func TestTimeoutExitCode(t *testing.T) {
+	dbfiles := "../../pkg/jsondb/testdata/organizations.json,../../pkg/jsondb/testdata/tickets.json"
+	tests := []struct {
+		name    string
+		command *command
+		args    []string
+		code    int
+	}{
+		{"Search", searchCommand,
+			[]string{"-searchdb", "tickets", "-keypath", "status", "-searchvalue", "open"}, exitFailed},
+		{"Streamed search", searchCommand,
+			[]string{"-searchdb", "tickets", "-keypath", "status", "-searchvalue", "open", "-stream"}, exitFailed},
+		{"Aggregation", searchCommand,
+			[]string{"-searchdb", "tickets", "-groupby", "status", "-agg", "count"}, exitFailed},
+		{"Query", queryCommand, []string{"from tickets where status = open"}, exitFailed},
+		{"Join", joinCommand, []string{"-from", "tickets", "-join", "organizations",
+			"-relationships", "organizations._id:tickets.organization_id"}, exitFailed},
+		{"Export", exportCommand, []string{"-searchdb", "tickets", "-keypath", "status", "-searchvalue", "open"}, exitFailed},
+		{"Export of every record", exportCommand, []string{"-searchdb", "tickets"}, exitFailed},
+		{"Usage error", queryCommand, []string{"from nothing"}, exitUsage},
+	}
+	for _, test := range tests {
+		log.Println("Test: ", test.name)
+		args := append([]string{"-dbfiles", dbfiles, "-timeout", "1ns"}, test.args...)
+		assert.Equal(t, test.command.run(args), test.code)
+	}
+}
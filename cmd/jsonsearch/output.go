@@ -0,0 +1,383 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/TylerBrock/colorjson"
+	"github.com/gusaki/jsonsearch/pkg/jsondb"
+	"github.com/mattn/go-isatty"
+	"gopkg.in/yaml.v3"
+)
+
+var errUnknownFormat = errors.New("unknown output format")
+
+// outputFormats are the values accepted by -output.
+var outputFormats = []string{"json", "ndjson", "csv", "tsv", "table", "yaml"}
+
+// Printer writes search results, records, or join and aggregate
+// results, rows of columns, in one of the output formats. Object keys
+// and record columns are sorted so the output is stable.
+type Printer struct {
+	// Annotate adds the paths of the matched fields to search
+	// results in a _matches field or column.
+	Annotate bool
+
+	w      io.Writer
+	format string
+	color  bool
+}
+
+// NewPrinter returns a printer writing to stdout. An empty format
+// prints records as JSON and rows as a table. JSON is colorized when
+// stdout is a terminal unless noColor is set or the NO_COLOR
+// environment variable is present.
+func NewPrinter(format string, noColor bool) (*Printer, error) {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format != "" {
+		found := false
+		for _, f := range outputFormats {
+			if f == format {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w %q, expected one of %s",
+				errUnknownFormat, format, strings.Join(outputFormats, ", "))
+		}
+	}
+	_, noColorEnv := os.LookupEnv("NO_COLOR")
+	tty := isatty.IsTerminal(os.Stdout.Fd()) || isatty.IsCygwinTerminal(os.Stdout.Fd())
+	return &Printer{
+		w:      os.Stdout,
+		format: format,
+		color:  tty && !noColor && !noColorEnv,
+	}, nil
+}
+
+// normalize converts values to the types of unmarshalled JSON.
+func normalize(v interface{}) interface{} {
+	switch val := v.(type) {
+	case int:
+		return float64(val)
+	case []interface{}:
+		list := make([]interface{}, len(val))
+		for n, e := range val {
+			list[n] = normalize(e)
+		}
+		return list
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(val))
+		for k, e := range val {
+			obj[k] = normalize(e)
+		}
+		return obj
+	}
+	return v
+}
+
+// formatCell formats a value as text. Lists and objects are
+// formatted as JSON.
+func formatCell(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(val)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// recordColumns returns the sorted top level keys of the records.
+// Records that are not objects are shown in a value column.
+func recordColumns(records []interface{}) ([]string, [][]interface{}) {
+	keys := make(map[string]bool)
+	for _, r := range records {
+		if obj, ok := r.(map[string]interface{}); ok {
+			for k := range obj {
+				keys[k] = true
+			}
+			continue
+		}
+		keys["value"] = true
+	}
+	columns := make([]string, 0, len(keys))
+	for k := range keys {
+		columns = append(columns, k)
+	}
+	sort.Strings(columns)
+	rows := make([][]interface{}, len(records))
+	for n, r := range records {
+		rows[n] = make([]interface{}, len(columns))
+		obj, ok := r.(map[string]interface{})
+		for c, k := range columns {
+			switch {
+			case ok:
+				rows[n][c] = obj[k]
+			case k == "value":
+				rows[n][c] = r
+			}
+		}
+	}
+	return columns, rows
+}
+
+// Records prints search results.
+func (p *Printer) Records(records []interface{}) error {
+	switch p.format {
+	case "", "json":
+		return p.json(records)
+	case "ndjson":
+		return p.ndjson(records)
+	case "yaml":
+		return p.yaml(records)
+	}
+	columns, rows := recordColumns(records)
+	return p.Rows(columns, rows)
+}
+
+// Streams reports whether the format prints each record on its own,
+// so that records can be printed as they are found. The columns of the
+// row formats depend on all the records.
+func (p *Printer) Streams() bool {
+	switch p.format {
+	case "", "json", "ndjson", "yaml":
+		return true
+	}
+	return false
+}
+
+// Matches prints search results along with the paths of their matched
+// fields. Colorized JSON highlights the matched values.
+func (p *Printer) Matches(records []interface{}, matches [][]string) error {
+	if len(matches) != len(records) {
+		return p.Records(records)
+	}
+	if p.Annotate {
+		annotated := make([]interface{}, len(records))
+		for n, r := range records {
+			annotated[n] = jsondb.Annotate(r, matches[n])
+		}
+		records = annotated
+	}
+	if p.color && (p.format == "" || p.format == "json") {
+		for n, r := range records {
+			fmt.Fprintln(p.w, newHighlighter(matches[n]).Marshal(normalize(r)))
+		}
+		return nil
+	}
+	return p.Records(records)
+}
+
+// Rows prints rows of columns. JSON and YAML formats print each row as
+// an object keyed by the column names.
+func (p *Printer) Rows(columns []string, rows [][]interface{}) error {
+	switch p.format {
+	case "csv":
+		return p.csv(columns, rows)
+	case "tsv":
+		return p.tsv(columns, rows)
+	case "", "table":
+		return p.table(columns, rows)
+	}
+	records := make([]interface{}, len(rows))
+	for n, row := range rows {
+		obj := make(map[string]interface{}, len(columns))
+		for c, k := range columns {
+			obj[k] = row[c]
+		}
+		records[n] = obj
+	}
+	return p.Records(records)
+}
+
+func (p *Printer) json(records []interface{}) error {
+	if p.color {
+		f := colorjson.NewFormatter()
+		f.Indent = 4
+		for _, r := range records {
+			s, err := f.Marshal(normalize(r))
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(p.w, string(s))
+		}
+		return nil
+	}
+	enc := json.NewEncoder(p.w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "    ")
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Printer) ndjson(records []interface{}) error {
+	enc := json.NewEncoder(p.w)
+	enc.SetEscapeHTML(false)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Printer) csv(columns []string, rows [][]interface{}) error {
+	w := csv.NewWriter(p.w)
+	if err := w.Write(columns); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for n, v := range row {
+			cells[n] = formatCell(v)
+		}
+		if err := w.Write(cells); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// tsv writes tab separated values, tabs and newlines in values are
+// replaced with spaces.
+func (p *Printer) tsv(columns []string, rows [][]interface{}) error {
+	clean := strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+	fmt.Fprintln(p.w, strings.Join(columns, "\t"))
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for n, v := range row {
+			cells[n] = clean.Replace(formatCell(v))
+		}
+		fmt.Fprintln(p.w, strings.Join(cells, "\t"))
+	}
+	return nil
+}
+
+func (p *Printer) table(columns []string, rows [][]interface{}) error {
+	clean := strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+	w := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(columns, "\t"))
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for n, v := range row {
+			cells[n] = clean.Replace(formatCell(v))
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+	return w.Flush()
+}
+
+// yaml writes each record as a YAML document.
+func (p *Printer) yaml(records []interface{}) error {
+	var b strings.Builder
+	for _, r := range records {
+		b.WriteString("---\n")
+		enc := yaml.NewEncoder(&b)
+		enc.SetIndent(2)
+		if err := enc.Encode(yamlNode(normalize(r))); err != nil {
+			return err
+		}
+		if err := enc.Close(); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(p.w, b.String())
+	return err
+}
+
+// yamlNode converts a value to a YAML node. Strings are always double
+// quoted so that no YAML parser reads them as another type, e.g. a
+// timestamp or a boolean, keys are only quoted when they are not plain
+// words.
+func yamlNode(v interface{}) *yaml.Node {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		n := &yaml.Node{Kind: yaml.MappingNode}
+		if len(val) == 0 {
+			n.Style = yaml.FlowStyle
+		}
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			key := &yaml.Node{Kind: yaml.ScalarNode, Value: k}
+			if !yamlPlainKey(k) {
+				key.Style = yaml.DoubleQuotedStyle
+			}
+			n.Content = append(n.Content, key, yamlNode(val[k]))
+		}
+		return n
+	case []interface{}:
+		n := &yaml.Node{Kind: yaml.SequenceNode}
+		if len(val) == 0 {
+			n.Style = yaml.FlowStyle
+		}
+		for _, e := range val {
+			n.Content = append(n.Content, yamlNode(e))
+		}
+		return n
+	case nil:
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: "null"}
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: strconv.FormatBool(val)}
+	case float64:
+		switch {
+		case math.IsNaN(val):
+			return &yaml.Node{Kind: yaml.ScalarNode, Value: ".nan"}
+		case math.IsInf(val, 1):
+			return &yaml.Node{Kind: yaml.ScalarNode, Value: ".inf"}
+		case math.IsInf(val, -1):
+			return &yaml.Node{Kind: yaml.ScalarNode, Value: "-.inf"}
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: strconv.FormatFloat(val, 'f', -1, 64)}
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Style: yaml.DoubleQuotedStyle, Value: fmt.Sprint(v)}
+}
+
+// yamlPlainKey reports whether a key is written unquoted: a word of
+// letters, digits and underscores starting with a letter or an
+// underscore that no YAML version reads as a boolean or null.
+func yamlPlainKey(k string) bool {
+	if k == "" {
+		return false
+	}
+	switch strings.ToLower(k) {
+	case "y", "n", "yes", "no", "on", "off", "true", "false", "null":
+		return false
+	}
+	for n, r := range k {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case n > 0 && r >= '0' && r <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
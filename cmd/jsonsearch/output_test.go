@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+// testPrinter returns a printer writing to a buffer without color.
+func testPrinter(format string) (*Printer, *bytes.Buffer) {
+	var b bytes.Buffer
+	return &Printer{w: &b, format: format}, &b
+}
+
+var testRecords = []interface{}{
+	map[string]interface{}{"_id": 1.0, "name": "Francisca", "tags": []interface{}{"a", "b"}},
+	map[string]interface{}{"_id": 2, "name": "Rose\tNewton", "active": true, "org": nil},
+}
+
+func TestPrinterRecords(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		expected string
+	}{
+		{"JSON", "json", `{
+    "_id": 1,
+    "name": "Francisca",
+    "tags": [
+        "a",
+        "b"
+    ]
+}
+{
+    "_id": 2,
+    "active": true,
+    "name": "Rose\tNewton",
+    "org": null
+}
+`},
+		{"Default JSON", "", `{
+    "_id": 1,
+    "name": "Francisca",
+    "tags": [
+        "a",
+        "b"
+    ]
+}
+{
+    "_id": 2,
+    "active": true,
+    "name": "Rose\tNewton",
+    "org": null
+}
+`},
+		{"NDJSON", "ndjson", `{"_id":1,"name":"Francisca","tags":["a","b"]}
+{"_id":2,"active":true,"name":"Rose\tNewton","org":null}
+`},
+		{"CSV", "csv", "_id,active,name,org,tags\n" +
+			"1,,Francisca,,\"[\"\"a\"\",\"\"b\"\"]\"\n" +
+			"2,true,Rose\tNewton,,\n"},
+		{"TSV", "tsv", "_id\tactive\tname\torg\ttags\n" +
+			"1\t\tFrancisca\t\t[\"a\",\"b\"]\n" +
+			"2\ttrue\tRose Newton\t\t\n"},
+		{"Table", "table", "_id  active  name         org  tags\n" +
+			"1            Francisca         [\"a\",\"b\"]\n" +
+			"2    true    Rose Newton       \n"},
+		{"YAML", "yaml", `---
+_id: 1
+name: "Francisca"
+tags:
+- "a"
+- "b"
+---
+_id: 2
+active: true
+name: "Rose\tNewton"
+org: null
+`},
+	}
+	for _, test := range tests {
+		log.Println("Test: ", test.name)
+		p, b := testPrinter(test.format)
+		assert.Equal(t, p.Records(testRecords), nil)
+		assert.Equal(t, b.String(), test.expected)
+	}
+}
+
+func TestPrinterRows(t *testing.T) {
+	columns := []string{"role", "count"}
+	rows := [][]interface{}{{"admin", 2.0}, {"end-user", 10}}
+	tests := []struct {
+		name     string
+		format   string
+		expected string
+	}{
+		{"Default table", "", "role      count\nadmin     2\nend-user  10\n"},
+		{"CSV", "csv", "role,count\nadmin,2\nend-user,10\n"},
+		{"TSV", "tsv", "role\tcount\nadmin\t2\nend-user\t10\n"},
+		{"JSON", "json", "{\n    \"count\": 2,\n    \"role\": \"admin\"\n}\n{\n    \"count\": 10,\n    \"role\": \"end-user\"\n}\n"},
+		{"NDJSON", "ndjson", "{\"count\":2,\"role\":\"admin\"}\n{\"count\":10,\"role\":\"end-user\"}\n"},
+		{"YAML", "yaml", "---\ncount: 2\nrole: \"admin\"\n---\ncount: 10\nrole: \"end-user\"\n"},
+	}
+	for _, test := range tests {
+		log.Println("Test: ", test.name)
+		p, b := testPrinter(test.format)
+		assert.Equal(t, p.Rows(columns, rows), nil)
+		assert.Equal(t, b.String(), test.expected)
+	}
+}
+
+func TestPrinterYAML(t *testing.T) {
+	// strings read as another type by YAML parsers when unquoted
+	values := []interface{}{
+		"2016-04-15T05:19:46 -10:00", "2016-04-15", "0x1F", "0o17", "1e3", "1_000",
+		"12:30:00", "y", "n", "yes", "No", "on", "OFF", "true", "null", "~", "NaN",
+		".inf", "<<", "=", "-", "", " padded ", "a: b", "#comment", "multi\nline",
+		"quote\"s", "back\\slash", "'single'", "*alias", "&anchor", "!tag", "%", "@", "`",
+	}
+	record := map[string]interface{}{
+		"created_at": values[0],
+		"values":     values,
+		"my key":     map[string]interface{}{},
+		"2016":       []interface{}{},
+		"nested":     []interface{}{map[string]interface{}{"a": nil, "b": false, "c": 1.5, "d": 2.0}},
+	}
+	for _, v := range values {
+		record[v.(string)] = v
+	}
+	p, b := testPrinter("yaml")
+	assert.Equal(t, p.Records([]interface{}{record, "scalar"}), nil)
+	out := b.String()
+	assert.Equal(t, strings.Contains(out, "\ncreated_at: \"2016-04-15T05:19:46 -10:00\"\n"), true)
+	assert.Equal(t, strings.Contains(out, "\n\"y\": \"y\"\n"), true)
+	assert.Equal(t, strings.Contains(out, "\n\"my key\": {}\n"), true)
+
+	// the documents decode to the records
+	dec := yaml.NewDecoder(strings.NewReader(out))
+	var decoded interface{}
+	assert.Equal(t, dec.Decode(&decoded), nil)
+	record["nested"] = []interface{}{map[string]interface{}{"a": nil, "b": false, "c": 1.5, "d": 2}}
+	assert.Equal(t, decoded, record)
+	assert.Equal(t, dec.Decode(&decoded), nil)
+	assert.Equal(t, decoded, "scalar")
+}
+
+func TestPrinterMatches(t *testing.T) {
+	records := []interface{}{map[string]interface{}{"_id": 1.0, "name": "a"}}
+	matches := [][]string{{"_id"}}
+
+	p, b := testPrinter("ndjson")
+	p.Annotate = true
+	assert.Equal(t, p.Matches(records, matches), nil)
+	assert.Equal(t, b.String(), "{\"_id\":1,\"_matches\":[\"_id\"],\"name\":\"a\"}\n")
+
+	p, b = testPrinter("csv")
+	p.Annotate = true
+	assert.Equal(t, p.Matches(records, matches), nil)
+	assert.Equal(t, b.String(), "_id,_matches,name\n1,\"[\"\"_id\"\"]\",a\n")
+
+	// without matches for each record the records are printed as is
+	p, b = testPrinter("ndjson")
+	p.Annotate = true
+	assert.Equal(t, p.Matches(records, nil), nil)
+	assert.Equal(t, b.String(), "{\"_id\":1,\"name\":\"a\"}\n")
+}
+
+func TestNewPrinter(t *testing.T) {
+	for _, format := range append(outputFormats, "", " YAML ") {
+		_, err := NewPrinter(format, true)
+		assert.Equal(t, err, nil)
+	}
+	_, err := NewPrinter("xml", true)
+	assert.Equal(t, errors.Is(err, errUnknownFormat), true)
+
+	p, _ := testPrinter("csv")
+	assert.Equal(t, p.Streams(), false)
+	p, _ = testPrinter("yaml")
+	assert.Equal(t, p.Streams(), true)
+}
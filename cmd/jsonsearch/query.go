@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gusaki/jsonsearch/pkg/jsondb"
+)
+
+var queryCommand = &command{
+	name: "query",
+	args: "<query>",
+	summary: "Run a search, join or aggregation written in the query language.\n" +
+		"A query is in the form of:\n" +
+		"  from <db> [where <keypath> = <value>] [[left] join <db> [as <alias>] [on <relationship>]]\n" +
+		"  [select <fields or columns>] [group by <keypaths>] [agg <aggregates>]\n" +
+		"  [sort by <keys>] [limit <n>] [offset <n>]\n" +
+		"Values holding keywords or quotes are quoted.",
+	examples: []string{
+		"jsonsearch query -dbfiles tickets.json 'from tickets where status = open sort by -priority limit 5'",
+		"jsonsearch query -dbfiles org.json,tickets.json -relationships org._id:tickets.org_id \\\n" +
+			"      'from tickets join org select tickets.subject, org.name as organization'",
+		"jsonsearch query -dbfiles tickets.json 'from tickets group by status agg count'",
+	},
+	setup: func(fs *flag.FlagSet) func(args []string) int {
+		var l loader
+		var o outputFlags
+		var t timeoutFlag
+
+		l.register(fs)
+		t.register(fs)
+		o.register(fs, true)
+
+		return func(args []string) int {
+			if len(args) == 0 {
+				return usageError(fs, "Missing required argument: <query>")
+			}
+			q, err := jsondb.ParseQuery(strings.Join(args, " "))
+			if err != nil {
+				return usageError(fs, err)
+			}
+			printer, code := o.printer(fs)
+			if printer == nil {
+				return code
+			}
+			jsonDb, code := l.load(fs)
+			if jsonDb == nil {
+				return code
+			}
+			defer jsonDb.Close()
+			q.Relations = l.relations
+			ctx, cancel := t.context(context.Background())
+			defer cancel()
+			return runQuery(ctx, jsonDb, q, printer, &t)
+		}
+	},
+}
+
+// runQuery prints the result of the query and returns the process
+// exit code.
+func runQuery(ctx context.Context, jsonDb *jsondb.JsonDB, q jsondb.Query, p *Printer, t *timeoutFlag) int {
+	result, err := jsonDb.QueryContext(ctx, q)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, t.error(err))
+		return searchExitCode(err)
+	}
+	if result.Page != nil {
+		return printPage(p, result.Page)
+	}
+	return printRows(p, result.Columns, result.Rows)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gusaki/jsonsearch/pkg/jsondb"
+)
+
+var schemaCommand = &command{
+	name: "schema",
+	summary: "Infer the schema of the databases from their records.\n" +
+		"Lists the key paths with the types of their values, the ratio of records holding\n" +
+		"them, the number of distinct values and samples.",
+	examples: []string{
+		"jsonsearch schema -dbfiles tickets.json",
+		"jsonsearch schema -dbfiles org.json,tickets.json -searchdb tickets -output json",
+	},
+	setup: func(fs *flag.FlagSet) func(args []string) int {
+		var l loader
+		var o outputFlags
+		var dbname string
+
+		l.register(fs)
+		fs.StringVar(&dbname, "searchdb", "", "Name of database, all the databases when empty")
+		o.register(fs, false)
+
+		return func(args []string) int {
+			if len(args) > 0 {
+				return usageError(fs, "Unexpected arguments:", strings.Join(args, " "))
+			}
+			printer, code := o.printer(fs)
+			if printer == nil {
+				return code
+			}
+			jsonDb, code := l.load(fs)
+			if jsonDb == nil {
+				return code
+			}
+			defer jsonDb.Close()
+			names := jsonDb.Names()
+			if dbname != "" {
+				names = []string{dbname}
+			}
+			var rows [][]interface{}
+			for _, name := range names {
+				s, err := jsonDb.Schema(name)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					return searchExitCode(err)
+				}
+				rows = append(rows, schemaRows(s)...)
+			}
+			columns := []string{"db", "path", "type", "types", "items", "presence", "nullable", "distinct", "samples"}
+			return printRows(printer, columns, rows)
+		}
+	},
+}
+
+// typeCounts returns the type histogram as a JSON object, or nil when
+// empty.
+func typeCounts(counts map[string]int) interface{} {
+	if len(counts) == 0 {
+		return nil
+	}
+	obj := make(map[string]interface{}, len(counts))
+	for t, n := range counts {
+		obj[t] = float64(n)
+	}
+	return obj
+}
+
+// schemaRows returns a row per field of the schema.
+func schemaRows(s *jsondb.Schema) [][]interface{} {
+	rows := make([][]interface{}, 0, len(s.Fields))
+	for _, f := range s.Fields {
+		rows = append(rows, []interface{}{
+			s.DB, f.Path, f.Type(), typeCounts(f.Types), typeCounts(f.Items),
+			f.Presence, f.Nullable, float64(f.Distinct), f.Samples,
+		})
+	}
+	return rows
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gusaki/jsonsearch/pkg/jsondb"
+)
+
+var searchCommand = &command{
+	name: "search",
+	summary: "Search a database for the records holding a value at a key path.\n" +
+		"With -groupby or -agg the matching records are aggregated instead.",
+	examples: []string{
+		"jsonsearch search -dbfiles org.json,tickets.json,users.json \\\n" +
+			"      -indexby org._id,tickets._id -relationships org._id:users.org_id \\\n" +
+			"      -searchdb org -keypath _id -searchvalue 101",
+		"jsonsearch search -dbfiles tickets.json -searchdb tickets \\\n" +
+			"      -keypath status -searchvalue open -groupby org_id -agg count",
+	},
+	setup: func(fs *flag.FlagSet) func(args []string) int {
+		var l loader
+		var o outputFlags
+		var t timeoutFlag
+		var dbname, keyPath, value, cursor string
+		var groupBy GroupBy
+		var aggs Aggregates
+		var sortKeys SortKeys
+		var limit, offset int
+		var fields Fields
+		var stream bool
+
+		l.register(fs)
+		fs.StringVar(&dbname, "searchdb", "", "Name of database to search")
+		fs.StringVar(&keyPath, "keypath", "", "Dot separated path to the JSON key")
+		fs.StringVar(&value, "searchvalue", "", "Search value")
+		fs.Var(&sortKeys, "sort", "Comma separated list of key paths to sort the results by."+
+			"\nPrefix a key path with - to sort in descending order. Example: -priority,created_at")
+		fs.IntVar(&limit, "limit", 0, "Maximum number of results")
+		fs.IntVar(&offset, "offset", 0, "Number of results to skip")
+		fs.StringVar(&cursor, "cursor", "", "Cursor of the next page of results")
+		fs.BoolVar(&stream, "stream", false, "Print the results as they are found and stop after -limit results."+
+			"\nCannot be combined with -sort, -offset, -cursor or aggregates")
+		fs.Var(&fields, "fields", "Comma separated list of fields of the results to show."+
+			"\nIn the form of <keypath>, <keypath> as <name>, -<keypath> to exclude"+
+			"\nor <name>=<func>(<keypath>) with func one of len, exists, upper, lower, type."+
+			"\nExample: _id,name,url as link,tag_count=len(tags)")
+		fs.Var(&groupBy, "groupby", "Comma separated list of key paths to group -searchdb by")
+		fs.Var(&aggs, "agg", "Comma separated list of aggregates in the form of <func>[:<keypath>] [as <name>]."+
+			"\nFunctions: count, distinct, sum, avg, min, max. Example: count as n,distinct:tags")
+		t.register(fs)
+		o.register(fs, true)
+
+		return func(args []string) int {
+			if len(args) > 0 {
+				return usageError(fs, "Unexpected arguments:", strings.Join(args, " "))
+			}
+			if strings.TrimSpace(dbname) == "" {
+				return usageError(fs, "Missing required argument: -searchdb")
+			}
+			aggregate := len(groupBy) > 0 || len(aggs) > 0
+			if !aggregate && strings.TrimSpace(keyPath) == "" {
+				return usageError(fs, "Missing required argument(s): -keypath / -searchvalue")
+			}
+			if stream && (aggregate || len(sortKeys) > 0 || offset != 0 || cursor != "") {
+				return usageError(fs, "-stream cannot be combined with -sort, -offset, -cursor, -groupby or -agg")
+			}
+			printer, code := o.printer(fs)
+			if printer == nil {
+				return code
+			}
+			if stream && !printer.Streams() {
+				return usageError(fs, "-stream requires the json, ndjson or yaml output")
+			}
+			jsonDb, code := l.load(fs)
+			if jsonDb == nil {
+				return code
+			}
+			defer jsonDb.Close()
+
+			ctx, cancel := t.context(context.Background())
+			defer cancel()
+			if aggregate {
+				return runAggregate(ctx, jsonDb, jsondb.AggregateQuery{
+					DB:         dbname,
+					GroupBy:    groupBy,
+					Aggregates: aggs,
+					Key:        keyPath,
+					Value:      value,
+				}, printer, &t)
+			}
+
+			if stream {
+				return runStream(ctx, jsonDb, dbname, keyPath, value, l.relations, limit, fields.projection, printer, &t)
+			}
+
+			page, err := jsonDb.SearchPageContext(ctx, dbname, keyPath, value, l.relations, jsondb.SearchOptions{
+				Sort:   sortKeys,
+				Limit:  limit,
+				Offset: offset,
+				Cursor: cursor,
+				Fields: fields.projection,
+				// matched fields are highlighted in colorized JSON
+				Matches: true,
+			})
+			if err != nil {
+				fmt.Fprintln(os.Stderr, t.error(err))
+				return searchExitCode(err)
+			}
+			return printPage(printer, page)
+		}
+	},
+}
+
+// runStream prints the results of a search as they are found, up to
+// limit results when limit is not zero.
+func runStream(ctx context.Context, jsonDb *jsondb.JsonDB, dbname, key, value string, relations []string,
+	limit int, fields *jsondb.Projection, p *Printer, t *timeoutFlag) int {
+
+	n := 0
+	var printErr error
+	err := jsonDb.SearchEachContext(ctx, dbname, key, value, relations, func(r jsondb.Result) bool {
+		matches := fields.ApplyPaths(jsondb.MatchPaths(r.Key, value, r.Record))
+		rec := fields.Apply(r.Record)
+		if printErr = p.Matches([]interface{}{rec}, [][]string{matches}); printErr != nil {
+			return false
+		}
+		n++
+		return limit == 0 || n < limit
+	})
+	if printErr != nil {
+		fmt.Fprintln(os.Stderr, printErr)
+		return exitFailed
+	}
+	if err != nil {
+		if err == jsondb.ErrKeyValueNotFound {
+			fmt.Fprintln(os.Stderr, "Data not found")
+		} else {
+			fmt.Fprintln(os.Stderr, t.error(err))
+		}
+		return searchExitCode(err)
+	}
+	return exitFound
+}
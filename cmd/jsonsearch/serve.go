@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/gusaki/jsonsearch/pkg/jsondb"
+	"github.com/gusaki/jsonsearch/pkg/server"
+)
+
+// shutdownTimeout bounds the wait for the requests in flight on
+// interrupt.
+const shutdownTimeout = 5 * time.Second
+
+var serveCommand = &command{
+	name: "serve",
+	summary: "Load the databases once and serve them over HTTP with a JSON REST API.\n" +
+		"Endpoints:\n" +
+		"  GET  /dbs                          the loaded databases\n" +
+		"  GET  /dbs/{db}/search?key=&value=  a page of search results, with sort, limit,\n" +
+		"                                     offset, cursor, fields and matches parameters\n" +
+		"  GET  /dbs/{db}/schema              the inferred schema of the database\n" +
+		"  POST /query                        a query of the query language, {\"query\": \"...\"}\n" +
+		"  POST /graphql                      a GraphQL query, each database is a type and a query\n" +
+		"                                     field taking its indexed keys as arguments,\n" +
+		"                                     relationships are fields of the related records",
+	examples: []string{
+		"jsonsearch serve -dbfiles org.json,tickets.json -relationships org._id:tickets.org_id -addr :8080",
+		"curl 'localhost:8080/dbs/tickets/search?key=status&value=open&limit=5'",
+		"curl -d '{\"query\": \"{ org(_id: \\\"101\\\") { name tickets { subject } } }\"}' localhost:8080/graphql",
+	},
+	setup: func(fs *flag.FlagSet) func(args []string) int {
+		var l loader
+		var w watchFlag
+		var addr string
+
+		l.register(fs)
+		fs.StringVar(&addr, "addr", "localhost:8080", "Address to listen on")
+		w.register(fs)
+
+		return func(args []string) int {
+			if len(args) > 0 {
+				return usageError(fs, "Unexpected arguments:", strings.Join(args, " "))
+			}
+			if code := w.check(fs, &l); code != 0 {
+				return code
+			}
+			jsonDb, code := l.load(fs)
+			if jsonDb == nil {
+				return code
+			}
+			defer jsonDb.Close()
+			handler := server.New(jsonDb, l.relations)
+			srv := &http.Server{Addr: addr, Handler: handler}
+
+			// requests in flight keep the databases they started with
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			w.start(ctx, jsonDb, func(e jsondb.ReloadEvent) {
+				log.Println(reloadMessage(e))
+				if e.Err == nil {
+					handler.Refresh()
+				}
+			})
+
+			// shut down gracefully on interrupt
+			done := make(chan struct{})
+			go func() {
+				sig := make(chan os.Signal, 1)
+				signal.Notify(sig, os.Interrupt)
+				<-sig
+				ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+				defer cancel()
+				srv.Shutdown(ctx)
+				close(done)
+			}()
+
+			log.Printf("Serving %s on http://%s", strings.Join(jsonDb.Names(), ", "), addr)
+			if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+				log.Println("Program terminated with an error:", err)
+				return exitLoad
+			}
+			<-done
+			return exitFound
+		}
+	},
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var snapshotCommand = &command{
+	name: "snapshot",
+	summary: "Save the databases, their indexes and relationships to a snapshot file.\n" +
+		"-snapshot loads the snapshot instead of parsing the JSON files and building the indexes.",
+	examples: []string{
+		"jsonsearch snapshot -dbfiles org.json,tickets.json -indexby org._id \\\n" +
+			"      -relationships org._id:tickets.org_id -out data.jsdb",
+		"jsonsearch -snapshot data.jsdb",
+	},
+	setup: func(fs *flag.FlagSet) func(args []string) int {
+		var l loader
+		var out string
+
+		l.register(fs)
+		fs.StringVar(&out, "out", "", "Snapshot file to write")
+
+		return func(args []string) int {
+			if len(args) > 0 {
+				return usageError(fs, "Unexpected arguments:", strings.Join(args, " "))
+			}
+			if strings.TrimSpace(out) == "" {
+				return usageError(fs, "Missing required argument: -out")
+			}
+			jsonDb, code := l.load(fs)
+			if jsonDb == nil {
+				return code
+			}
+			defer jsonDb.Close()
+
+			// write a temporary file renamed over -out so that a
+			// failed write leaves the previous snapshot
+			f, err := ioutil.TempFile(filepath.Dir(out), filepath.Base(out)+".*")
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return exitFailed
+			}
+			err = f.Chmod(0644)
+			if err == nil {
+				err = jsonDb.Save(f)
+			}
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err == nil {
+				err = os.Rename(f.Name(), out)
+			}
+			if err != nil {
+				os.Remove(f.Name())
+				fmt.Fprintln(os.Stderr, err)
+				return exitFailed
+			}
+			fmt.Fprintf(os.Stderr, "Saved %d database(s) and %d index(es) to %s\n",
+				len(jsonDb.Names()), len(jsonDb.Indexes()), out)
+			return exitFound
+		}
+	},
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var statsCommand = &command{
+	name:    "stats",
+	summary: "Print the number of records, key paths and indexes of each database.",
+	examples: []string{
+		"jsonsearch stats -dbfiles org.json,tickets.json,users.json -indexby tickets._id",
+	},
+	setup: func(fs *flag.FlagSet) func(args []string) int {
+		var l loader
+		var o outputFlags
+
+		l.register(fs)
+		o.register(fs, false)
+
+		return func(args []string) int {
+			if len(args) > 0 {
+				return usageError(fs, "Unexpected arguments:", strings.Join(args, " "))
+			}
+			printer, code := o.printer(fs)
+			if printer == nil {
+				return code
+			}
+			jsonDb, code := l.load(fs)
+			if jsonDb == nil {
+				return code
+			}
+			defer jsonDb.Close()
+			indexes := make(map[string]int)
+			for _, info := range jsonDb.Indexes() {
+				indexes[info.DB]++
+			}
+			var rows [][]interface{}
+			for _, name := range jsonDb.Names() {
+				records, err := jsonDb.Len(name)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					return searchExitCode(err)
+				}
+				keys, err := jsonDb.Keys(name)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					return exitLoad
+				}
+				rows = append(rows, []interface{}{
+					name, float64(records), float64(len(keys)), float64(indexes[name]),
+				})
+			}
+			return printRows(printer, []string{"db", "records", "keys", "indexes"}, rows)
+		}
+	},
+}
+
+// printRows prints the rows and returns the process exit code.
+func printRows(p *Printer, columns []string, rows [][]interface{}) int {
+	if err := p.Rows(columns, rows); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailed
+	}
+	if len(rows) == 0 {
+		return exitNotFound
+	}
+	return exitFound
+}
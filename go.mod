@@ -4,6 +4,11 @@ go 1.15
 
 require (
 	github.com/TylerBrock/colorjson v0.0.0-20200706003622-8a50f05110d2
-	github.com/fatih/color v1.10.0 // indirect
+	github.com/fatih/color v1.10.0
+	github.com/graphql-go/graphql v0.8.1
+	github.com/mattn/go-isatty v0.0.12
+	github.com/santhosh-tekuri/jsonschema/v5 v5.3.0
 	github.com/stretchr/testify v1.7.0
+	golang.org/x/term v0.0.0-20201210144234-2321bbc49cbf
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
@@ -1,10 +1,12 @@
 package db
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"io/ioutil"
+	"sort"
 	"strconv"
 )
 
@@ -37,38 +39,27 @@ func LoadJson(reader io.Reader) (interface{}, error) {
 }
 
 // Create a map index with the key's value for quick access.
-// The function returns a IndexBackend with stringIndex if
-// the value is of basic indexable type. If the type is
-// of complex type (array / map) an error is returned.
+// The function returns a map of the key's value to the list of
+// objects holding that value (posting list). If the value is a
+// list each of its elements is indexed. If the type is of complex
+// type (map / list of maps) an error is returned.
 //
-func CreateIndex(unmarshalledJson interface{}, dbname, key string) (map[string]interface{}, error) {
+func CreateIndex(unmarshalledJson interface{}, dbname, key string) (map[string][]interface{}, error) {
 
-	var result map[string]interface{}
+	var result map[string][]interface{}
 	var val interface{}
 	var found bool
 
-	result = make(map[string]interface{})
+	result = make(map[string][]interface{})
 	toResult := func(valueFound interface{}, enclObj interface{}) bool {
-		switch indexVal := valueFound.(type) {
-		case int:
-			sval := strconv.Itoa(indexVal)
-			result[sval] = enclObj
-			return true
-		case float64:
-			sval := strconv.Itoa(int(indexVal))
-			result[sval] = enclObj
-			return true
-		case string:
-			result[indexVal] = enclObj
-			return true
-		}
-		return false
+		return addPosting(result, valueFound, enclObj)
 	}
 
 	switch jsonType := unmarshalledJson.(type) {
 	case []interface{}:
 		for _, lobj := range jsonType {
-			if found, val = find(key, lobj); found {
+			found, val = find(key, lobj)
+			if found == true {
 				saved := toResult(val, lobj)
 				if !saved {
 					return nil, ErrUnsupportedIndexType
@@ -91,8 +82,8 @@ func CreateIndex(unmarshalledJson interface{}, dbname, key string) (map[string]i
 			}
 			return result, nil
 		}
-		for _, v := range jsonType {
-			switch mobj := v.(type) {
+		for _, k := range sortedKeys(jsonType) {
+			switch mobj := jsonType[k].(type) {
 			case []interface{}:
 				found, val = find(key, mobj)
 				if found == true {
@@ -119,12 +110,131 @@ func CreateIndex(unmarshalledJson interface{}, dbname, key string) (map[string]i
 	return nil, ErrKeyNotFound
 }
 
+// addPosting adds the enclosing object to the posting lists of the value
+// found, see postingValues. It returns false for values that cannot be
+// indexed.
+func addPosting(result map[string][]interface{}, valueFound interface{}, enclObj interface{}) bool {
+	values, ok := postingValues(valueFound)
+	if !ok {
+		return false
+	}
+	for _, sval := range values {
+		result[sval] = append(result[sval], enclObj)
+	}
+	return true
+}
+
+// postingValues returns the index values of a value found, a list value
+// fans out to each of its distinct element values. It returns false for
+// values that cannot be indexed.
+func postingValues(valueFound interface{}) ([]string, bool) {
+	list, ok := valueFound.([]interface{})
+	if !ok {
+		sval, ok := IndexValue(valueFound)
+		if !ok {
+			return nil, false
+		}
+		return []string{sval}, true
+	}
+	values := make([]string, 0, len(list))
+	seen := make(map[string]bool)
+	for _, elem := range list {
+		sval, ok := IndexValue(elem)
+		if !ok {
+			return nil, false
+		}
+		if !seen[sval] {
+			seen[sval] = true
+			values = append(values, sval)
+		}
+	}
+	return values, true
+}
+
+// IndexRecords indexes the key of count records like CreateIndex indexes
+// a list, the posting lists hold the positions of the records. record
+// returns the record at a position, its error stops the indexing.
+func IndexRecords(count int, record func(int) (interface{}, error), key string) (map[string][]int, error) {
+	result := make(map[string][]int)
+	for n := 0; n < count; n++ {
+		lobj, err := record(n)
+		if err != nil {
+			return nil, err
+		}
+		found, val := find(key, lobj)
+		if !found {
+			continue
+		}
+		values, ok := postingValues(val)
+		if !ok {
+			return nil, ErrUnsupportedIndexType
+		}
+		for _, sval := range values {
+			result[sval] = append(result[sval], n)
+		}
+	}
+	if len(result) == 0 {
+		return nil, ErrKeyNotFound
+	}
+	return result, nil
+}
+
+// Match reports whether the record holds the value at the key, like
+// the records found by Search.
+func Match(key, value string, record interface{}) bool {
+	found, _ := findv(nil, key, value, record)
+	return found
+}
+
+// matchContext reports whether the record holds the value at the key
+// like Match, the search of the record stops with the error of c.
+func matchContext(c *canceller, key, value string, record interface{}) (bool, error) {
+	found, _ := findv(c, key, value, record)
+	if c.err != nil {
+		return false, c.err
+	}
+	return found, nil
+}
+
 // Perform a search on the entire JSON object and look for the key with the
 // corresponding value. The search is not indexed. If the key and value
 // match one or more results are returned in IndexBackend.resultSet. If
 // no values are found then an error is returned.
 //
 func Search(root interface{}, dbname, key, value string) ([]interface{}, error) {
+	return SearchContext(context.Background(), root, dbname, key, value)
+}
+
+// cancelCheck is the number of values searched between checks of the
+// context.
+const cancelCheck = 256
+
+// canceller checks its context every cancelCheck values searched, the
+// records and the values nested in them, so that the search of a large
+// record stops too. A nil canceller is never done.
+type canceller struct {
+	ctx context.Context
+	n   int
+	err error
+}
+
+// done reports whether the context was found done, err is then its
+// error.
+func (c *canceller) done() bool {
+	if c == nil {
+		return false
+	}
+	if c.err == nil && c.n%cancelCheck == 0 {
+		c.err = c.ctx.Err()
+	}
+	c.n++
+	return c.err != nil
+}
+
+// SearchContext searches like Search and stops with the error of the
+// context when it is done. The records of a list are scanned in
+// parallel, see SearchWorkers.
+func SearchContext(ctx context.Context, root interface{}, dbname, key, value string) ([]interface{}, error) {
 
 	var result []interface{}
 	var found bool
@@ -136,16 +246,7 @@ func Search(root interface{}, dbname, key, value string) ([]interface{}, error)
 	result = make([]interface{}, 0)
 	switch jsonType := root.(type) {
 	case []interface{}:
-		for _, lobj := range jsonType {
-			found, _ = findv(key, value, lobj)
-			if found {
-				toResult(value, lobj)
-			}
-		}
-		if len(result) == 0 {
-			return nil, ErrKeyValueNotFound
-		}
-		return result, nil
+		return SearchWorkers(ctx, jsonType, dbname, key, value, 0)
 	case map[string]interface{}:
 		v, ok := jsonType[key]
 		if ok {
@@ -167,8 +268,16 @@ func Search(root interface{}, dbname, key, value string) ([]interface{}, error)
 			}
 			return nil, ErrKeyValueNotFound
 		}
-		for _, v := range jsonType {
-			found, _ = findv(key, value, v)
+		c := &canceller{ctx: ctx}
+		for _, k := range sortedKeys(jsonType) {
+			if c.done() {
+				return nil, c.err
+			}
+			v := jsonType[k]
+			found, _ = findv(c, key, value, v)
+			if c.err != nil {
+				return nil, c.err
+			}
 			if found == true {
 				toResult(value, v)
 			}
@@ -180,3 +289,126 @@ func Search(root interface{}, dbname, key, value string) ([]interface{}, error)
 	}
 	return nil, ErrKeyValueNotFound
 }
+
+// Each calls fn with the records of root holding the value at the key
+// as they are found, in the order of Search, until fn returns false.
+// The records of a list are scanned sequentially so that the scan stops
+// with fn. It returns ErrKeyValueNotFound when no record matches.
+func Each(ctx context.Context, root interface{}, dbname, key, value string, fn func(interface{}) bool) error {
+	list, ok := root.([]interface{})
+	if !ok {
+		result, err := SearchContext(ctx, root, dbname, key, value)
+		if err != nil {
+			return err
+		}
+		for _, v := range result {
+			if !fn(v) {
+				break
+			}
+		}
+		return nil
+	}
+	return EachRecord(ctx, len(list), ListRecord(list), key, value, fn)
+}
+
+// ListRecord returns the record function of the records of a list.
+func ListRecord(list []interface{}) func(int) (interface{}, error) {
+	return func(n int) (interface{}, error) { return list[n], nil }
+}
+
+// EachRecord calls fn with the records holding the value at the key
+// among count records like Each, record returns the record at a
+// position and its error stops the scan.
+func EachRecord(ctx context.Context, count int, record func(int) (interface{}, error), key, value string, fn func(interface{}) bool) error {
+	found := false
+	c := &canceller{ctx: ctx}
+	for n := 0; n < count; n++ {
+		if c.done() {
+			return c.err
+		}
+		lobj, err := record(n)
+		if err != nil {
+			return err
+		}
+		match, err := matchContext(c, key, value, lobj)
+		if err != nil {
+			return err
+		}
+		if match {
+			found = true
+			if !fn(lobj) {
+				return nil
+			}
+		}
+	}
+	if !found {
+		return ErrKeyValueNotFound
+	}
+	return nil
+}
+
+// sortedKeys returns the sorted keys of an object. The values of an
+// object database are searched and indexed in the order of their keys,
+// so that its results keep their order from one search to the next.
+func sortedKeys(obj map[string]interface{}) []string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Find looks up the key recursively in the given JSON object and returns
+// the value of the first match.
+func Find(key string, root interface{}) (interface{}, bool) {
+	found, val := find(key, root)
+	return val, found
+}
+
+// Records returns the top level records of a JSON document. A list
+// document yields each of its elements, an object document is a single
+// record.
+func Records(root interface{}) []interface{} {
+	switch jsonType := root.(type) {
+	case []interface{}:
+		return jsonType
+	case map[string]interface{}:
+		return []interface{}{jsonType}
+	}
+	return nil
+}
+
+// IndexValue returns the string form used to index a scalar JSON value.
+// ok is false for values that cannot be indexed (arrays, objects, null
+// and booleans).
+func IndexValue(v interface{}) (string, bool) {
+	switch val := v.(type) {
+	case int:
+		return strconv.Itoa(val), true
+	case float64:
+		return strconv.Itoa(int(val)), true
+	case string:
+		return val, true
+	}
+	return "", false
+}
+
+// TypeName returns the JSON type name of an unmarshalled value.
+func TypeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case int, float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
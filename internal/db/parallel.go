@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// minParallel is the number of records below which a list is scanned
+// by a single worker.
+const minParallel = 2048
+
+// chunksPerWorker splits a list in more chunks than workers so that
+// workers finishing early pick up the remaining chunks.
+const chunksPerWorker = 4
+
+// SearchWorkers searches like SearchContext and scans the records of a
+// list root with up to workers goroutines, the results keep the order
+// of the list. Zero workers use GOMAXPROCS goroutines, a single worker
+// scans sequentially.
+func SearchWorkers(ctx context.Context, root interface{}, dbname, key, value string, workers int) ([]interface{}, error) {
+	list, ok := root.([]interface{})
+	if !ok {
+		return SearchContext(ctx, root, dbname, key, value)
+	}
+	result, err := scanList(ctx, list, key, value, workers)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, ErrKeyValueNotFound
+	}
+	return result, nil
+}
+
+// scanList returns the records of the list holding the value at the
+// key, in list order.
+func scanList(ctx context.Context, list []interface{}, key, value string, workers int) ([]interface{}, error) {
+	return ScanRecords(ctx, len(list), ListRecord(list), key, value, workers)
+}
+
+// ScanRecords returns the records holding the value at the key among n
+// records, in order, with up to workers goroutines like SearchWorkers.
+// record returns the record at a position and is called concurrently,
+// the first error it returns stops the scan.
+func ScanRecords(ctx context.Context, count int, record func(int) (interface{}, error), key, value string, workers int) ([]interface{}, error) {
+
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers == 1 || count < minParallel {
+		result := make([]interface{}, 0)
+		c := &canceller{ctx: ctx}
+		for n := 0; n < count; n++ {
+			if c.done() {
+				return nil, c.err
+			}
+			lobj, err := record(n)
+			if err != nil {
+				return nil, err
+			}
+			match, err := matchContext(c, key, value, lobj)
+			if err != nil {
+				return nil, err
+			}
+			if match {
+				result = append(result, lobj)
+			}
+		}
+		return result, nil
+	}
+
+	// a record error stops the other workers
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var recordErr error
+	var once sync.Once
+
+	chunks := workers * chunksPerWorker
+	size := (count + chunks - 1) / chunks
+	matches := make([][]interface{}, chunks)
+	var next int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cc := &canceller{ctx: ctx}
+			for {
+				c := int(atomic.AddInt64(&next, 1) - 1)
+				if c >= chunks {
+					return
+				}
+				start := c * size
+				end := start + size
+				if end > count {
+					end = count
+				}
+				for n := start; n < end; n++ {
+					if cc.done() {
+						return
+					}
+					lobj, err := record(n)
+					if err != nil {
+						once.Do(func() {
+							recordErr = err
+							cancel()
+						})
+						return
+					}
+					match, err := matchContext(cc, key, value, lobj)
+					if err != nil {
+						return
+					}
+					if match {
+						matches[c] = append(matches[c], lobj)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if recordErr != nil {
+		return nil, recordErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// merge the chunks in list order
+	total := 0
+	for _, m := range matches {
+		total += len(m)
+	}
+	result := make([]interface{}, 0, total)
+	for _, m := range matches {
+		result = append(result, m...)
+	}
+	return result, nil
+}
@@ -1,13 +1,16 @@
 package db
 
 import (
+	"fmt"
 	"log"
+	"sort"
 	"strconv"
+	"strings"
 )
 
 // Recursively check if key and value match in the given
-// JSON object
-func findv(key, value string, root interface{}) (bool, interface{}) {
+// JSON object. The search stops when c is cancelled, see canceller.
+func findv(c *canceller, key, value string, root interface{}) (bool, interface{}) {
 
 	var found bool
 	var val interface{}
@@ -20,14 +23,17 @@ func findv(key, value string, root interface{}) (bool, interface{}) {
 	// JSON child object is a list/array
 	case []interface{}:
 		for _, o := range obj {
+			if c.done() {
+				return false, nil
+			}
 			switch lobj := o.(type) {
 			case []interface{}:
-				found, val = findv(key, value, lobj)
+				found, val = findv(c, key, value, lobj)
 				if found == true {
 					return true, val
 				}
 			case map[string]interface{}:
-				found, val = findv(key, value, lobj)
+				found, val = findv(c, key, value, lobj)
 				if found == true {
 					return true, val
 				}
@@ -66,12 +72,12 @@ func findv(key, value string, root interface{}) (bool, interface{}) {
 					return true, nil
 				}
 			case []interface{}:
-				found, val = findv(key, value, vv)
+				found, val = findv(c, key, value, vv)
 				if found == true {
 					return true, nil
 				}
 			case map[string]interface{}:
-				found, val = findv(key, value, vv)
+				found, val = findv(c, key, value, vv)
 				if found == true {
 					return true, nil
 				}
@@ -79,6 +85,9 @@ func findv(key, value string, root interface{}) (bool, interface{}) {
 			return false, nil
 		}
 		for k, v := range obj {
+			if c.done() {
+				return false, nil
+			}
 			switch mobj := v.(type) {
 			case string:
 				if k == key && mobj == value {
@@ -95,12 +104,12 @@ func findv(key, value string, root interface{}) (bool, interface{}) {
 					return true, mobj
 				}
 			case []interface{}:
-				found, val = findv(key, value, mobj)
+				found, val = findv(c, key, value, mobj)
 				if found == true {
 					return true, val
 				}
 			case map[string]interface{}:
-				found, val = findv(key, value, mobj)
+				found, val = findv(c, key, value, mobj)
 				if found == true {
 					return true, val
 				}
@@ -172,3 +181,155 @@ func find(key string, root interface{}) (bool, interface{}) {
 	}
 	return false, nil
 }
+
+// Lookup returns the value at the dot separated key path in the
+// given JSON object. A path of a single key that is not found at
+// the top level is looked up recursively.
+func Lookup(path string, root interface{}) (interface{}, bool) {
+
+	if path == "" || root == nil {
+		return nil, false
+	}
+	keys := strings.Split(path, ".")
+	val := root
+	found := true
+	for _, k := range keys {
+		obj, ok := val.(map[string]interface{})
+		if !ok {
+			found = false
+			break
+		}
+		if val, ok = obj[k]; !ok {
+			found = false
+			break
+		}
+	}
+	if found {
+		return val, true
+	}
+	if len(keys) == 1 {
+		if found, v := find(path, root); found {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// typeOrder orders values of different JSON types.
+func typeOrder(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case int, float64:
+		return 2
+	case string:
+		return 3
+	case []interface{}:
+		return 4
+	case map[string]interface{}:
+		return 5
+	}
+	return 6
+}
+
+func toFloat(v interface{}) float64 {
+	switch val := v.(type) {
+	case int:
+		return float64(val)
+	case float64:
+		return val
+	}
+	return 0
+}
+
+// Compare orders two JSON values and returns -1, 0 or 1. Values of
+// different types are ordered null < boolean < number < string <
+// array < object. Arrays and objects are compared by their length.
+func Compare(a, b interface{}) int {
+	ta, tb := typeOrder(a), typeOrder(b)
+	if ta != tb {
+		if ta < tb {
+			return -1
+		}
+		return 1
+	}
+	switch av := a.(type) {
+	case bool:
+		bv := b.(bool)
+		switch {
+		case av == bv:
+			return 0
+		case !av:
+			return -1
+		}
+		return 1
+	case int, float64:
+		af, bf := toFloat(a), toFloat(b)
+		switch {
+		case af < bf:
+			return -1
+		case af > bf:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(av, b.(string))
+	case []interface{}:
+		return compareLen(len(av), len(b.([]interface{})))
+	case map[string]interface{}:
+		return compareLen(len(av), len(b.(map[string]interface{})))
+	}
+	return 0
+}
+
+func compareLen(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// MatchPaths returns the paths of the values of key matching value in
+// the given JSON object, e.g. "tags[1]" or "via.source.from.name".
+// Paths are returned in key order.
+func MatchPaths(key, value string, root interface{}) []string {
+
+	var paths []string
+	var walk func(v interface{}, path string, keyMatched bool)
+
+	walk = func(v interface{}, path string, keyMatched bool) {
+		switch obj := v.(type) {
+		case map[string]interface{}:
+			keys := make([]string, 0, len(obj))
+			for k := range obj {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				kpath := k
+				if path != "" {
+					kpath = path + "." + k
+				}
+				walk(obj[k], kpath, k == key)
+			}
+		case []interface{}:
+			for n, e := range obj {
+				walk(e, fmt.Sprintf("%s[%d]", path, n), keyMatched)
+			}
+		default:
+			if !keyMatched {
+				return
+			}
+			if sval, ok := IndexValue(obj); ok && sval == value {
+				paths = append(paths, path)
+			}
+		}
+	}
+	walk(root, "", false)
+	return paths
+}
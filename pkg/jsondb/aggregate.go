@@ -0,0 +1,401 @@
+package jsondb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gusaki/jsonsearch/internal/db"
+)
+
+var ErrInvalidAggregate = errors.New("invalid aggregate")
+
+// AggFunc is an aggregate function computed over the records of a
+// group.
+type AggFunc int
+
+const (
+	// Count counts the records of a group, or the records holding a
+	// non null value when a key path is given.
+	Count AggFunc = iota
+	// Distinct lists the distinct values of a key path, list values
+	// contribute each of their elements.
+	Distinct
+	Sum
+	Avg
+	Min
+	Max
+)
+
+var aggFuncNames = map[AggFunc]string{
+	Count:    "count",
+	Distinct: "distinct",
+	Sum:      "sum",
+	Avg:      "avg",
+	Min:      "min",
+	Max:      "max",
+}
+
+func (f AggFunc) String() string {
+	if name, ok := aggFuncNames[f]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Aggregate is an aggregate function over a key path. Sum, Avg, Min
+// and Max only consider numeric values.
+type Aggregate struct {
+	Func AggFunc
+	Path string
+	As   string
+}
+
+// Name returns the column name of the aggregate.
+func (a Aggregate) Name() string {
+	if a.As != "" {
+		return a.As
+	}
+	if a.Path == "" {
+		return a.Func.String()
+	}
+	return fmt.Sprintf("%s(%s)", a.Func, a.Path)
+}
+
+// ParseAggregate parses an aggregate in the form of
+// <func>[:<key path>] [as <name>], e.g. count, distinct:tags or
+// avg:price as mean.
+func ParseAggregate(s string) (Aggregate, error) {
+
+	var agg Aggregate
+
+	s = strings.TrimSpace(s)
+	if i := strings.Index(strings.ToLower(s), " as "); i != -1 {
+		agg.As = strings.TrimSpace(s[i+4:])
+		if agg.As == "" {
+			return Aggregate{}, fmt.Errorf("%w: %s", ErrInvalidAggregate, s)
+		}
+		s = strings.TrimSpace(s[0:i])
+	}
+	name := s
+	if i := strings.Index(s, ":"); i != -1 {
+		name, agg.Path = s[0:i], strings.TrimSpace(s[i+1:])
+	}
+	found := false
+	for f, fname := range aggFuncNames {
+		if strings.ToLower(strings.TrimSpace(name)) == fname {
+			agg.Func = f
+			found = true
+			break
+		}
+	}
+	if !found || (agg.Path == "" && agg.Func != Count) {
+		return Aggregate{}, fmt.Errorf("%w: %s", ErrInvalidAggregate, s)
+	}
+	return agg, nil
+}
+
+// AggregateQuery groups the records of DB by the values of the GroupBy
+// key paths and computes the Aggregates of each group. When Key is set
+// only the records matching Key and Value are aggregated.
+type AggregateQuery struct {
+	DB         string
+	GroupBy    []string
+	Aggregates []Aggregate
+	Key        string
+	Value      string
+}
+
+// Group holds the group by values of a group and its aggregates in
+// the order of the query.
+type Group struct {
+	Keys   []interface{}
+	Values []interface{}
+}
+
+// AggregateResult is the list of groups ordered by their keys.
+type AggregateResult struct {
+	GroupBy    []string
+	Aggregates []string
+	Groups     []Group
+}
+
+// Columns returns the group by and aggregate column names.
+func (r *AggregateResult) Columns() []string {
+	return append(append([]string{}, r.GroupBy...), r.Aggregates...)
+}
+
+// Rows returns each group as a row of its keys followed by its
+// aggregates.
+func (r *AggregateResult) Rows() [][]interface{} {
+	rows := make([][]interface{}, 0, len(r.Groups))
+	for _, g := range r.Groups {
+		rows = append(rows, append(append([]interface{}{}, g.Keys...), g.Values...))
+	}
+	return rows
+}
+
+// groupValues returns the values a record is grouped by for a key
+// path. List values group the record under each of their distinct
+// elements. Records without a value are not grouped, as they are not
+// indexed.
+func groupValues(path string, rec interface{}) []interface{} {
+	v, ok := db.Lookup(path, rec)
+	if !ok || v == nil {
+		return nil
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return []interface{}{v}
+	}
+	values := make([]interface{}, 0, len(list))
+	seen := make(map[string]bool)
+	for _, elem := range list {
+		id := fmt.Sprintf("%#v", elem)
+		if !seen[id] {
+			seen[id] = true
+			values = append(values, elem)
+		}
+	}
+	return values
+}
+
+type groupAcc struct {
+	keys    []interface{}
+	records []interface{}
+}
+
+// accumulator collects records into groups keyed by their group by
+// values.
+type accumulator struct {
+	groups map[string]*groupAcc
+}
+
+func (a *accumulator) add(keys []interface{}, rec interface{}) {
+	id := fmt.Sprintf("%#v", keys)
+	g, ok := a.groups[id]
+	if !ok {
+		g = &groupAcc{keys: keys}
+		a.groups[id] = g
+	}
+	g.records = append(g.records, rec)
+}
+
+// addRecord adds a record to every group formed by the cartesian
+// product of its group by values.
+func (a *accumulator) addRecord(groupBy []string, rec interface{}) {
+	combos := [][]interface{}{{}}
+	for _, path := range groupBy {
+		var next [][]interface{}
+		for _, c := range combos {
+			for _, v := range groupValues(path, rec) {
+				next = append(next, append(append([]interface{}{}, c...), v))
+			}
+		}
+		combos = next
+	}
+	for _, keys := range combos {
+		a.add(keys, rec)
+	}
+}
+
+// indexGroupValues returns the group values of rec at path that are
+// indexed as sval. Index values truncate numbers, so the posting list
+// of 1 also holds the records of 1.5, which form a group of their own.
+func indexGroupValues(path, sval string, rec interface{}) []interface{} {
+	var values []interface{}
+	for _, v := range groupValues(path, rec) {
+		if s, ok := db.IndexValue(v); ok && s == sval {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func numeric(v interface{}) (float64, bool) {
+	switch val := v.(type) {
+	case int:
+		return float64(val), true
+	case float64:
+		return val, true
+	}
+	return 0, false
+}
+
+// compute evaluates the aggregate over the records of a group.
+func compute(agg Aggregate, records []interface{}) interface{} {
+
+	if agg.Func == Count && agg.Path == "" {
+		return len(records)
+	}
+
+	var values []interface{}
+	for _, rec := range records {
+		if v, ok := db.Lookup(agg.Path, rec); ok && v != nil {
+			values = append(values, v)
+		}
+	}
+
+	switch agg.Func {
+	case Count:
+		return len(values)
+	case Distinct:
+		seen := make(map[string]bool)
+		distinct := make([]interface{}, 0)
+		for _, v := range values {
+			list, ok := v.([]interface{})
+			if !ok {
+				list = []interface{}{v}
+			}
+			for _, elem := range list {
+				id := fmt.Sprintf("%#v", elem)
+				if !seen[id] {
+					seen[id] = true
+					distinct = append(distinct, elem)
+				}
+			}
+		}
+		sort.SliceStable(distinct, func(i, j int) bool {
+			return db.Compare(distinct[i], distinct[j]) < 0
+		})
+		return distinct
+	}
+
+	var sum float64
+	var result interface{}
+	n := 0
+	for _, v := range values {
+		f, ok := numeric(v)
+		if !ok {
+			continue
+		}
+		switch {
+		case n == 0:
+			result = f
+		case agg.Func == Min && f < result.(float64):
+			result = f
+		case agg.Func == Max && f > result.(float64):
+			result = f
+		}
+		sum += f
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+	switch agg.Func {
+	case Sum:
+		return sum
+	case Avg:
+		return sum / float64(n)
+	}
+	return result
+}
+
+// Aggregate groups the records of q.DB and computes the aggregates of
+// each group. Without GroupBy all the records form a single group,
+// records without a value for a GroupBy key are left out. A single
+// GroupBy key that is indexed is grouped using the index, unless the
+// database holds a single object.
+func (jdb *JsonDB) Aggregate(q AggregateQuery) (*AggregateResult, error) {
+	return jdb.AggregateContext(context.Background(), q)
+}
+
+// AggregateContext aggregates like Aggregate and stops with the error
+// of the context when it is done.
+func (jdb *JsonDB) AggregateContext(ctx context.Context, q AggregateQuery) (*AggregateResult, error) {
+
+	if jdb == nil || jdb.dbMap == nil {
+		return nil, ErrInvalidDatabase
+	}
+	if _, ok := jdb.dbMap[q.DB]; !ok {
+		return nil, ErrInvalidDatabase
+	}
+	aggs := q.Aggregates
+	if len(aggs) == 0 {
+		aggs = []Aggregate{{Func: Count}}
+	}
+
+	acc := &accumulator{groups: make(map[string]*groupAcc)}
+	var vIndex *valueIndex
+	if len(q.GroupBy) == 1 && q.Key == "" {
+		// the index of an object also holds its nested objects, which
+		// are not records of their own
+		if jsonType, _ := jdb.jsonType(q.DB); jsonType.dict == nil {
+			vIndex, _ = jdb.index(q.DB, q.GroupBy[0])
+		}
+	}
+	switch {
+	case vIndex != nil:
+		// group using the index posting lists
+		for n, sval := range vIndex.values() {
+			if err := cancelled(ctx, n); err != nil {
+				return nil, err
+			}
+			recs, _, err := vIndex.lookup(sval)
+			if err != nil {
+				return nil, err
+			}
+			for _, rec := range recs {
+				for _, v := range indexGroupValues(q.GroupBy[0], sval, rec) {
+					acc.add([]interface{}{v}, rec)
+				}
+			}
+		}
+	case q.Key != "":
+		records, err := jdb.SearchContext(ctx, q.DB, q.Key, q.Value, nil)
+		if err != nil && err != ErrKeyValueNotFound {
+			return nil, err
+		}
+		for _, rec := range records {
+			acc.addRecord(q.GroupBy, rec)
+		}
+	default:
+		err := jdb.eachRecord(q.DB, func(n int, rec interface{}) error {
+			if err := cancelled(ctx, n); err != nil {
+				return err
+			}
+			acc.addRecord(q.GroupBy, rec)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(q.GroupBy) == 0 && len(acc.groups) == 0 {
+		acc.groups[""] = &groupAcc{}
+	}
+
+	result := &AggregateResult{GroupBy: q.GroupBy}
+	for _, agg := range aggs {
+		result.Aggregates = append(result.Aggregates, agg.Name())
+	}
+	ids := make([]string, 0, len(acc.groups))
+	for id := range acc.groups {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		g := acc.groups[id]
+		group := Group{Keys: g.keys}
+		for _, agg := range aggs {
+			group.Values = append(group.Values, compute(agg, g.records))
+		}
+		result.Groups = append(result.Groups, group)
+	}
+	// the groups whose keys compare equal, like objects of the same
+	// size, stay in the order of their ids
+	sort.SliceStable(result.Groups, func(i, j int) bool {
+		a, b := result.Groups[i].Keys, result.Groups[j].Keys
+		for n := range a {
+			if c := db.Compare(a[n], b[n]); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	return result, nil
+}
@@ -0,0 +1,135 @@
+package jsondb
+
+import "sort"
+
+// IndexInfo describes the index of a database key. Values is the
+// number of distinct indexed values and Entries the number of records
+// in the posting lists.
+type IndexInfo struct {
+	DB      string
+	Key     string
+	Values  int
+	Entries int
+}
+
+// Names returns the sorted names of the loaded databases.
+func (jdb *JsonDB) Names() []string {
+	if jdb == nil {
+		return nil
+	}
+	names := make([]string, 0, len(jdb.dbMap))
+	for name := range jdb.dbMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Records returns the records of the database. A database holding a
+// single object has that object as its only record. The records of a
+// database kept on disk are all decoded, use Len to count them.
+func (jdb *JsonDB) Records(dbname string) ([]interface{}, error) {
+	if jdb == nil || jdb.dbMap == nil {
+		return nil, ErrInvalidDatabase
+	}
+	if _, ok := jdb.dbMap[dbname]; !ok {
+		return nil, ErrInvalidDatabase
+	}
+	return jdb.records(dbname)
+}
+
+// Len returns the number of records of the database, counted like
+// Records without decoding the records of a database kept on disk.
+func (jdb *JsonDB) Len(dbname string) (int, error) {
+	if jdb == nil || jdb.dbMap == nil {
+		return 0, ErrInvalidDatabase
+	}
+	if _, ok := jdb.dbMap[dbname]; !ok {
+		return 0, ErrInvalidDatabase
+	}
+	return count(jdb.getDB(dbname)), nil
+}
+
+// keyPaths adds the dot separated key paths of v below prefix. The
+// objects in lists share the key path of the list.
+func keyPaths(v interface{}, prefix string, paths map[string]bool) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, e := range val {
+			path := k
+			if prefix != "" {
+				path = prefix + "." + k
+			}
+			paths[path] = true
+			keyPaths(e, path, paths)
+		}
+	case []interface{}:
+		for _, e := range val {
+			keyPaths(e, prefix, paths)
+		}
+	}
+}
+
+// Keys returns the sorted key paths found in the records of the
+// database, including the paths of nested objects.
+func (jdb *JsonDB) Keys(dbname string) ([]string, error) {
+	if jdb == nil || jdb.dbMap == nil {
+		return nil, ErrInvalidDatabase
+	}
+	if _, ok := jdb.dbMap[dbname]; !ok {
+		return nil, ErrInvalidDatabase
+	}
+	paths := make(map[string]bool)
+	err := jdb.eachRecord(dbname, func(n int, rec interface{}) error {
+		keyPaths(rec, "", paths)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(paths))
+	for k := range paths {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Indexes returns the built indexes sorted by database and key.
+func (jdb *JsonDB) Indexes() []IndexInfo {
+	var infos []IndexInfo
+	if jdb == nil {
+		return infos
+	}
+	jdb.mu.RLock()
+	defer jdb.mu.RUnlock()
+	for dbname, kIndex := range jdb.dbIndex {
+		for key, vIndex := range kIndex {
+			info := IndexInfo{DB: dbname, Key: key}
+			info.Values, info.Entries = vIndex.size()
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].DB != infos[j].DB {
+			return infos[i].DB < infos[j].DB
+		}
+		return infos[i].Key < infos[j].Key
+	})
+	return infos
+}
+
+// IndexedValues returns the sorted values of the index of the database
+// key, ok is false when the key is not indexed.
+func (jdb *JsonDB) IndexedValues(dbname, key string) ([]string, bool) {
+	if jdb == nil {
+		return nil, false
+	}
+	vIndex, ok := jdb.index(dbname, key)
+	if !ok {
+		return nil, false
+	}
+	values := vIndex.values()
+	sort.Strings(values)
+	return values, true
+}
@@ -1,12 +1,17 @@
 package jsondb
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/gusaki/jsonsearch/internal/db"
 )
@@ -27,16 +32,43 @@ var (
 type JSONType struct {
 	list []interface{}
 	dict map[string]interface{}
+	// source of a database loaded from a file
+	source *Source
+	// store of a database kept on disk, list and dict are nil
+	store Store
 }
 
 type DBMap map[string]*JSONType
 
+// JsonDB holds the loaded databases and their indexes. A JsonDB is safe
+// for concurrent use by multiple goroutines: indexes may be built while
+// other goroutines search. The records are shared with the results and
+// must not be modified.
 type JsonDB struct {
-	dbMap   DBMap
+	// the names of dbMap are fixed once loaded, Reload replaces the
+	// content of a database
+	dbMap DBMap
+	// mu guards the databases of dbMap, dbIndex and validationErrors,
+	// a value index is not modified once built
+	mu      sync.RWMutex
 	dbIndex DBIndex
+	// errors of the records kept by a load in ValidateWarn mode
+	validationErrors []ValidationError
+	// schemas and validation mode of the load, used by Reload
+	schemas  map[string]string
+	validate ValidationMode
+	// relationships saved with a snapshot
+	relations []string
 }
 
 func Load(filenames []string) (*JsonDB, error) {
+	return load(filenames, false)
+}
+
+// load loads the databases in memory, or the lists of records in a
+// DiskStore with diskStore. The databases holding an object are always
+// loaded in memory.
+func load(filenames []string, diskStore bool) (*JsonDB, error) {
 
 	var jsonDB JsonDB
 
@@ -46,82 +78,247 @@ func Load(filenames []string) (*JsonDB, error) {
 
 	jsonDB.dbMap = make(DBMap)
 	for _, fname := range filenames {
-		file, err := os.Open(fname)
 		base := filepath.Base(fname)
 		ext := filepath.Ext(base)
 		dbname := base[0:strings.Index(base, ext)]
+		jsonType, err := openFile(fname, diskStore)
 		if err != nil {
-			log.Println("Error opening file", err)
+			jsonDB.Close()
 			return nil, err
 		}
-		v, err := db.LoadJson(file)
-		if err != nil {
+		jsonDB.dbMap[dbname] = jsonType
+	}
+	return &jsonDB, nil
+}
+
+// openFile opens a database file in a DiskStore with diskStore when it
+// holds a list of records, and loads it in memory otherwise.
+func openFile(fname string, diskStore bool) (*JSONType, error) {
+	if diskStore {
+		s, err := OpenDiskStore(fname)
+		if err == nil {
+			return &JSONType{store: s, source: s.source}, nil
+		}
+		if !errors.Is(err, ErrNotList) {
 			log.Println("Error loading JSON files to the database", err)
 			return nil, err
 		}
-		jsonType := &JSONType{}
-		switch jtype := v.(type) {
-		case map[string]interface{}:
-			jsonType.dict = jtype
-		case []interface{}:
-			jsonType.list = jtype
-		default:
-			return nil, ErrInvalidDatabase
+	}
+	return loadFile(fname)
+}
+
+// loadFile parses a database file in memory.
+func loadFile(fname string) (*JSONType, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		log.Println("Error opening file", err)
+		return nil, err
+	}
+	defer file.Close()
+	source, err := newSource(fname, file)
+	if err != nil {
+		return nil, err
+	}
+	// the content is hashed while it is read to tell whether the
+	// index file of the database is still valid
+	h := sha256.New()
+	v, err := db.LoadJson(io.TeeReader(file, h))
+	if err != nil {
+		log.Println("Error loading JSON files to the database", err)
+		return nil, err
+	}
+	source.Hash = hex.EncodeToString(h.Sum(nil))
+	jsonType := &JSONType{source: source}
+	switch jtype := v.(type) {
+	case map[string]interface{}:
+		jsonType.dict = jtype
+	case []interface{}:
+		jsonType.list = jtype
+	default:
+		return nil, ErrInvalidDatabase
+	}
+	return jsonType, nil
+}
+
+// Close closes the stores of the databases kept on disk, which cannot
+// be searched afterwards. The in-memory databases need not be closed.
+func (jdb *JsonDB) Close() error {
+	if jdb == nil {
+		return nil
+	}
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+	var first error
+	for _, jsonType := range jdb.dbMap {
+		if jsonType.store == nil {
+			continue
+		}
+		if err := jsonType.store.Close(); err != nil && first == nil {
+			first = err
 		}
-		jsonDB.dbMap[dbname] = jsonType
 	}
-	return &jsonDB, nil
+	return first
 }
 
 func getRelatedDB(dbname, key, relationship string) (string, string, error) {
 
-	found := false
-	r := strings.Split(relationship, ":")
-	for _, idx := range r {
-		li := strings.LastIndex(idx, ".")
-		rdb := idx[0:li]
-		rkey := idx[li+1:]
-		if rdb == dbname && rkey == key {
-			found = true
-			break
-		}
+	rel, err := ParseRelationship(relationship)
+	if err != nil {
+		return "", "", errNotRelated
 	}
-	if found == true {
-		li := strings.LastIndex(r[1], ".")
-		return r[1][0:li], r[1][li+1:], nil
+	rdb, rkey, ok := rel.Related(dbname, key)
+	if !ok {
+		return "", "", errNotRelated
 	}
-	return "", "", errNotRelated
+	return rdb, rkey, nil
 }
 
+// searchIndex looks up the value in the index of the database key.
 func (jdb *JsonDB) searchIndex(dbname, key, value string) ([]interface{}, error) {
-	if jdb.dbIndex != nil {
-		kIndex, kIndexOk := jdb.dbIndex[dbname]
-		if kIndexOk {
-			vIndex, vIndexOk := kIndex[key]
-			if vIndexOk {
-				v, vOk := vIndex[value]
-				if vOk {
-					result := make([]interface{}, 0)
-					result = append(result, v)
-					return result, nil
-				}
-			}
-		} else {
-			return nil, ErrInvalidDatabase
+	return jdb.view().searchIndex(dbname, key, value)
+}
+
+// searchIndex looks up the value in the index of the database key of
+// the view.
+func (v *view) searchIndex(dbname, key, value string) ([]interface{}, error) {
+	if _, ok := v.dbs[dbname]; !ok {
+		return nil, ErrInvalidDatabase
+	}
+	if vIndex, ok := v.index(dbname, key); ok {
+		result, ok, err := vIndex.lookup(value)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return result, nil
 		}
 	}
 	return nil, ErrIndexNotFound
 }
 
+// cancelCheck is the number of records processed between checks of
+// the context.
+const cancelCheck = 256
+
+// cancelled returns the error of a done context, checked once every
+// cancelCheck records.
+func cancelled(ctx context.Context, n int) error {
+	if n%cancelCheck != 0 {
+		return nil
+	}
+	return ctx.Err()
+}
+
 func (jdb *JsonDB) Search(dbname, key, value string, relations []string) ([]interface{}, error) {
+	return jdb.SearchContext(context.Background(), dbname, key, value, relations)
+}
 
+// SearchContext searches like Search. The scans of the databases stop
+// with the error of the context when it is done.
+func (jdb *JsonDB) SearchContext(ctx context.Context, dbname, key, value string, relations []string) ([]interface{}, error) {
+	return jdb.search(ctx, dbname, key, value, relations, 0)
+}
+
+// Result is a record found by a search and the database holding it,
+// the searched database or a database related to it. Key is the key
+// path the record matched on, the searched key or the related key.
+type Result struct {
+	DB     string
+	Key    string
+	Record interface{}
+}
+
+// SearchEach calls fn with the results of the search as they are found,
+// in the order of Search, and stops when fn returns false. Unlike
+// Search the results are not collected and databases without an index
+// are scanned sequentially, so that only the records up to the last
+// result passed to fn are scanned. It returns ErrKeyValueNotFound when
+// fn is never called.
+func (jdb *JsonDB) SearchEach(dbname, key, value string, relations []string, fn func(Result) bool) error {
+	return jdb.SearchEachContext(context.Background(), dbname, key, value, relations, fn)
+}
+
+// SearchEachContext streams the results like SearchEach. The scans of
+// the databases stop with the error of the context when it is done.
+func (jdb *JsonDB) SearchEachContext(ctx context.Context, dbname, key, value string, relations []string, fn func(Result) bool) error {
+	return jdb.each(ctx, dbname, key, value, relations, 1, fn)
+}
+
+// search collects the results of the databases scanned without an
+// index with up to workers goroutines, GOMAXPROCS when zero.
+func (jdb *JsonDB) search(ctx context.Context, dbname, key, value string, relations []string, workers int) ([]interface{}, error) {
 	var results []interface{}
+	err := jdb.each(ctx, dbname, key, value, relations, workers, func(r Result) bool {
+		results = append(results, r.Record)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// scan calls fn with the records of root, or of a Store, holding the
+// value at the key. A single worker streams the records, more workers
+// collect them with a parallel scan first.
+func scan(ctx context.Context, root interface{}, dbname, key, value string, workers int, fn func(interface{}) bool) error {
+	s, isStore := root.(Store)
+	if workers == 1 {
+		if isStore {
+			return db.EachRecord(ctx, s.Len(), s.Record, key, value, fn)
+		}
+		return db.Each(ctx, root, dbname, key, value, fn)
+	}
+	var r []interface{}
+	var err error
+	if isStore {
+		r, err = db.ScanRecords(ctx, s.Len(), s.Record, key, value, workers)
+		if err == nil && len(r) == 0 {
+			err = db.ErrKeyValueNotFound
+		}
+	} else {
+		r, err = db.SearchWorkers(ctx, root, dbname, key, value, workers)
+	}
+	if err != nil {
+		return err
+	}
+	for _, rec := range r {
+		if !fn(rec) {
+			break
+		}
+	}
+	return nil
+}
+
+// each calls fn with the results of the search in order: the results
+// of the searched database followed by the results of its related
+// databases, until fn returns false.
+func (jdb *JsonDB) each(ctx context.Context, dbname, key, value string, relations []string, workers int, fn func(Result) bool) error {
+
 	// dbname:key pairs
 	var found []string
 	var nfIndex []string
 
 	if jdb == nil || jdb.dbMap == nil {
-		return nil, ErrInvalidDatabase
+		return ErrInvalidDatabase
+	}
+	// the databases and indexes searched are read once
+	v := jdb.view()
+
+	stopped := false
+	emit := func(dbname, key string) func(interface{}) bool {
+		return func(rec interface{}) bool {
+			stopped = !fn(Result{DB: dbname, Key: key, Record: rec})
+			return !stopped
+		}
+	}
+	emitAll := func(dbname, key string, records []interface{}) {
+		yield := emit(dbname, key)
+		for _, rec := range records {
+			if !yield(rec) {
+				return
+			}
+		}
 	}
 
 	notFoundInIndex := func() []string {
@@ -147,12 +344,15 @@ func (jdb *JsonDB) Search(dbname, key, value string, relations []string) ([]inte
 	}
 
 	// search index for the given dbname, key and value
-	res, err := jdb.searchIndex(dbname, key, value)
-	if err == ErrInvalidDatabase {
-		return nil, ErrInvalidDatabase
+	res, err := v.searchIndex(dbname, key, value)
+	if err != nil && err != ErrIndexNotFound {
+		return err
 	}
 	if err == nil {
-		results = append(results, res...)
+		emitAll(dbname, key, res)
+		if stopped {
+			return nil
+		}
 		found = append(found, fmt.Sprintf("%s:%s", dbname, key))
 		// check if the dbname has any related dbnames and
 		// look for the related values in the index
@@ -161,55 +361,64 @@ func (jdb *JsonDB) Search(dbname, key, value string, relations []string) ([]inte
 			if err != nil {
 				continue
 			}
-			rres, err := jdb.searchIndex(relDb, relKey, value)
+			rres, err := v.searchIndex(relDb, relKey, value)
+			if err != nil && err != ErrIndexNotFound {
+				return err
+			}
 			if err == nil {
-				results = append(results, rres...)
+				emitAll(relDb, relKey, rres)
+				if stopped {
+					return nil
+				}
 				found = append(found, fmt.Sprintf("%s:%s", relDb, relKey))
 			}
 		}
 		nfIndex = notFoundInIndex()
 		if len(nfIndex) == 0 {
-			return results, nil
+			return nil
 		}
 	}
 
 	if len(nfIndex) > 0 {
 		// perform full search for the ones not found on index
-		for _, v := range nfIndex {
-			li := strings.LastIndex(v, ":")
-			nDb := v[0:li]
-			nKey := v[li+1:]
-			root := jdb.getDB(nDb)
-			r, err := db.Search(root, nDb, nKey, value)
-			if err != nil {
-				continue
+		for _, nf := range nfIndex {
+			li := strings.LastIndex(nf, ":")
+			nDb := nf[0:li]
+			nKey := nf[li+1:]
+			root := v.root(nDb)
+			err := scan(ctx, root, nDb, nKey, value, workers, emit(nDb, nKey))
+			if err != nil && err != db.ErrKeyValueNotFound {
+				return err
+			}
+			if stopped {
+				return nil
 			}
-			results = append(results, r...)
 		}
-		return results, nil
+		return nil
 	}
 
 	// perform full search for everything
-	root := jdb.getDB(dbname)
-	r, err := db.Search(root, dbname, key, value)
-	if err != nil {
-		return nil, err
+	root := v.root(dbname)
+	err = scan(ctx, root, dbname, key, value, workers, emit(dbname, key))
+	if err == db.ErrKeyValueNotFound {
+		return ErrKeyValueNotFound
+	}
+	if err != nil || stopped {
+		return err
 	}
-	results = append(results, r...)
 	for _, reln := range relations {
 		relDb, relKey, err := getRelatedDB(dbname, key, reln)
 		if err != nil {
 			continue
 		}
-		root := jdb.getDB(relDb)
-		r, err := db.Search(root, relDb, relKey, value)
-		if err != nil {
-			continue
+		root := v.root(relDb)
+		err = scan(ctx, root, relDb, relKey, value, workers, emit(relDb, relKey))
+		if err != nil && err != db.ErrKeyValueNotFound {
+			return err
+		}
+		if stopped {
+			return nil
 		}
-		results = append(results, r...)
-	}
-	if len(results) == 0 {
-		return nil, ErrKeyValueNotFound
 	}
-	return results, nil
+	return nil
 }
@@ -1,8 +1,18 @@
 package jsondb
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -207,6 +217,1884 @@ func TestIndexedSearch(t *testing.T) {
 	}
 }
 
+func TestCheckIntegrity(t *testing.T) {
+	files := []string{
+		"./testdata/organizations.json",
+		"./testdata/tickets.json",
+		"./testdata/users.json",
+	}
+	jsonDb, err := Load(files)
+	assert.Equal(t, err, nil)
+
+	tests := []struct {
+		name       string
+		relations  []string
+		err        error
+		violations int
+	}{
+		{
+			"Ticket referencing a missing organization",
+			[]string{"organizations._id:tickets.organization_id"},
+			nil,
+			1,
+		},
+		{
+			"Ticket assigned to a missing user",
+			[]string{"users._id:tickets.assignee_id"},
+			nil,
+			1,
+		},
+		{
+			"Users all belong to existing organizations",
+			[]string{"organizations._id:users.organization_id"},
+			nil,
+			0,
+		},
+		{
+			"Organization name is not a key of tickets",
+			[]string{"organizations.name:tickets.organization_id"},
+			nil,
+			196,
+		},
+		{
+			"Relationship with an unknown database",
+			[]string{"wrongobject._id:tickets.organization_id"},
+			ErrInvalidDatabase,
+			0,
+		},
+		{
+			"Malformed relationship",
+			[]string{"organizations._id"},
+			ErrInvalidRelationship,
+			0,
+		},
+	}
+	for _, test := range tests {
+		log.Println("Test: ", test.name)
+		report, err := jsonDb.CheckIntegrity(test.relations)
+		assert.Equal(t, err, test.err)
+		if err == nil {
+			assert.Equal(t, len(report.Violations), test.violations)
+		}
+	}
+
+	report, err := jsonDb.CheckIntegrity([]string{"organizations._id:tickets.organization_id"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, report.Violations[0].Kind, DanglingReference)
+	assert.Equal(t, report.Violations[0].Value, "555")
+
+	// the primary key is inferred whichever side it is written on
+	for _, reln := range []string{
+		"organizations._id:tickets.organization_id",
+		"tickets.organization_id:organizations._id",
+		"tickets.organization_id>organizations._id",
+	} {
+		log.Println("Test: ", "Primary key of", reln)
+		report, err := jsonDb.CheckIntegrity([]string{reln})
+		assert.Equal(t, err, nil)
+		assert.Equal(t, len(report.Violations), 1)
+		assert.Equal(t, report.Violations[0].Kind, DanglingReference)
+		assert.Equal(t, report.Violations[0].DB, "tickets")
+		assert.Equal(t, report.Violations[0].Relationship, reln)
+		assert.Equal(t, report.PrimaryKeys, []string{"organizations._id"})
+	}
+	report, err = jsonDb.CheckIntegrity([]string{
+		"users.organization_id:organizations._id",
+		"organizations._id:tickets.organization_id",
+	})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(report.Violations), 1)
+	assert.Equal(t, report.PrimaryKeys, []string{"organizations._id"})
+
+	// type mismatches and duplicate primary keys
+	dir := t.TempDir()
+	orgs := filepath.Join(dir, "orgs.json")
+	tickets := filepath.Join(dir, "tickets.json")
+	assert.Equal(t, ioutil.WriteFile(orgs, []byte(`[{"_id": 1}, {"_id": "2"}, {"_id": 3}]`), 0644), nil)
+	assert.Equal(t, ioutil.WriteFile(tickets, []byte(`[{"org_id": "1"}, {"org_id": 2}, {"org_id": 3},
+		{"org_id": 3}, {"org_id": {"id": 1}}, {"org_id": null}, {}]`), 0644), nil)
+	mismatchDb, err := Load([]string{orgs, tickets})
+	assert.Equal(t, err, nil)
+	report, err = mismatchDb.CheckIntegrity([]string{"tickets.org_id:orgs._id"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(report.Violations), 3)
+	for n, record := range []int{0, 1, 4} {
+		v := report.Violations[n]
+		assert.Equal(t, v.Kind, TypeMismatch)
+		assert.Equal(t, v.DB, "tickets")
+		assert.Equal(t, v.Record, record)
+	}
+	assert.Equal(t, report.Violations[0].Detail, "string value references number orgs._id")
+	assert.Equal(t, report.Violations[1].Detail, "number value references string orgs._id")
+	assert.Equal(t, report.Violations[2].Detail, "object value cannot reference orgs._id")
+
+	// neither side is unique, the left side is the primary key
+	assert.Equal(t, ioutil.WriteFile(orgs, []byte(`[{"_id": 1}, {"_id": 1}]`), 0644), nil)
+	dupDb, err := Load([]string{orgs, tickets})
+	assert.Equal(t, err, nil)
+	report, err = dupDb.CheckIntegrity([]string{"orgs._id:tickets.org_id"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, report.Violations[0].Kind, DuplicateKey)
+	assert.Equal(t, report.Violations[0].DB, "orgs")
+	assert.Equal(t, report.Violations[0].Relationship, "orgs._id:tickets.org_id")
+	assert.Equal(t, report.PrimaryKeys, []string{"orgs._id"})
+
+	dups, err := jsonDb.DuplicateKeys("organizations", "_id")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(dups), 0)
+
+	dups, err = jsonDb.DuplicateKeys("tickets", "type")
+	assert.Equal(t, err, nil)
+	assert.NotEqual(t, len(dups), 0)
+	assert.Equal(t, dups[0].Kind, DuplicateKey)
+}
+
+func TestArrayValuedKeys(t *testing.T) {
+	files := []string{
+		"./testdata/organizations.json",
+		"./testdata/users.json",
+		"./testdata/watchlists.json",
+	}
+	relations := []string{"users._id:watchlists.watcher_ids"}
+
+	tests := []struct {
+		name           string
+		dbname         string
+		key            string
+		value          string
+		err            error
+		returnValCount int
+	}{
+		{
+			"Search an array element and the watching user",
+			"watchlists",
+			"watcher_ids",
+			"5",
+			nil,
+			3,
+		},
+		{
+			"Search for a user and the lists watched",
+			"users",
+			"_id",
+			"1",
+			nil,
+			3,
+		},
+		{
+			"Search for tags shared by organizations",
+			"organizations",
+			"tags",
+			"Cherry",
+			nil,
+			1,
+		},
+	}
+	for _, indexed := range []bool{false, true} {
+		jsonDb, err := Load(files)
+		assert.Equal(t, err, nil)
+		if indexed {
+			assert.Equal(t, jsonDb.BuildIndex("users", "_id"), nil)
+			assert.Equal(t, jsonDb.BuildIndex("watchlists", "watcher_ids"), nil)
+			assert.Equal(t, jsonDb.BuildIndex("organizations", "tags"), nil)
+		}
+		for _, test := range tests {
+			log.Println("Test: ", test.name, "indexed:", indexed)
+			results, err := jsonDb.Search(test.dbname, test.key, test.value, relations)
+			assert.Equal(t, err, test.err)
+			assert.Equal(t, len(results), test.returnValCount)
+		}
+	}
+
+	jsonDb, err := Load(files)
+	assert.Equal(t, err, nil)
+	report, err := jsonDb.CheckIntegrity(relations)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(report.Violations), 1)
+	assert.Equal(t, report.Violations[0].Value, "555")
+}
+
+func TestReverseRelationships(t *testing.T) {
+	files := []string{
+		"./testdata/organizations.json",
+		"./testdata/tickets.json",
+		"./testdata/users.json",
+	}
+	jsonDb, err := Load(files)
+	assert.Equal(t, err, nil)
+
+	tests := []struct {
+		name           string
+		dbname         string
+		key            string
+		value          string
+		relations      []string
+		err            error
+		returnValCount int
+	}{
+		{
+			"Search the left side of a relationship",
+			"organizations",
+			"_id",
+			"119",
+			[]string{"organizations._id:users.organization_id"},
+			nil,
+			5,
+		},
+		{
+			"Search the right side of a relationship",
+			"users",
+			"organization_id",
+			"119",
+			[]string{"organizations._id:users.organization_id"},
+			nil,
+			5,
+		},
+		{
+			"Search the right side of a one way relationship",
+			"users",
+			"organization_id",
+			"119",
+			[]string{"organizations._id>users.organization_id"},
+			nil,
+			4,
+		},
+		{
+			"Search the left side of a one way relationship",
+			"organizations",
+			"_id",
+			"119",
+			[]string{"organizations._id>users.organization_id"},
+			nil,
+			5,
+		},
+		{
+			"Search through several relationships",
+			"tickets",
+			"organization_id",
+			"119",
+			[]string{
+				"organizations._id:users.organization_id",
+				"organizations._id:tickets.organization_id",
+			},
+			nil,
+			8,
+		},
+	}
+	for _, test := range tests {
+		log.Println("Test: ", test.name)
+		results, err := jsonDb.Search(test.dbname, test.key, test.value, test.relations)
+		assert.Equal(t, err, test.err)
+		assert.Equal(t, len(results), test.returnValCount)
+	}
+
+	rel, err := ParseRelationship("organizations._id>users.organization_id")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, rel.OneWay, true)
+	assert.Equal(t, rel.String(), "organizations._id>users.organization_id")
+	_, err = ParseRelationship("organizations._id")
+	assert.Equal(t, err, ErrInvalidRelationship)
+}
+
+func TestJoin(t *testing.T) {
+	files := []string{
+		"./testdata/organizations.json",
+		"./testdata/tickets.json",
+		"./testdata/users.json",
+	}
+	jsonDb, err := Load(files)
+	assert.Equal(t, err, nil)
+	relations := []string{
+		"organizations._id:tickets.organization_id",
+		"users._id:tickets.submitter_id",
+		"users._id:tickets.assignee_id",
+	}
+	columns := []Column{
+		ParseColumn("tickets.subject"),
+		ParseColumn("organizations.name as organization"),
+	}
+
+	tests := []struct {
+		name     string
+		joins    []string
+		columns  []Column
+		err      error
+		rowCount int
+	}{
+		{
+			"Inner join drops tickets without an organization",
+			[]string{"organizations"},
+			columns,
+			nil,
+			195,
+		},
+		{
+			"Left join keeps tickets without an organization",
+			[]string{"left organizations"},
+			columns,
+			nil,
+			200,
+		},
+		{
+			"Join the same database twice with aliases",
+			[]string{
+				"users as submitter on users._id:tickets.submitter_id",
+				"users as assignee on users._id:tickets.assignee_id",
+			},
+			[]Column{ParseColumn("submitter.name"), ParseColumn("assignee.name")},
+			nil,
+			194,
+		},
+		{
+			"Join with more than one matching relationship",
+			[]string{"users"},
+			nil,
+			ErrAmbiguousJoin,
+			0,
+		},
+		{
+			"Join with an unknown alias in the columns",
+			[]string{"organizations"},
+			[]Column{ParseColumn("nosuchalias.name")},
+			ErrUnknownAlias,
+			0,
+		},
+	}
+	for _, test := range tests {
+		log.Println("Test: ", test.name)
+		q := JoinQuery{From: "tickets", Columns: test.columns, Relations: relations}
+		for _, j := range test.joins {
+			spec, err := ParseJoin(j)
+			assert.Equal(t, err, nil)
+			q.Joins = append(q.Joins, spec)
+		}
+		result, err := jsonDb.Join(q)
+		assert.True(t, errors.Is(err, test.err))
+		if err == nil {
+			assert.Equal(t, len(result.Rows), test.rowCount)
+			assert.Equal(t, len(result.Columns), len(test.columns))
+		}
+	}
+
+	q := JoinQuery{
+		From:      "tickets",
+		Joins:     []JoinSpec{{DB: "organizations", Type: LeftJoin}},
+		Columns:   columns,
+		Relations: relations,
+	}
+	result, err := jsonDb.Join(q)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, result.Columns, []string{"tickets.subject", "organization"})
+	assert.Equal(t, result.Rows[0], []interface{}{"A Catastrophe in Korea (North)", "Zentry"})
+}
+
+func TestAggregate(t *testing.T) {
+	files := []string{
+		"./testdata/organizations.json",
+		"./testdata/tickets.json",
+		"./testdata/users.json",
+	}
+	jsonDb, err := Load(files)
+	assert.Equal(t, err, nil)
+
+	count, _ := ParseAggregate("count")
+	distinctTags, _ := ParseAggregate("distinct:tags")
+	maxID, _ := ParseAggregate("max:_id")
+	_, err = ParseAggregate("sum")
+	assert.True(t, errors.Is(err, ErrInvalidAggregate))
+
+	tests := []struct {
+		name       string
+		query      AggregateQuery
+		err        error
+		groupCount int
+	}{
+		{
+			"Count the tickets by status",
+			AggregateQuery{DB: "tickets", GroupBy: []string{"status"}},
+			nil,
+			5,
+		},
+		{
+			"Count the open tickets per organization",
+			AggregateQuery{
+				DB:         "tickets",
+				GroupBy:    []string{"organization_id"},
+				Aggregates: []Aggregate{count},
+				Key:        "status",
+				Value:      "open",
+			},
+			nil,
+			20,
+		},
+		{
+			"Group tickets by organization and status",
+			AggregateQuery{DB: "tickets", GroupBy: []string{"organization_id", "status"}},
+			nil,
+			102,
+		},
+		{
+			"Aggregate without a group",
+			AggregateQuery{DB: "users", Aggregates: []Aggregate{count, distinctTags, maxID}},
+			nil,
+			1,
+		},
+		{
+			"Aggregate an unknown database",
+			AggregateQuery{DB: "wrongobject"},
+			ErrInvalidDatabase,
+			0,
+		},
+	}
+	for _, test := range tests {
+		log.Println("Test: ", test.name)
+		result, err := jsonDb.Aggregate(test.query)
+		assert.Equal(t, err, test.err)
+		if err == nil {
+			assert.Equal(t, len(result.Groups), test.groupCount)
+		}
+	}
+
+	result, err := jsonDb.Aggregate(AggregateQuery{
+		DB:         "users",
+		Aggregates: []Aggregate{count, distinctTags, maxID},
+	})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, result.Columns(), []string{"count", "distinct(tags)", "max(_id)"})
+	assert.Equal(t, result.Groups[0].Values[0], 75)
+	assert.Equal(t, len(result.Groups[0].Values[1].([]interface{})), 300)
+	assert.Equal(t, result.Groups[0].Values[2], float64(75))
+
+	// grouping on an indexed key uses the index
+	scanned, err := jsonDb.Aggregate(AggregateQuery{DB: "tickets", GroupBy: []string{"tags"}})
+	assert.Equal(t, err, nil)
+	err = jsonDb.BuildIndex("tickets", "tags")
+	assert.Equal(t, err, nil)
+	indexed, err := jsonDb.Aggregate(AggregateQuery{DB: "tickets", GroupBy: []string{"tags"}})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, indexed.Rows(), scanned.Rows())
+
+	// aggregates are named with as
+	avg, err := ParseAggregate("avg:price AS mean")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, avg, Aggregate{Func: Avg, Path: "price", As: "mean"})
+	avg, err = ParseAggregate(" count as n ")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, avg.Name(), "n")
+	_, err = ParseAggregate("count as ")
+	assert.True(t, errors.Is(err, ErrInvalidAggregate))
+	_, err = ParseAggregate("sum as total")
+	assert.True(t, errors.Is(err, ErrInvalidAggregate))
+
+	// index values truncate numbers, the groups keep the values apart
+	dir := t.TempDir()
+	prices := filepath.Join(dir, "prices.json")
+	assert.Equal(t, ioutil.WriteFile(prices, []byte(`[{"price": 1}, {"price": 1.5},
+		{"price": [1.5, 1.25, 1.5]}, {"price": 2}, {"price": "1"}]`), 0644), nil)
+	jsonDb, err = Load([]string{prices})
+	assert.Equal(t, err, nil)
+	scanned, err = jsonDb.Aggregate(AggregateQuery{DB: "prices", GroupBy: []string{"price"}})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, jsonDb.BuildIndex("prices", "price"), nil)
+	indexed, err = jsonDb.Aggregate(AggregateQuery{DB: "prices", GroupBy: []string{"price"}})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, indexed.Rows(), [][]interface{}{
+		{float64(1), 1}, {1.25, 1}, {1.5, 2}, {float64(2), 1}, {"1", 1},
+	})
+	assert.Equal(t, indexed.Rows(), scanned.Rows())
+
+	// the index of an object holds its nested objects, the object is
+	// grouped as a single record either way
+	config := filepath.Join(dir, "config.json")
+	assert.Equal(t, ioutil.WriteFile(config, []byte(`{"name": "prod", "web": {"status": "open"}}`), 0644), nil)
+	jsonDb, err = Load([]string{config})
+	assert.Equal(t, err, nil)
+	q := AggregateQuery{DB: "config", GroupBy: []string{"status"}, Aggregates: []Aggregate{{Func: Count, Path: "name"}}}
+	scanned, err = jsonDb.Aggregate(q)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, jsonDb.BuildIndex("config", "status"), nil)
+	indexed, err = jsonDb.Aggregate(q)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, indexed.Rows(), [][]interface{}{{"open", 1}})
+	assert.Equal(t, indexed.Rows(), scanned.Rows())
+
+	// groups of keys comparing equal come out in the same order
+	shapes := filepath.Join(dir, "shapes.json")
+	assert.Equal(t, ioutil.WriteFile(shapes, []byte(`[{"v": {"c": 1}}, {"v": {"a": 1}},
+		{"v": {"b": 1}}, {"v": {"a": 1}}]`), 0644), nil)
+	jsonDb, err = Load([]string{shapes})
+	assert.Equal(t, err, nil)
+	for n := 0; n < 10; n++ {
+		res, err := jsonDb.Aggregate(AggregateQuery{DB: "shapes", GroupBy: []string{"v"}})
+		assert.Equal(t, err, nil)
+		assert.Equal(t, res.Rows(), [][]interface{}{
+			{map[string]interface{}{"a": float64(1)}, 2},
+			{map[string]interface{}{"b": float64(1)}, 1},
+			{map[string]interface{}{"c": float64(1)}, 1},
+		})
+	}
+}
+
+func TestSearchPage(t *testing.T) {
+	files := []string{
+		"./testdata/organizations.json",
+		"./testdata/tickets.json",
+		"./testdata/users.json",
+	}
+	jsonDb, err := Load(files)
+	assert.Equal(t, err, nil)
+
+	keys, err := ParseSort("-priority,subject:asc")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, keys, []SortKey{{Path: "priority", Desc: true}, {Path: "subject"}})
+	_, err = ParseSort("-")
+	assert.Equal(t, err, ErrInvalidSort)
+
+	tests := []struct {
+		name           string
+		opts           SearchOptions
+		err            error
+		returnValCount int
+		hasNext        bool
+	}{
+		{
+			"All the results",
+			SearchOptions{Sort: keys},
+			nil,
+			39,
+			false,
+		},
+		{
+			"First page",
+			SearchOptions{Sort: keys, Limit: 10},
+			nil,
+			10,
+			true,
+		},
+		{
+			"Last page",
+			SearchOptions{Sort: keys, Limit: 10, Offset: 30},
+			nil,
+			9,
+			false,
+		},
+		{
+			"Offset past the results",
+			SearchOptions{Offset: 100},
+			nil,
+			0,
+			false,
+		},
+		{
+			"Negative limit",
+			SearchOptions{Limit: -1},
+			ErrInvalidPage,
+			0,
+			false,
+		},
+		{
+			"Malformed cursor",
+			SearchOptions{Cursor: "nocursor"},
+			ErrInvalidCursor,
+			0,
+			false,
+		},
+	}
+	for _, test := range tests {
+		log.Println("Test: ", test.name)
+		page, err := jsonDb.SearchPage("tickets", "status", "open", nil, test.opts)
+		assert.Equal(t, err, test.err)
+		if err == nil {
+			assert.Equal(t, len(page.Results), test.returnValCount)
+			assert.Equal(t, page.NextCursor != "", test.hasNext)
+		}
+	}
+
+	// walking the pages with the cursor returns every result in order
+	all, err := jsonDb.SearchPage("tickets", "status", "open", nil, SearchOptions{Sort: keys})
+	assert.Equal(t, err, nil)
+	var walked []interface{}
+	opts := SearchOptions{Sort: keys, Limit: 7}
+	for {
+		page, err := jsonDb.SearchPage("tickets", "status", "open", nil, opts)
+		assert.Equal(t, err, nil)
+		walked = append(walked, page.Results...)
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+	assert.Equal(t, walked, all.Results)
+	for n := 1; n < len(walked); n++ {
+		prev := walked[n-1].(map[string]interface{})["priority"].(string)
+		cur := walked[n].(map[string]interface{})["priority"].(string)
+		assert.True(t, prev >= cur)
+	}
+
+	// a cursor belongs to the search it was returned for
+	_, err = jsonDb.SearchPage("tickets", "status", "pending", nil, opts)
+	assert.Equal(t, err, ErrInvalidCursor)
+
+	// the values of an object database are paged in the order of their
+	// keys without a sort, with or without an index
+	var b strings.Builder
+	b.WriteString("{")
+	for n := 0; n < 50; n++ {
+		if n > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, `"k%02d": {"_id": %d, "group": "a"}`, n, n)
+	}
+	b.WriteString("}")
+	groups := filepath.Join(t.TempDir(), "groups.json")
+	assert.Equal(t, ioutil.WriteFile(groups, []byte(b.String()), 0644), nil)
+	jsonDb, err = Load([]string{groups})
+	assert.Equal(t, err, nil)
+	for _, indexed := range []bool{false, true} {
+		if indexed {
+			assert.Equal(t, jsonDb.BuildIndex("groups", "group"), nil)
+		}
+		var ids []interface{}
+		opts := SearchOptions{Limit: 7}
+		for {
+			page, err := jsonDb.SearchPage("groups", "group", "a", nil, opts)
+			assert.Equal(t, err, nil)
+			for _, r := range page.Results {
+				ids = append(ids, r.(map[string]interface{})["_id"])
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			opts.Cursor = page.NextCursor
+		}
+		assert.Equal(t, len(ids), 50)
+		for n, id := range ids {
+			assert.Equal(t, id, float64(n))
+		}
+	}
+}
+
+func TestProjection(t *testing.T) {
+	files := []string{
+		"./testdata/organizations.json",
+	}
+	jsonDb, err := Load(files)
+	assert.Equal(t, err, nil)
+
+	tests := []struct {
+		name   string
+		fields string
+		err    error
+		result map[string]interface{}
+	}{
+		{
+			"Include and rename fields",
+			"_id,name as organization",
+			nil,
+			map[string]interface{}{"_id": float64(101), "organization": "Enthaze"},
+		},
+		{
+			"Computed fields",
+			"_id,tag_count=len(tags),has_url=exists(url),NAME=upper(name)",
+			nil,
+			map[string]interface{}{
+				"_id":       float64(101),
+				"tag_count": float64(4),
+				"has_url":   true,
+				"NAME":      "ENTHAZE",
+			},
+		},
+		{
+			"Exclude fields",
+			"-url,-external_id,-domain_names,-tags,-created_at,-details,-shared_tickets",
+			nil,
+			map[string]interface{}{"_id": float64(101), "name": "Enthaze"},
+		},
+		{
+			"Unknown computed function",
+			"n=nosuchfunc(name)",
+			ErrInvalidField,
+			nil,
+		},
+	}
+	for _, test := range tests {
+		log.Println("Test: ", test.name)
+		p, err := ParseProjection(test.fields)
+		assert.True(t, errors.Is(err, test.err))
+		if err != nil {
+			continue
+		}
+		page, err := jsonDb.SearchPage("organizations", "_id", "101", nil, SearchOptions{Fields: p})
+		assert.Equal(t, err, nil)
+		assert.Equal(t, page.Results[0], test.result)
+	}
+
+	// projecting leaves the database records unchanged
+	p, _ := ParseProjection("-name")
+	_, err = jsonDb.SearchPage("organizations", "_id", "101", nil, SearchOptions{Fields: p})
+	assert.Equal(t, err, nil)
+	results, err := jsonDb.Search("organizations", "_id", "101", nil)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, results[0].(map[string]interface{})["name"], "Enthaze")
+
+	// nested key paths keep their structure unless renamed
+	p, _ = ParseProjection("a.b,a.c as c")
+	rec := map[string]interface{}{
+		"a": map[string]interface{}{"b": "x", "c": "y", "d": "z"},
+	}
+	assert.Equal(t, p.Apply(rec), map[string]interface{}{
+		"a": map[string]interface{}{"b": "x"},
+		"c": "y",
+	})
+	p, _ = ParseProjection("-a.d")
+	assert.Equal(t, p.Apply(rec), map[string]interface{}{
+		"a": map[string]interface{}{"b": "x", "c": "y"},
+	})
+	assert.Equal(t, len(rec["a"].(map[string]interface{})), 3)
+}
+
+func TestMatchPaths(t *testing.T) {
+	files := []string{
+		"./testdata/organizations.json",
+		"./testdata/users.json",
+	}
+	jsonDb, err := Load(files)
+	assert.Equal(t, err, nil)
+	relations := []string{"organizations._id:users.organization_id"}
+
+	page, err := jsonDb.SearchPage("organizations", "tags", "West", nil, SearchOptions{Matches: true})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, page.Matches, [][]string{{"tags[1]"}})
+
+	page, err = jsonDb.SearchPage("organizations", "_id", "101", relations, SearchOptions{Matches: true})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(page.Matches), len(page.Results))
+	assert.Equal(t, page.Matches[0], []string{"_id"})
+	for _, m := range page.Matches[1:] {
+		assert.Equal(t, m, []string{"organization_id"})
+	}
+
+	page, err = jsonDb.SearchPage("organizations", "_id", "101", nil, SearchOptions{})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(page.Matches), 0)
+
+	// a related record matches on the related key only, even when its
+	// searched key holds the value, and keeps its matches when sorted
+	dir := t.TempDir()
+	orgs := filepath.Join(dir, "orgs.json")
+	users := filepath.Join(dir, "users.json")
+	assert.Equal(t, ioutil.WriteFile(orgs, []byte(`[{"_id": 1}]`), 0644), nil)
+	assert.Equal(t, ioutil.WriteFile(users, []byte(`[{"_id": 1, "org_id": 1}, {"_id": 2, "org_id": 1}]`), 0644), nil)
+	relDb, err := Load([]string{orgs, users})
+	assert.Equal(t, err, nil)
+	sortKeys, _ := ParseSort("-_id")
+	page, err = relDb.SearchPage("orgs", "_id", "1", []string{"orgs._id:users.org_id"},
+		SearchOptions{Matches: true, Sort: sortKeys})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, page.Results, []interface{}{
+		map[string]interface{}{"_id": 2.0, "org_id": 1.0},
+		map[string]interface{}{"_id": 1.0},
+		map[string]interface{}{"_id": 1.0, "org_id": 1.0},
+	})
+	assert.Equal(t, page.Matches, [][]string{{"org_id"}, {"_id"}, {"org_id"}})
+	var keys []string
+	relDb.SearchEach("orgs", "_id", "1", []string{"orgs._id:users.org_id"}, func(r Result) bool {
+		keys = append(keys, r.DB+"."+r.Key)
+		return true
+	})
+	assert.Equal(t, keys, []string{"orgs._id", "users.org_id", "users.org_id"})
+
+	rec := map[string]interface{}{
+		"via": map[string]interface{}{
+			"source": map[string]interface{}{"name": "web"},
+			"names":  []interface{}{"mail", "web"},
+		},
+		"name": "web",
+	}
+	assert.Equal(t, MatchPaths("name", "web", rec), []string{"name", "via.source.name"})
+	assert.Equal(t, MatchPaths("name", "mail", rec), []string{})
+	annotated := Annotate(rec, []string{"name"}).(map[string]interface{})
+	assert.Equal(t, annotated[MatchesKey], []interface{}{"name"})
+	_, ok := rec[MatchesKey]
+	assert.False(t, ok)
+
+	// the paths follow the projection of the results
+	paths := []string{"name", "via.names[1]", "via.source.name"}
+	tests := []struct {
+		name   string
+		fields string
+		paths  []string
+	}{
+		{"Included fields", "name,via.source", []string{"name", "via.source.name"}},
+		{"Renamed fields", "name as title,via.names as names", []string{"title", "names[1]"}},
+		{"Renamed nested field", "via.source.name as source", []string{"source"}},
+		{"Excluded fields", "-via.source", []string{"name", "via.names[1]"}},
+		{"Computed field", "name=upper(name)", []string{"via.names[1]", "via.source.name"}},
+		{"Field prefix", "via.source.n", []string{}},
+	}
+	for _, test := range tests {
+		log.Println("Test: ", test.name)
+		p, err := ParseProjection(test.fields)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, p.ApplyPaths(paths), test.paths)
+	}
+	var p *Projection
+	assert.Equal(t, p.ApplyPaths(paths), paths)
+
+	fields, _ := ParseProjection("_id as id,name")
+	page, err = jsonDb.SearchPage("organizations", "_id", "101", nil, SearchOptions{Matches: true, Fields: fields})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, page.Matches, [][]string{{"id"}})
+}
+
+func TestQuery(t *testing.T) {
+	files := []string{
+		"./testdata/organizations.json",
+		"./testdata/tickets.json",
+	}
+	jsonDb, err := Load(files)
+	assert.Equal(t, err, nil)
+	jsonDb.BuildIndex("tickets", "status")
+	relations := []string{"organizations._id:tickets.organization_id"}
+
+	tests := []struct {
+		name      string
+		query     string
+		err       error
+		rowCount  int
+		pageCount int
+	}{
+		{
+			"Search",
+			"from tickets where status = open sort by -priority limit 5",
+			nil,
+			0,
+			5,
+		},
+		{
+			"Search a quoted value",
+			"from tickets where subject = 'A Drama in Uruguay' select _id, subject",
+			nil,
+			0,
+			1,
+		},
+		{
+			"All the records",
+			"FROM organizations ORDER BY name offset 20",
+			nil,
+			0,
+			5,
+		},
+		{
+			"Join",
+			"from tickets left join organizations select tickets.subject, organizations.name as org limit 7",
+			nil,
+			7,
+			0,
+		},
+		{
+			"Aggregation",
+			"from tickets group by status agg count sort -count",
+			nil,
+			5,
+			0,
+		},
+		{
+			"Missing from",
+			"where status = open",
+			ErrInvalidQuery,
+			0,
+			0,
+		},
+		{
+			"Unterminated quote",
+			"from tickets where subject = 'A Drama",
+			ErrInvalidQuery,
+			0,
+			0,
+		},
+		{
+			"Repeated clause",
+			"from tickets limit 1 limit 2",
+			ErrInvalidQuery,
+			0,
+			0,
+		},
+		{
+			"Unknown database",
+			"from nothing where _id = 1",
+			ErrInvalidDatabase,
+			0,
+			0,
+		},
+	}
+
+	for _, test := range tests {
+		log.Println("Test: ", test.name)
+		q, err := ParseQuery(test.query)
+		if err == nil {
+			q.Relations = relations
+			var result *QueryResult
+			result, err = jsonDb.Query(q)
+			if err == nil {
+				assert.Equal(t, len(result.Rows), test.rowCount)
+				if result.Page != nil {
+					assert.Equal(t, len(result.Page.Results), test.pageCount)
+				}
+			}
+		}
+		assert.True(t, errors.Is(err, test.err), err)
+	}
+
+	q, err := ParseQuery("from tickets group by status agg count sort -count")
+	assert.Equal(t, err, nil)
+	result, err := jsonDb.Query(q)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, result.Rows[0], []interface{}{"pending", 45})
+}
+
+func TestCatalog(t *testing.T) {
+	files := []string{
+		"./testdata/organizations.json",
+		"./testdata/users.json",
+	}
+	jsonDb, err := Load(files)
+	assert.Equal(t, err, nil)
+	jsonDb.BuildIndex("users", "_id")
+
+	assert.Equal(t, jsonDb.Names(), []string{"organizations", "users"})
+	records, err := jsonDb.Records("users")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(records), 75)
+	keys, err := jsonDb.Keys("organizations")
+	assert.Equal(t, err, nil)
+	assert.Contains(t, keys, "tags")
+	assert.Contains(t, keys, "domain_names")
+	_, err = jsonDb.Keys("nothing")
+	assert.Equal(t, err, ErrInvalidDatabase)
+	assert.Equal(t, jsonDb.Indexes(), []IndexInfo{{DB: "users", Key: "_id", Values: 75, Entries: 75}})
+	values, ok := jsonDb.IndexedValues("users", "_id")
+	assert.True(t, ok)
+	assert.Equal(t, len(values), 75)
+	assert.Equal(t, values[0:3], []string{"1", "10", "11"})
+	_, ok = jsonDb.IndexedValues("users", "name")
+	assert.False(t, ok)
+}
+
+func TestSchema(t *testing.T) {
+	files := []string{
+		"./testdata/organizations.json",
+		"./testdata/tickets.json",
+	}
+	jsonDb, err := Load(files)
+	assert.Equal(t, err, nil)
+
+	tests := []struct {
+		name     string
+		dbname   string
+		path     string
+		typ      string
+		presence float64
+		nullable bool
+		distinct int
+	}{
+		{"Unique key", "organizations", "_id", "number", 1, false, 25},
+		{"Missing values", "tickets", "assignee_id", "number", 0.98, false, 71},
+		{"List values", "organizations", "tags", "array", 1, false, 100},
+		{"Few values", "tickets", "status", "string", 1, false, 5},
+	}
+
+	for _, test := range tests {
+		log.Println("Test: ", test.name)
+		s, err := jsonDb.Schema(test.dbname)
+		assert.Equal(t, err, nil)
+		f, ok := s.Field(test.path)
+		assert.True(t, ok)
+		assert.Equal(t, f.Type(), test.typ)
+		assert.Equal(t, f.Presence, test.presence)
+		assert.Equal(t, f.Nullable, test.nullable)
+		assert.Equal(t, f.Distinct, test.distinct)
+		assert.True(t, len(f.Samples) <= maxSamples)
+	}
+
+	s, err := jsonDb.Schema("organizations")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, s.Records, 25)
+	tags, _ := s.Field("tags")
+	assert.Equal(t, tags.Items, map[string]int{"string": 100})
+	_, err = jsonDb.Schema("nothing")
+	assert.Equal(t, err, ErrInvalidDatabase)
+
+	// missing values are reported by the presence, null values by
+	// nullable
+	file := filepath.Join(t.TempDir(), "values.json")
+	assert.Equal(t, ioutil.WriteFile(file, []byte(`[{"a": 1, "b": null}, {"a": 2, "b": 3}, {"b": null}]`), 0644), nil)
+	jsonDb, err = Load([]string{file})
+	assert.Equal(t, err, nil)
+	s, err = jsonDb.Schema("values")
+	assert.Equal(t, err, nil)
+	a, _ := s.Field("a")
+	assert.Equal(t, []interface{}{a.Present, a.Nullable, a.Type()}, []interface{}{2, false, "number"})
+	b, _ := s.Field("b")
+	assert.Equal(t, []interface{}{b.Present, b.Nullable, b.Type()}, []interface{}{3, true, "number"})
+	assert.Equal(t, b.Types, map[string]int{"null": 2, "number": 1})
+}
+
+func TestValidate(t *testing.T) {
+	files := []string{
+		"./testdata/organizations.json",
+		"./testdata/users.json",
+	}
+	schemas := map[string]string{"users": "./testdata/users.schema.json"}
+
+	_, err := LoadWithOptions(files, LoadOptions{Schemas: schemas})
+	assert.True(t, errors.Is(err, ErrValidation))
+	var serrs SchemaErrors
+	assert.True(t, errors.As(err, &serrs))
+	assert.Equal(t, len(serrs), 2)
+	assert.Equal(t, serrs[0].DB, "users")
+	assert.Equal(t, serrs[0].Pointer, "")
+	assert.Equal(t, serrs[0].Keyword, "/required")
+
+	jsonDb, err := LoadWithOptions(files, LoadOptions{Schemas: schemas, Validate: ValidateWarn})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, jsonDb.ValidationErrors(), []ValidationError(serrs))
+
+	// a type mismatch is reported at the pointer of the value
+	schema := filepath.Join(t.TempDir(), "organizations.schema.json")
+	err = ioutil.WriteFile(schema, []byte(`{"properties": {"tags": {"items": {"type": "number"}}}}`), 0644)
+	assert.Equal(t, err, nil)
+	errs, err := jsonDb.Validate("organizations", schema)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(errs), 100)
+	assert.Equal(t, errs[1].Record, 0)
+	assert.Equal(t, errs[1].Pointer, "/tags/1")
+
+	_, err = jsonDb.Validate("organizations", "./testdata/missing.schema.json")
+	assert.True(t, errors.Is(err, ErrInvalidSchema))
+	_, err = jsonDb.Validate("nothing", schema)
+	assert.Equal(t, err, ErrInvalidDatabase)
+}
+
+func TestRelatedRecords(t *testing.T) {
+	files := []string{
+		"./testdata/organizations.json",
+		"./testdata/tickets.json",
+	}
+	jsonDb, err := Load(files)
+	assert.Equal(t, err, nil)
+	orgs, err := jsonDb.Search("organizations", "_id", "101", nil)
+	assert.Equal(t, err, nil)
+
+	tests := []struct {
+		name    string
+		fromKey string
+		toDB    string
+		toKey   string
+		err     error
+		count   int
+	}{
+		{"Tickets of the organization", "_id", "tickets", "organization_id", nil, 4},
+		{"Missing key", "nokey", "tickets", "organization_id", nil, 0},
+		{"Unknown database", "_id", "nodb", "organization_id", ErrInvalidDatabase, 0},
+	}
+	for _, test := range tests {
+		log.Println("Test: ", test.name)
+		related, err := jsonDb.RelatedRecords(orgs[0], test.fromKey, test.toDB, test.toKey)
+		assert.Equal(t, err, test.err)
+		assert.Equal(t, len(related), test.count)
+	}
+
+	// a table finds the related records of every record
+	table, err := jsonDb.RelatedTable("tickets", "organization_id")
+	assert.Equal(t, err, nil)
+	all, err := jsonDb.Records("organizations")
+	assert.Equal(t, err, nil)
+	total := 0
+	for _, org := range all {
+		related, err := table.Records(org, "_id")
+		assert.Equal(t, err, nil)
+		total += len(related)
+	}
+	assert.Equal(t, total, 195)
+
+	// an index gives the same records
+	jsonDb.BuildIndex("tickets", "organization_id")
+	related, err := jsonDb.RelatedRecords(orgs[0], "_id", "tickets", "organization_id")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(related), 4)
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	files := []string{
+		"./testdata/organizations.json",
+		"./testdata/tickets.json",
+		"./testdata/users.json",
+	}
+	jsonDb, err := Load(files)
+	assert.Equal(t, err, nil)
+	relations := []string{
+		"organizations._id:tickets.organization_id",
+		"organizations._id:users.organization_id",
+	}
+	keys := []struct {
+		dbname string
+		key    string
+	}{
+		{"organizations", "_id"},
+		{"tickets", "organization_id"},
+		{"tickets", "status"},
+		{"users", "organization_id"},
+		{"users", "role"},
+	}
+	fields, err := ParseProjection("_id,via,via.x")
+	assert.Equal(t, err, nil)
+
+	// search while the indexes are built, the results must not
+	// depend on the indexes
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for _, k := range keys {
+		wg.Add(1)
+		go func(dbname, key string) {
+			defer wg.Done()
+			if err := jsonDb.BuildIndex(dbname, key); err != nil {
+				errs <- err
+			}
+		}(k.dbname, k.key)
+	}
+	for n := 0; n < 8; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				res, err := jsonDb.Search("organizations", "_id", "101", relations)
+				if err != nil || len(res) != 9 {
+					errs <- fmt.Errorf("search: %d results, %v", len(res), err)
+				}
+				page, err := jsonDb.SearchPage("tickets", "status", "open", nil, SearchOptions{Fields: fields})
+				if err != nil || page.Total != 39 {
+					errs <- fmt.Errorf("search page: %v", err)
+				}
+				agg, err := jsonDb.Aggregate(AggregateQuery{DB: "users", GroupBy: []string{"role"}})
+				if err != nil || len(agg.Rows()) != 3 {
+					errs <- fmt.Errorf("aggregate: %v", err)
+				}
+				join, err := jsonDb.Join(JoinQuery{
+					From:      "tickets",
+					Joins:     []JoinSpec{{DB: "organizations"}},
+					Relations: relations,
+				})
+				if err != nil || len(join.Rows) == 0 {
+					errs <- fmt.Errorf("join: %v", err)
+				}
+				jsonDb.Indexes()
+				jsonDb.IndexedValues("users", "role")
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	assert.Equal(t, len(jsonDb.Indexes()), len(keys))
+}
+
+func TestSearchContext(t *testing.T) {
+	files := []string{
+		"./testdata/organizations.json",
+		"./testdata/tickets.json",
+	}
+	jsonDb, err := Load(files)
+	assert.Equal(t, err, nil)
+	relations := []string{"organizations._id:tickets.organization_id"}
+
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	expiredCtx, cancelExpired := context.WithTimeout(context.Background(), -time.Second)
+	defer cancelExpired()
+
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		query string
+		err   error
+	}{
+		{"Search", context.Background(), "from tickets where status = open", nil},
+		{"Cancelled search", cancelledCtx, "from tickets where status = open", context.Canceled},
+		{"Expired search", expiredCtx, "from tickets where status = open", context.DeadlineExceeded},
+		{"Cancelled aggregation", cancelledCtx, "from tickets group by status agg count", context.Canceled},
+		{"Cancelled aggregation of a search", cancelledCtx, "from tickets where status = open agg count", context.Canceled},
+		{"Cancelled join", cancelledCtx, "from tickets join organizations", context.Canceled},
+		{"Cancelled records", cancelledCtx, "from tickets", context.Canceled},
+	}
+	for _, test := range tests {
+		log.Println("Test: ", test.name)
+		q, err := ParseQuery(test.query)
+		assert.Equal(t, err, nil)
+		q.Relations = relations
+		_, err = jsonDb.QueryContext(test.ctx, q)
+		assert.Equal(t, errors.Is(err, test.err), true)
+	}
+
+	// the related databases are scanned with the context
+	_, err = jsonDb.SearchContext(cancelledCtx, "organizations", "_id", "101", relations)
+	assert.Equal(t, err, context.Canceled)
+	_, err = jsonDb.SearchPageContext(cancelledCtx, "tickets", "status", "open", nil, SearchOptions{})
+	assert.Equal(t, err, context.Canceled)
+
+	// an indexed search does not scan
+	assert.Equal(t, jsonDb.BuildIndex("tickets", "status"), nil)
+	res, err := jsonDb.SearchContext(cancelledCtx, "tickets", "status", "open", nil)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(res), 39)
+
+	// the search of a single large record is cancelled while it is
+	// scanned, in a list or an object database
+	items := make([]string, 0, 100000)
+	for n := 0; n < 100000; n++ {
+		items = append(items, fmt.Sprintf(`{"id": %d, "tags": ["a", "b"]}`, n))
+	}
+	record := `{"items": [` + strings.Join(items, ",") + `]}`
+	dir := t.TempDir()
+	list := filepath.Join(dir, "list.json")
+	object := filepath.Join(dir, "object.json")
+	assert.Equal(t, ioutil.WriteFile(list, []byte("["+record+"]"), 0644), nil)
+	assert.Equal(t, ioutil.WriteFile(object, []byte(`{"large": `+record+`}`), 0644), nil)
+	jsonDb, err = Load([]string{list, object})
+	assert.Equal(t, err, nil)
+	for _, dbname := range []string{"list", "object"} {
+		log.Println("Test: Cancelled scan of", dbname)
+		ctx := &cancelAfter{Context: context.Background(), checks: 3}
+		_, err = jsonDb.SearchContext(ctx, dbname, "id", "-1", nil)
+		assert.Equal(t, err, context.Canceled)
+		assert.Equal(t, ctx.checked, 4)
+	}
+}
+
+// cancelAfter is a context cancelled after its error is checked a
+// number of times.
+type cancelAfter struct {
+	context.Context
+	checks  int
+	checked int
+}
+
+func (c *cancelAfter) Err() error {
+	c.checked++
+	if c.checked > c.checks {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestParallelSearch(t *testing.T) {
+	// a list large enough to be split between the workers
+	records := make([]string, 0, 10000)
+	for n := 0; n < 10000; n++ {
+		records = append(records, fmt.Sprintf(`{"id": %d, "group": "g%d", "tags": ["t%d"]}`, n, n%7, n%3))
+	}
+	file := filepath.Join(t.TempDir(), "items.json")
+	assert.Equal(t, ioutil.WriteFile(file, []byte("["+strings.Join(records, ",")+"]"), 0644), nil)
+	jsonDb, err := Load([]string{file})
+	assert.Equal(t, err, nil)
+
+	tests := []struct {
+		name  string
+		key   string
+		value string
+		count int
+	}{
+		{"Search a string", "group", "g3", 1429},
+		{"Search a number", "id", "9999", 1},
+		{"Search list values", "tags", "t0", 3334},
+		{"Value not found", "group", "g9", 0},
+	}
+	for _, test := range tests {
+		log.Println("Test: ", test.name)
+		var sequential []interface{}
+		for _, workers := range []int{1, 0, 3, 64} {
+			page, err := jsonDb.SearchPage("items", test.key, test.value, nil, SearchOptions{Workers: workers})
+			if test.count == 0 {
+				assert.Equal(t, err, ErrKeyValueNotFound)
+				continue
+			}
+			assert.Equal(t, err, nil)
+			assert.Equal(t, page.Total, test.count)
+			// the results keep the order of the records
+			if workers == 1 {
+				sequential = page.Results
+			}
+			assert.Equal(t, page.Results, sequential)
+		}
+	}
+
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = jsonDb.SearchPageContext(cancelledCtx, "items", "group", "g3", nil, SearchOptions{Workers: 4})
+	assert.Equal(t, err, context.Canceled)
+}
+
+func TestSearchEach(t *testing.T) {
+	files := []string{
+		"./testdata/organizations.json",
+		"./testdata/tickets.json",
+		"./testdata/users.json",
+	}
+	jsonDb, err := Load(files)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, jsonDb.BuildIndex("organizations", "_id"), nil)
+	relations := []string{
+		"organizations._id:tickets.organization_id",
+		"organizations._id:users.organization_id",
+	}
+
+	tests := []struct {
+		name      string
+		dbname    string
+		key       string
+		value     string
+		relations []string
+		dbs       int
+	}{
+		{"Indexed search with relationships", "organizations", "_id", "101", relations, 3},
+		{"Full scan", "tickets", "status", "open", nil, 1},
+		{"Full scan with relationships", "tickets", "organization_id", "101", relations, 2},
+		{"List values", "organizations", "tags", "Frank", nil, 1},
+	}
+	for _, test := range tests {
+		log.Println("Test: ", test.name)
+		expected, err := jsonDb.Search(test.dbname, test.key, test.value, test.relations)
+		assert.Equal(t, err, nil)
+
+		// all the results in the order of Search
+		var records []interface{}
+		dbs := make(map[string]int)
+		err = jsonDb.SearchEach(test.dbname, test.key, test.value, test.relations, func(r Result) bool {
+			records = append(records, r.Record)
+			dbs[r.DB]++
+			return true
+		})
+		assert.Equal(t, err, nil)
+		assert.Equal(t, records, expected)
+		assert.Equal(t, len(dbs), test.dbs)
+
+		// stop after the first result
+		records = nil
+		err = jsonDb.SearchEach(test.dbname, test.key, test.value, test.relations, func(r Result) bool {
+			records = append(records, r.Record)
+			return false
+		})
+		assert.Equal(t, err, nil)
+		assert.Equal(t, records, expected[0:1])
+	}
+
+	called := false
+	err = jsonDb.SearchEach("tickets", "status", "none", nil, func(r Result) bool {
+		called = true
+		return true
+	})
+	assert.Equal(t, err, ErrKeyValueNotFound)
+	assert.Equal(t, called, false)
+	err = jsonDb.SearchEach("nodb", "status", "open", nil, func(r Result) bool { return true })
+	assert.Equal(t, err, ErrInvalidDatabase)
+
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = jsonDb.SearchEachContext(cancelledCtx, "tickets", "status", "open", nil, func(r Result) bool { return true })
+	assert.Equal(t, err, context.Canceled)
+}
+
+func TestIndexFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{filepath.Join(dir, "organizations.json"), filepath.Join(dir, "tickets.json")}
+	for _, f := range files {
+		b, err := ioutil.ReadFile(filepath.Join("testdata", filepath.Base(f)))
+		assert.Equal(t, err, nil)
+		assert.Equal(t, ioutil.WriteFile(f, b, 0644), nil)
+	}
+
+	jsonDb, err := Load(files)
+	assert.Equal(t, err, nil)
+	_, err = jsonDb.LoadIndexes("tickets")
+	assert.Equal(t, os.IsNotExist(err), true)
+	_, err = VerifyIndexFile(files[1])
+	assert.Equal(t, os.IsNotExist(err), true)
+
+	assert.Equal(t, jsonDb.BuildIndex("tickets", "status"), nil)
+	assert.Equal(t, jsonDb.BuildIndex("tickets", "tags"), nil)
+	keys, err := jsonDb.SaveIndexes("tickets")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, keys, []string{"status", "tags"})
+	keys, err = VerifyIndexFile(files[1])
+	assert.Equal(t, err, nil)
+	assert.Equal(t, keys, []string{"status", "tags"})
+	expected, err := jsonDb.Search("tickets", "tags", "Ohio", nil)
+	assert.Equal(t, err, nil)
+
+	// a new load uses the saved indexes
+	reloaded, err := Load(files)
+	assert.Equal(t, err, nil)
+	keys, err = reloaded.LoadIndexes("tickets")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, keys, []string{"status", "tags"})
+	info := reloaded.Indexes()
+	assert.Equal(t, len(info), 2)
+	assert.Equal(t, info[0].Entries, 200)
+	res, err := reloaded.searchIndex("tickets", "tags", "Ohio")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, res, expected)
+	// the postings are the records of the reloaded database
+	records, _ := reloaded.Records("tickets")
+	ids := make(map[uintptr]bool)
+	for _, r := range records {
+		id, _ := recordID(r)
+		ids[id] = true
+	}
+	id, _ := recordID(res[0])
+	assert.Equal(t, ids[id], true)
+
+	// a changed database file invalidates the index file
+	b, _ := ioutil.ReadFile(files[1])
+	assert.Equal(t, ioutil.WriteFile(files[1], append(b, ' '), 0644), nil)
+	_, err = VerifyIndexFile(files[1])
+	assert.Equal(t, errors.Is(err, ErrStaleIndexFile), true)
+	changed, err := Load(files)
+	assert.Equal(t, err, nil)
+	_, err = changed.LoadIndexes("tickets")
+	assert.Equal(t, errors.Is(err, ErrStaleIndexFile), true)
+	assert.Equal(t, len(changed.Indexes()), 0)
+
+	assert.Equal(t, ClearIndexFile(files[1]), nil)
+	assert.Equal(t, os.IsNotExist(ClearIndexFile(files[1])), true)
+	_, err = jsonDb.SaveIndexes("nodb")
+	assert.Equal(t, err, ErrInvalidDatabase)
+}
+
+func TestSnapshot(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	data := `{"id": 1, "name": "config", "ratio": 0.25, "big": 12345678901234567890, "neg": -3,
+		"on": true, "off": false, "none": null, "tags": ["a", 2.5, []], "nested": {"empty": {}}}`
+	assert.Equal(t, ioutil.WriteFile(file, []byte(data), 0644), nil)
+	files := []string{
+		"./testdata/organizations.json",
+		"./testdata/tickets.json",
+		"./testdata/users.json",
+		file,
+	}
+	jsonDb, err := Load(files)
+	assert.Equal(t, err, nil)
+	relations := []string{
+		"organizations._id:tickets.organization_id",
+		"organizations._id:users.organization_id",
+	}
+	assert.Equal(t, jsonDb.SetRelationships(relations), nil)
+	for _, idx := range []struct{ db, key string }{
+		{"organizations", "_id"},
+		{"tickets", "organization_id"},
+		{"tickets", "tags"},
+		{"users", "organization_id"},
+		{"config", "name"},
+	} {
+		assert.Equal(t, jsonDb.BuildIndex(idx.db, idx.key), nil)
+	}
+
+	var buf bytes.Buffer
+	assert.Equal(t, jsonDb.Save(&buf), nil)
+	snapshot := buf.Bytes()
+	restored, err := Open(bytes.NewReader(snapshot))
+	assert.Equal(t, err, nil)
+
+	assert.Equal(t, restored.Names(), jsonDb.Names())
+	assert.Equal(t, restored.Relationships(), relations)
+	assert.Equal(t, restored.Indexes(), jsonDb.Indexes())
+	for _, name := range jsonDb.Names() {
+		expected, _ := jsonDb.Records(name)
+		records, err := restored.Records(name)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, records, expected)
+		source, _ := jsonDb.Source(name)
+		rsource, ok := restored.Source(name)
+		assert.Equal(t, ok, true)
+		assert.Equal(t, rsource.Hash, source.Hash)
+		assert.Equal(t, rsource.ModTime.Equal(source.ModTime), true)
+	}
+
+	tests := []struct {
+		name   string
+		dbname string
+		key    string
+		value  string
+	}{
+		{"Indexed search with relationships", "organizations", "_id", "101"},
+		{"Indexed list values", "tickets", "tags", "Ohio"},
+		{"Full scan", "users", "role", "admin"},
+		{"Object database", "config", "name", "config"},
+	}
+	for _, test := range tests {
+		log.Println("Test: ", test.name)
+		expected, err := jsonDb.Search(test.dbname, test.key, test.value, relations)
+		assert.Equal(t, err, nil)
+		results, err := restored.Search(test.dbname, test.key, test.value, restored.Relationships())
+		assert.Equal(t, err, nil)
+		assert.Equal(t, results, expected)
+	}
+
+	// the postings are the records of the restored database
+	records, _ := restored.Records("tickets")
+	ids := make(map[uintptr]bool)
+	for _, r := range records {
+		id, _ := recordID(r)
+		ids[id] = true
+	}
+	res, err := restored.searchIndex("tickets", "tags", "Ohio")
+	assert.Equal(t, err, nil)
+	for _, r := range res {
+		id, _ := recordID(r)
+		assert.Equal(t, ids[id], true)
+	}
+
+	// a snapshot of the restored database is identical
+	buf.Reset()
+	assert.Equal(t, restored.Save(&buf), nil)
+	again, err := Open(&buf)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, again.Indexes(), jsonDb.Indexes())
+
+	_, err = Open(strings.NewReader("{}"))
+	assert.Equal(t, errors.Is(err, ErrInvalidSnapshot), true)
+	_, err = Open(bytes.NewReader(append([]byte(snapshotMagic), 9)))
+	assert.Equal(t, errors.Is(err, ErrSnapshotVersion), true)
+	for _, n := range []int{5, len(snapshot) / 2, len(snapshot) - 1} {
+		_, err = Open(bytes.NewReader(snapshot[0:n]))
+		assert.Equal(t, errors.Is(err, ErrInvalidSnapshot), true)
+	}
+}
+
+func TestDiskStore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	assert.Equal(t, ioutil.WriteFile(file, []byte(`{"name": "config"}`), 0644), nil)
+	files := []string{
+		"./testdata/organizations.json",
+		"./testdata/tickets.json",
+		"./testdata/users.json",
+		file,
+	}
+	relations := []string{
+		"organizations._id:tickets.organization_id",
+		"organizations._id:users.organization_id",
+	}
+	memDb, err := Load(files)
+	assert.Equal(t, err, nil)
+	diskDb, err := LoadWithOptions(files, LoadOptions{DiskStore: true})
+	assert.Equal(t, err, nil)
+	defer diskDb.Close()
+
+	// the object database is loaded in memory
+	_, ok := diskDb.getDB("tickets").(*DiskStore)
+	assert.Equal(t, ok, true)
+	_, ok = diskDb.getDB("config").(map[string]interface{})
+	assert.Equal(t, ok, true)
+	for _, name := range memDb.Names() {
+		expected, _ := memDb.Records(name)
+		records, err := diskDb.Records(name)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, records, expected)
+		n, err := diskDb.Len(name)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, n, len(expected))
+		source, _ := memDb.Source(name)
+		dsource, _ := diskDb.Source(name)
+		assert.Equal(t, dsource.Hash, source.Hash)
+	}
+
+	tests := []struct {
+		name   string
+		dbname string
+		key    string
+		value  string
+		index  bool
+	}{
+		{"Full scan with relationships", "organizations", "_id", "101", false},
+		{"Indexed search with relationships", "organizations", "_id", "101", true},
+		{"List values", "tickets", "tags", "Ohio", false},
+		{"Nested key", "users", "role", "admin", false},
+		{"Object database", "config", "name", "config", false},
+	}
+	for _, test := range tests {
+		log.Println("Test: ", test.name)
+		if test.index {
+			for _, jdb := range []*JsonDB{memDb, diskDb} {
+				assert.Equal(t, jdb.BuildIndex("organizations", "_id"), nil)
+				assert.Equal(t, jdb.BuildIndex("tickets", "organization_id"), nil)
+				assert.Equal(t, jdb.BuildIndex("users", "organization_id"), nil)
+			}
+		}
+		expected, err := memDb.Search(test.dbname, test.key, test.value, relations)
+		assert.Equal(t, err, nil)
+		results, err := diskDb.Search(test.dbname, test.key, test.value, relations)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, results, expected)
+		var streamed []interface{}
+		err = diskDb.SearchEach(test.dbname, test.key, test.value, relations, func(r Result) bool {
+			streamed = append(streamed, r.Record)
+			return true
+		})
+		assert.Equal(t, err, nil)
+		assert.Equal(t, streamed, expected)
+	}
+	_, err = diskDb.Search("tickets", "status", "nostatus", nil)
+	assert.Equal(t, err, ErrKeyValueNotFound)
+
+	keys, _ := ParseSort("-priority,subject")
+	page, err := diskDb.SearchPage("tickets", "status", "open", nil, SearchOptions{Sort: keys, Limit: 5, Workers: 3})
+	assert.Equal(t, err, nil)
+	expectedPage, _ := memDb.SearchPage("tickets", "status", "open", nil, SearchOptions{Sort: keys, Limit: 5})
+	assert.Equal(t, page.Results, expectedPage.Results)
+	assert.Equal(t, page.Total, expectedPage.Total)
+
+	agg, err := diskDb.Aggregate(AggregateQuery{DB: "users", GroupBy: []string{"role"}})
+	assert.Equal(t, err, nil)
+	expectedAgg, _ := memDb.Aggregate(AggregateQuery{DB: "users", GroupBy: []string{"role"}})
+	assert.Equal(t, agg.Rows(), expectedAgg.Rows())
+
+	q := JoinQuery{From: "tickets", Joins: []JoinSpec{{DB: "organizations"}}, Relations: relations}
+	join, err := diskDb.Join(q)
+	assert.Equal(t, err, nil)
+	expectedJoin, _ := memDb.Join(q)
+	assert.Equal(t, join.Rows, expectedJoin.Rows)
+
+	assert.Equal(t, diskDb.BuildIndex("tickets", "tags"), nil)
+	assert.Equal(t, memDb.BuildIndex("tickets", "tags"), nil)
+	values, _ := diskDb.IndexedValues("tickets", "tags")
+	expectedValues, _ := memDb.IndexedValues("tickets", "tags")
+	assert.Equal(t, values, expectedValues)
+	// the postings of a store hold record positions
+	vIndex, _ := diskDb.index("tickets", "tags")
+	assert.Equal(t, vIndex.records == nil, true)
+	assert.Equal(t, diskDb.Indexes(), memDb.Indexes())
+
+	// features relying on shared in-memory records are unsupported
+	var buf bytes.Buffer
+	assert.Equal(t, errors.Is(diskDb.Save(&buf), ErrUnsupportedStore), true)
+	_, err = diskDb.SaveIndexes("tickets")
+	assert.Equal(t, errors.Is(err, ErrUnsupportedStore), true)
+
+	_, err = OpenDiskStore(file)
+	assert.Equal(t, errors.Is(err, ErrNotList), true)
+	bad := filepath.Join(t.TempDir(), "bad.json")
+	assert.Equal(t, ioutil.WriteFile(bad, []byte(`[{"id": 1}] x`), 0644), nil)
+	_, err = OpenDiskStore(bad)
+	assert.NotEqual(t, err, nil)
+	assert.Equal(t, ioutil.WriteFile(bad, []byte(`[{"id": 1}, {"id": 2]`), 0644), nil)
+	_, err = LoadWithOptions([]string{bad}, LoadOptions{DiskStore: true})
+	assert.NotEqual(t, err, nil)
+	empty := filepath.Join(t.TempDir(), "empty.json")
+	assert.Equal(t, ioutil.WriteFile(empty, []byte(" [ ] "), 0644), nil)
+	s, err := OpenDiskStore(empty)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, s.Len(), 0)
+	assert.Equal(t, s.Close(), nil)
+
+	// a file modified in place fails the reads instead of faulting
+	data, err := ioutil.ReadFile("./testdata/tickets.json")
+	assert.Equal(t, err, nil)
+	changed := filepath.Join(t.TempDir(), "changed.json")
+	assert.Equal(t, ioutil.WriteFile(changed, data, 0644), nil)
+	jsonDb, err := LoadWithOptions([]string{changed}, LoadOptions{DiskStore: true})
+	assert.Equal(t, err, nil)
+	defer jsonDb.Close()
+	assert.Equal(t, jsonDb.BuildIndex("changed", "status"), nil)
+	s = jsonDb.getDB("changed").(*DiskStore)
+	assert.Equal(t, ioutil.WriteFile(changed, []byte(`[{"status": "x"}]`), 0644), nil)
+	_, err = s.Record(0)
+	assert.Equal(t, errors.Is(err, ErrStoreChanged), true)
+	assert.Equal(t, os.Truncate(changed, 0), nil)
+	_, err = s.Record(s.Len() - 1)
+	assert.Equal(t, errors.Is(err, ErrStoreChanged), true)
+	_, err = jsonDb.Search("changed", "status", "open", nil)
+	assert.Equal(t, errors.Is(err, ErrStoreChanged), true)
+	_, err = jsonDb.search(context.Background(), "changed", "type", "task", nil, 4)
+	assert.Equal(t, errors.Is(err, ErrStoreChanged), true)
+	_, err = jsonDb.Keys("changed")
+	assert.Equal(t, errors.Is(err, ErrStoreChanged), true)
+}
+
+// replaceFile replaces a file by renaming a new file over it, like the
+// jobs regenerating the database files.
+func replaceFile(t *testing.T, path, data string) {
+	tmp := path + ".tmp"
+	assert.Equal(t, ioutil.WriteFile(tmp, []byte(data), 0644), nil)
+	assert.Equal(t, os.Rename(tmp, path), nil)
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	orgs := filepath.Join(dir, "orgs.json")
+	tickets := filepath.Join(dir, "tickets.json")
+	schema := filepath.Join(dir, "orgs.schema.json")
+	replaceFile(t, orgs, `[{"_id": 1, "name": "a"}, {"_id": 2, "name": "b"}]`)
+	replaceFile(t, tickets, `[{"id": 10, "org_id": 1}, {"id": 11, "org_id": 3}]`)
+	replaceFile(t, schema, `{"properties": {"name": {"type": "string"}}}`)
+	relations := []string{"orgs._id:tickets.org_id"}
+
+	for _, disk := range []bool{false, true} {
+		log.Println("Test: ", "Reload with disk store", disk)
+		replaceFile(t, orgs, `[{"_id": 1, "name": "a"}, {"_id": 2, "name": "b"}]`)
+		jsonDb, err := LoadWithOptions([]string{orgs, tickets}, LoadOptions{
+			Schemas:   map[string]string{"orgs": schema},
+			DiskStore: disk,
+		})
+		assert.Equal(t, err, nil)
+		assert.Equal(t, jsonDb.BuildIndex("orgs", "_id"), nil)
+		assert.Equal(t, jsonDb.BuildIndex("tickets", "org_id"), nil)
+		before, err := jsonDb.Search("orgs", "_id", "2", relations)
+		assert.Equal(t, err, nil)
+
+		replaceFile(t, orgs, `[{"_id": 1, "name": "c"}, {"_id": 3, "name": "d", "tier": "gold"}]`)
+		keys, err := jsonDb.Reload("orgs")
+		assert.Equal(t, err, nil)
+		assert.Equal(t, keys, []string{"_id"})
+		_, isStore := jsonDb.getDB("orgs").(*DiskStore)
+		assert.Equal(t, isStore, disk)
+		res, err := jsonDb.searchIndex("orgs", "_id", "3")
+		assert.Equal(t, err, nil)
+		assert.Equal(t, res, []interface{}{map[string]interface{}{"_id": 3.0, "name": "d", "tier": "gold"}})
+		res, err = jsonDb.Search("orgs", "_id", "3", relations)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, len(res), 2)
+		_, err = jsonDb.Search("orgs", "_id", "2", nil)
+		assert.Equal(t, err, ErrKeyValueNotFound)
+		keyPaths, _ := jsonDb.Keys("orgs")
+		assert.Equal(t, keyPaths, []string{"_id", "name", "tier"})
+		source, _ := jsonDb.Source("orgs")
+		fs, err := FileSource(orgs)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, source.matches(fs), true)
+		// the results found before the reload are kept
+		assert.Equal(t, before[0], map[string]interface{}{"_id": 2.0, "name": "b"})
+
+		// a failed reload keeps the loaded database
+		replaceFile(t, orgs, `[{"_id": 4, "name": "e"},`)
+		_, err = jsonDb.Reload("orgs")
+		assert.NotEqual(t, err, nil)
+		replaceFile(t, orgs, `[{"_id": 4, "name": 5}]`)
+		_, err = jsonDb.Reload("orgs")
+		assert.Equal(t, errors.Is(err, ErrValidation), true)
+		res, err = jsonDb.Search("orgs", "_id", "3", nil)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, len(res), 1)
+		_, err = jsonDb.Reload("nodb")
+		assert.Equal(t, err, ErrInvalidDatabase)
+		assert.Equal(t, jsonDb.Close(), nil)
+	}
+
+	// searches running during reloads see a consistent database
+	replaceFile(t, orgs, `[{"_id": 1, "name": "a"}, {"_id": 2, "name": "b"}]`)
+	jsonDb, err := LoadWithOptions([]string{orgs, tickets}, LoadOptions{DiskStore: true})
+	assert.Equal(t, err, nil)
+	defer jsonDb.Close()
+	assert.Equal(t, jsonDb.BuildIndex("orgs", "name"), nil)
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				res, err := jsonDb.Search("orgs", "_id", "1", relations)
+				if err != nil || len(res) != 2 {
+					errs <- fmt.Errorf("search: %v %v", res, err)
+				}
+				if _, err := jsonDb.Search("orgs", "name", "a", nil); err != nil {
+					errs <- fmt.Errorf("indexed search: %v", err)
+				}
+				jsonDb.BuildIndex("orgs", "_id")
+				jsonDb.Records("orgs")
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		replaceFile(t, orgs, fmt.Sprintf(`[{"_id": 1, "name": "a", "n": %d}]`, i))
+		_, err := jsonDb.Reload("orgs")
+		assert.Equal(t, err, nil)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// a search reads the databases and indexes of a single view
+	v := jsonDb.view()
+	replaceFile(t, orgs, `[{"_id": 1, "name": "b"}]`)
+	_, err = jsonDb.Reload("orgs")
+	assert.Equal(t, err, nil)
+	res, err := v.searchIndex("orgs", "name", "a")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(res), 1)
+	old, err := records(v.root("orgs"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, old, res)
+	_, err = jsonDb.searchIndex("orgs", "name", "a")
+	assert.Equal(t, err, ErrIndexNotFound)
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	orgs := filepath.Join(dir, "orgs.json")
+	replaceFile(t, orgs, `[{"_id": 1, "name": "a"}]`)
+	jsonDb, err := Load([]string{orgs})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, jsonDb.BuildIndex("orgs", "_id"), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan ReloadEvent, 10)
+	done := make(chan struct{})
+	go func() {
+		jsonDb.Watch(ctx, 20*time.Millisecond, func(e ReloadEvent) {
+			events <- e
+		})
+		close(done)
+	}()
+	wait := func() ReloadEvent {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reload")
+		}
+		return ReloadEvent{}
+	}
+
+	// let the watcher start before changing the file
+	time.Sleep(50 * time.Millisecond)
+	replaceFile(t, orgs, `[{"_id": 2, "name": "b"}]`)
+	e := wait()
+	assert.Equal(t, e.Err, nil)
+	assert.Equal(t, e.DB, "orgs")
+	assert.Equal(t, e.Indexes, []string{"_id"})
+	res, err := jsonDb.Search("orgs", "_id", "2", nil)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(res), 1)
+
+	replaceFile(t, orgs, `[{"_id": 3, `)
+	e = wait()
+	assert.NotEqual(t, e.Err, nil)
+	_, err = jsonDb.Search("orgs", "_id", "2", nil)
+	assert.Equal(t, err, nil)
+
+	replaceFile(t, orgs, `[{"_id": 3, "name": "c"}]`)
+	e = wait()
+	assert.Equal(t, e.Err, nil)
+	_, err = jsonDb.Search("orgs", "_id", "3", nil)
+	assert.Equal(t, err, nil)
+
+	cancel()
+	<-done
+}
+
 // initialize the JsonDB into package level variables to eliminate the
 // loading from the search operations during the benchmark tests.
 
@@ -257,6 +2145,15 @@ func benchmarkSearch(jsonDb *JsonDB, dbname, key, value string, b *testing.B) {
 	}
 }
 
+func benchmarkSearchWorkers(jsonDb *JsonDB, dbname, key, value string, workers int, b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		page, _ := jsonDb.SearchPage(dbname, key, value, nil, SearchOptions{Workers: workers})
+		if page != nil {
+			benchResult = page.Results
+		}
+	}
+}
+
 func BenchmarkIndexedSearchByKey(b *testing.B) {
 	benchmarkSearch(benchJsonDb, "organizations", "_id", "105", b)
 }
@@ -286,3 +2183,32 @@ func BenchmarkLargeDBSearchByKey(b *testing.B) {
 func BenchmarkLargeDBSearchByKeyNoValue(b *testing.B) {
 	benchmarkSearch(benchlargeDb, "24mb", "login", "zzz", b)
 }
+func BenchmarkLargeDBSearchEachFirst(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		benchlargeDb.SearchEach("24mb", "login", "rspt", nil, func(r Result) bool {
+			benchResult = []interface{}{r.Record}
+			return false
+		})
+	}
+}
+func BenchmarkLargeDBSearchByKeySequential(b *testing.B) {
+	benchmarkSearchWorkers(benchlargeDb, "24mb", "login", "rspt", 1, b)
+}
+func BenchmarkLargeDBSearchByKeyParallel(b *testing.B) {
+	benchmarkSearchWorkers(benchlargeDb, "24mb", "login", "rspt", 0, b)
+}
+func BenchmarkLargeDBSearchByKeyNoValueSequential(b *testing.B) {
+	benchmarkSearchWorkers(benchlargeDb, "24mb", "login", "zzz", 1, b)
+}
+func BenchmarkLargeDBSearchByKeyNoValueParallel(b *testing.B) {
+	benchmarkSearchWorkers(benchlargeDb, "24mb", "login", "zzz", 0, b)
+}
+func BenchmarkLargeDBSearchByKeyDisk(b *testing.B) {
+	jdb, err := LoadWithOptions([]string{"./testdata/24mb.json"}, LoadOptions{DiskStore: true})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer jdb.Close()
+	b.ResetTimer()
+	benchmarkSearch(jdb, "24mb", "login", "rspt", b)
+}
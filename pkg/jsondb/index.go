@@ -8,49 +8,282 @@ import (
 
 type SearchResults []map[string]interface{}
 
-// keyIndex is a map of key name to its value indexes.
-// map[keyname] -> map[value]interface{}
-type keyIndex map[string]map[string]interface{}
+// keyIndex is a map of key name to its value index.
+type keyIndex map[string]*valueIndex
+
+// valueIndex maps each value of a key to the list of objects holding
+// that value (posting list). The posting lists of a database kept on
+// disk hold the positions of its records in the store instead, decoded
+// when they are read, so that the index does not keep the records in
+// memory.
+type valueIndex struct {
+	records   map[string][]interface{}
+	positions map[string][]int
+	store     Store
+}
+
+// values returns the indexed values in no particular order.
+func (v *valueIndex) values() []string {
+	values := make([]string, 0, len(v.records)+len(v.positions))
+	for sval := range v.records {
+		values = append(values, sval)
+	}
+	for sval := range v.positions {
+		values = append(values, sval)
+	}
+	return values
+}
+
+// size returns the number of indexed values and the number of records
+// in the posting lists.
+func (v *valueIndex) size() (values, entries int) {
+	for _, recs := range v.records {
+		entries += len(recs)
+	}
+	for _, positions := range v.positions {
+		entries += len(positions)
+	}
+	return len(v.records) + len(v.positions), entries
+}
+
+// lookup returns a copy of the posting list of a value, ok is false when
+// the value is not indexed.
+func (v *valueIndex) lookup(value string) ([]interface{}, bool, error) {
+	if recs, ok := v.records[value]; ok {
+		result := make([]interface{}, 0, len(recs))
+		return append(result, recs...), true, nil
+	}
+	positions, ok := v.positions[value]
+	if !ok {
+		return nil, false, nil
+	}
+	result := make([]interface{}, len(positions))
+	for n, pos := range positions {
+		rec, err := v.store.Record(pos)
+		if err != nil {
+			return nil, true, err
+		}
+		result[n] = rec
+	}
+	return result, true, nil
+}
 
 // DBIndex is a mapping of database name it's indexes
 type DBIndex map[string]keyIndex
 
+// getDB returns the root of an in-memory database or the Store of a
+// database kept on disk.
 func (jdb *JsonDB) getDB(name string) interface{} {
-	jsonType := jdb.dbMap[name]
-	if jsonType.list != nil {
-		return jsonType.list
+	jsonType, ok := jdb.jsonType(name)
+	if !ok {
+		return nil
+	}
+	return jsonType.root()
+}
+
+// jsonType returns a copy of the database, which a reload replaces
+// while it is used.
+func (jdb *JsonDB) jsonType(name string) (JSONType, bool) {
+	jdb.mu.RLock()
+	defer jdb.mu.RUnlock()
+	jsonType, ok := jdb.dbMap[name]
+	if !ok {
+		return JSONType{}, false
+	}
+	return *jsonType, true
+}
+
+// view holds the databases and their indexes as seen by a search. It is
+// taken at once so that a search running during a Reload sees each
+// database and its indexes either before or after the reload.
+type view struct {
+	dbs     map[string]JSONType
+	indexes DBIndex
+}
+
+// view returns the current databases and indexes.
+func (jdb *JsonDB) view() *view {
+	jdb.mu.RLock()
+	defer jdb.mu.RUnlock()
+	v := &view{
+		dbs:     make(map[string]JSONType, len(jdb.dbMap)),
+		indexes: make(DBIndex, len(jdb.dbIndex)),
+	}
+	for name, jsonType := range jdb.dbMap {
+		v.dbs[name] = *jsonType
+	}
+	// the key indexes gain the indexes built later
+	for name, kIndex := range jdb.dbIndex {
+		indexes := make(keyIndex, len(kIndex))
+		for key, vIndex := range kIndex {
+			indexes[key] = vIndex
+		}
+		v.indexes[name] = indexes
+	}
+	return v
+}
+
+// root returns the root of a database of the view, nil when the
+// database is unknown.
+func (v *view) root(name string) interface{} {
+	jsonType, ok := v.dbs[name]
+	if !ok {
+		return nil
+	}
+	return jsonType.root()
+}
+
+// index returns the value index of the database key of the view.
+func (v *view) index(dbname, key string) (*valueIndex, bool) {
+	vIndex, ok := v.indexes[dbname][key]
+	return vIndex, ok
+}
+
+// root returns the root of an in-memory database or the Store of a
+// database kept on disk.
+func (t JSONType) root() interface{} {
+	if t.store != nil {
+		return t.store
+	}
+	if t.list != nil {
+		return t.list
+	}
+	return t.dict
+}
+
+// eachRecord calls fn with the top level records of a database in order
+// until fn returns an error, which eachRecord returns. The records of a
+// store are decoded one at a time.
+func (jdb *JsonDB) eachRecord(name string, fn func(n int, rec interface{}) error) error {
+	return eachRecord(jdb.getDB(name), fn)
+}
+
+// eachRecord calls fn with the top level records of a root or a Store.
+func eachRecord(root interface{}, fn func(n int, rec interface{}) error) error {
+	if s, ok := root.(Store); ok {
+		for n := 0; n < s.Len(); n++ {
+			rec, err := s.Record(n)
+			if err != nil {
+				return err
+			}
+			if err := fn(n, rec); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
-	return jsonType.dict
+	for n, rec := range db.Records(root) {
+		if err := fn(n, rec); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
+// records returns the top level records of a database, the records of a
+// store are all decoded.
+func (jdb *JsonDB) records(name string) ([]interface{}, error) {
+	return records(jdb.getDB(name))
+}
+
+// records returns the top level records of a root or a Store.
+func records(root interface{}) ([]interface{}, error) {
+	s, ok := root.(Store)
+	if !ok {
+		return db.Records(root), nil
+	}
+	records := make([]interface{}, s.Len())
+	for n := range records {
+		rec, err := s.Record(n)
+		if err != nil {
+			return nil, err
+		}
+		records[n] = rec
+	}
+	return records, nil
+}
+
+// count returns the number of top level records of a root or a Store.
+func count(root interface{}) int {
+	if s, ok := root.(Store); ok {
+		return s.Len()
+	}
+	return len(db.Records(root))
+}
+
+// index returns the value index of the database key, ok is false when
+// the key is not indexed. The value index is safe to read without the
+// lock.
+func (jdb *JsonDB) index(dbname, key string) (*valueIndex, bool) {
+	jdb.mu.RLock()
+	defer jdb.mu.RUnlock()
+	vIndex, ok := jdb.dbIndex[dbname][key]
+	return vIndex, ok
+}
+
+// BuildIndex indexes the values of the database key. The index is
+// built without holding the lock, searches running meanwhile scan the
+// database.
 func (jdb *JsonDB) BuildIndex(dbname, keyname string) error {
 
 	if jdb == nil || jdb.dbMap == nil || len(jdb.dbMap) == 0 {
 		return ErrInvalidDatabase
 	}
 
-	if jdb.dbIndex != nil {
-		// check if the index is already created
-		dbIdx, ok := jdb.dbIndex[dbname]
-		if ok {
-			_, kOk := dbIdx[keyname]
-			if kOk {
-				return nil
-			}
+	// check if the index is already created
+	if _, ok := jdb.index(dbname, keyname); ok {
+		return nil
+	}
+
+	for {
+		jsonType, _ := jdb.jsonType(dbname)
+		result, err := createIndex(jsonType.root(), dbname, keyname)
+		if err != nil {
+			log.Printf("Error %v, cannot create index on database %v key %v", err, dbname, keyname)
+			return err
+		}
+		if jdb.installIndex(dbname, keyname, jsonType.source, result) {
+			return nil
+		}
+	}
+}
+
+// createIndex indexes the values of the key of a root or a Store.
+func createIndex(root interface{}, dbname, keyname string) (*valueIndex, error) {
+	if s, ok := root.(Store); ok {
+		positions, err := db.IndexRecords(s.Len(), s.Record, keyname)
+		if err != nil {
+			return nil, err
 		}
+		return &valueIndex{positions: positions, store: s}, nil
 	}
+	records, err := db.CreateIndex(root, dbname, keyname)
+	if err != nil {
+		return nil, err
+	}
+	return &valueIndex{records: records}, nil
+}
 
-	root := jdb.getDB(dbname)
+// installIndex installs an index built from the database loaded from
+// source. It returns false when the database was reloaded meanwhile,
+// the index must then be built again.
+func (jdb *JsonDB) installIndex(dbname, keyname string, source *Source, result *valueIndex) bool {
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+	if jsonType, ok := jdb.dbMap[dbname]; ok && jsonType.source != source {
+		return false
+	}
 	if jdb.dbIndex == nil {
 		jdb.dbIndex = make(DBIndex)
 	}
-	result, err := db.CreateIndex(root, dbname, keyname)
-	if err != nil {
-		log.Printf("Error %v, cannot create index on database %v key %v", err, dbname, keyname)
-		return err
+	kIndex, ok := jdb.dbIndex[dbname]
+	if !ok {
+		kIndex = make(keyIndex)
+		jdb.dbIndex[dbname] = kIndex
 	}
-	kIndex := make(keyIndex)
-	kIndex[keyname] = result
-	jdb.dbIndex[dbname] = kIndex
-	return nil
+	// an index built concurrently is kept
+	if _, ok := kIndex[keyname]; !ok {
+		kIndex[keyname] = result
+	}
+	return true
 }
@@ -0,0 +1,324 @@
+package jsondb
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"time"
+)
+
+var (
+	ErrStaleIndexFile   = errors.New("index file does not match the database file")
+	ErrIndexFileVersion = errors.New("unsupported index file version")
+	ErrNoSourceFile     = errors.New("database not loaded from a list in a file")
+)
+
+// IndexFileExt is appended to the path of a database file to name the
+// index file holding its persisted indexes, e.g. tickets.json.idx.
+const IndexFileExt = ".idx"
+
+// indexFileVersion is the version of the index file format. Index
+// files of other versions are stale.
+const indexFileVersion = 1
+
+// Source identifies the content of a database file. An index file is
+// valid for a database file of the same path, size, modification time
+// and SHA-256 hash.
+type Source struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	Hash    string
+}
+
+// matches reports whether the sources identify the same content.
+func (s Source) matches(o Source) bool {
+	return s.Path == o.Path && s.Size == o.Size && s.ModTime.Equal(o.ModTime) && s.Hash == o.Hash
+}
+
+// newSource returns the source of an open database file without its
+// hash.
+func newSource(filename string, file *os.File) (*Source, error) {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &Source{Path: path, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+// FileSource returns the source of a database file, hashing its
+// content without parsing it.
+func FileSource(filename string) (Source, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return Source{}, err
+	}
+	defer file.Close()
+	source, err := newSource(filename, file)
+	if err != nil {
+		return Source{}, err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return Source{}, err
+	}
+	source.Hash = hex.EncodeToString(h.Sum(nil))
+	return *source, nil
+}
+
+// IndexFile returns the path of the index file of a database file.
+func IndexFile(filename string) string {
+	return filename + IndexFileExt
+}
+
+// indexFileHeader is written ahead of the indexes so that an index file
+// is verified without decoding its indexes.
+type indexFileHeader struct {
+	Version int
+	Source  Source
+	Keys    []string
+}
+
+// persistedIndex maps the values of a key to the positions of the
+// records holding them.
+type persistedIndex map[string][]int
+
+// Source returns the source of a database loaded from a file.
+func (jdb *JsonDB) Source(dbname string) (Source, bool) {
+	if jdb == nil || jdb.dbMap == nil {
+		return Source{}, false
+	}
+	jsonType, ok := jdb.jsonType(dbname)
+	if !ok || jsonType.source == nil {
+		return Source{}, false
+	}
+	return *jsonType.source, true
+}
+
+// recordID identifies a record of a list by the address of its object
+// or list, the records of a posting list are shared with the database.
+func recordID(rec interface{}) (uintptr, bool) {
+	v := reflect.ValueOf(rec)
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice:
+		return v.Pointer(), true
+	}
+	return 0, false
+}
+
+// SaveIndexes writes the built indexes of a database to the index file
+// of its database file, replacing an existing index file. Only the
+// indexes of list databases loaded from a file are persisted, the
+// postings are stored as record positions.
+func (jdb *JsonDB) SaveIndexes(dbname string) ([]string, error) {
+
+	if jdb == nil || jdb.dbMap == nil {
+		return nil, ErrInvalidDatabase
+	}
+	// the database and its indexes are read together, a reload
+	// replaces both
+	jdb.mu.RLock()
+	jsonType, ok := jdb.dbMap[dbname]
+	if !ok {
+		jdb.mu.RUnlock()
+		return nil, ErrInvalidDatabase
+	}
+	if jsonType.store != nil {
+		jdb.mu.RUnlock()
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedStore, dbname)
+	}
+	if jsonType.source == nil || jsonType.list == nil {
+		jdb.mu.RUnlock()
+		return nil, fmt.Errorf("%w: %s", ErrNoSourceFile, dbname)
+	}
+	source := *jsonType.source
+	list := jsonType.list
+	kIndex := jdb.dbIndex[dbname]
+	jdb.mu.RUnlock()
+
+	positions := make(map[uintptr]int, len(list))
+	for n, rec := range list {
+		if id, ok := recordID(rec); ok {
+			positions[id] = n
+		}
+	}
+	jdb.mu.RLock()
+	keys := make([]string, 0, len(kIndex))
+	indexes := make(map[string]persistedIndex, len(kIndex))
+	for key, vIndex := range kIndex {
+		pIndex := make(persistedIndex, len(vIndex.records))
+		for sval, recs := range vIndex.records {
+			for _, rec := range recs {
+				id, _ := recordID(rec)
+				pIndex[sval] = append(pIndex[sval], positions[id])
+			}
+		}
+		keys = append(keys, key)
+		indexes[key] = pIndex
+	}
+	jdb.mu.RUnlock()
+	sort.Strings(keys)
+
+	// write a temporary file renamed over the index file so that a
+	// failed write leaves the previous index file
+	path := IndexFile(source.Path)
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return nil, err
+	}
+	enc := gob.NewEncoder(tmp)
+	err = tmp.Chmod(0644)
+	if err == nil {
+		err = enc.Encode(indexFileHeader{
+			Version: indexFileVersion,
+			Source:  source,
+			Keys:    keys,
+		})
+	}
+	if err == nil {
+		err = enc.Encode(indexes)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return keys, nil
+}
+
+// readIndexHeader opens an index file and decodes its header.
+func readIndexHeader(path string) (*os.File, *gob.Decoder, indexFileHeader, error) {
+	var header indexFileHeader
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, header, err
+	}
+	dec := gob.NewDecoder(file)
+	if err := dec.Decode(&header); err != nil {
+		file.Close()
+		return nil, nil, header, fmt.Errorf("%w: %s: %v", ErrStaleIndexFile, path, err)
+	}
+	if header.Version != indexFileVersion {
+		file.Close()
+		return nil, nil, header, fmt.Errorf("%w %d: %s", ErrIndexFileVersion, header.Version, path)
+	}
+	return file, dec, header, nil
+}
+
+// LoadIndexes installs the indexes of the index file of a database
+// when the index file matches the loaded database file, and returns
+// their keys. It returns an error satisfying os.IsNotExist without an
+// index file and ErrStaleIndexFile when the database file changed
+// since the indexes were saved. Indexes already built are kept.
+func (jdb *JsonDB) LoadIndexes(dbname string) ([]string, error) {
+
+	if jdb == nil || jdb.dbMap == nil {
+		return nil, ErrInvalidDatabase
+	}
+	jsonType, ok := jdb.jsonType(dbname)
+	if !ok {
+		return nil, ErrInvalidDatabase
+	}
+	if jsonType.store != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedStore, dbname)
+	}
+	if jsonType.source == nil || jsonType.list == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoSourceFile, dbname)
+	}
+
+	path := IndexFile(jsonType.source.Path)
+	file, dec, header, err := readIndexHeader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if !header.Source.matches(*jsonType.source) {
+		return nil, fmt.Errorf("%w: %s", ErrStaleIndexFile, path)
+	}
+	var indexes map[string]persistedIndex
+	if err := dec.Decode(&indexes); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrStaleIndexFile, path, err)
+	}
+
+	kIndex := make(keyIndex, len(indexes))
+	for key, pIndex := range indexes {
+		vIndex := make(map[string][]interface{}, len(pIndex))
+		for sval, positions := range pIndex {
+			recs := make([]interface{}, len(positions))
+			for n, pos := range positions {
+				if pos < 0 || pos >= len(jsonType.list) {
+					return nil, fmt.Errorf("%w: %s", ErrStaleIndexFile, path)
+				}
+				recs[n] = jsonType.list[pos]
+			}
+			vIndex[sval] = recs
+		}
+		kIndex[key] = &valueIndex{records: vIndex}
+	}
+
+	jdb.mu.Lock()
+	defer jdb.mu.Unlock()
+	if jdb.dbMap[dbname].source != jsonType.source {
+		// reloaded meanwhile
+		return nil, fmt.Errorf("%w: %s", ErrStaleIndexFile, path)
+	}
+	if jdb.dbIndex == nil {
+		jdb.dbIndex = make(DBIndex)
+	}
+	if _, ok := jdb.dbIndex[dbname]; !ok {
+		jdb.dbIndex[dbname] = make(keyIndex)
+	}
+	for key, vIndex := range kIndex {
+		if _, ok := jdb.dbIndex[dbname][key]; !ok {
+			jdb.dbIndex[dbname][key] = vIndex
+		}
+	}
+	return header.Keys, nil
+}
+
+// VerifyIndexFile checks the index file of a database file against the
+// database file without loading either, and returns the keys of its
+// indexes. The errors are those of LoadIndexes.
+func VerifyIndexFile(filename string) ([]string, error) {
+	source, err := FileSource(filename)
+	if err != nil {
+		return nil, err
+	}
+	path := IndexFile(source.Path)
+	file, _, header, err := readIndexHeader(path)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	if !header.Source.matches(source) {
+		return header.Keys, fmt.Errorf("%w: %s", ErrStaleIndexFile, path)
+	}
+	return header.Keys, nil
+}
+
+// ClearIndexFile removes the index file of a database file. It returns
+// an error satisfying os.IsNotExist without an index file.
+func ClearIndexFile(filename string) error {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+	return os.Remove(IndexFile(path))
+}
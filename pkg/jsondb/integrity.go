@@ -0,0 +1,247 @@
+package jsondb
+
+import (
+	"fmt"
+
+	"github.com/gusaki/jsonsearch/internal/db"
+)
+
+// ViolationKind classifies a referential integrity violation.
+type ViolationKind int
+
+const (
+	// DanglingReference is a foreign key value with no matching
+	// primary key value.
+	DanglingReference ViolationKind = iota
+	// DuplicateKey is a primary key value shared by more than one record.
+	DuplicateKey
+	// TypeMismatch is a foreign key value whose JSON type differs from
+	// the primary key it refers to.
+	TypeMismatch
+)
+
+func (k ViolationKind) String() string {
+	switch k {
+	case DanglingReference:
+		return "dangling reference"
+	case DuplicateKey:
+		return "duplicate key"
+	case TypeMismatch:
+		return "type mismatch"
+	}
+	return "unknown"
+}
+
+// Violation describes a single integrity problem found in a record.
+type Violation struct {
+	Kind         ViolationKind
+	Relationship string
+	DB           string
+	Key          string
+	Value        string
+	// Record is the position of the offending record in its database.
+	Record int
+	Detail string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s.%s=%s (record %d) %s",
+		v.Kind, v.DB, v.Key, v.Value, v.Record, v.Detail)
+}
+
+// IntegrityReport is the result of an integrity check. PrimaryKeys
+// lists the <db.key> primary keys checked for duplicates.
+type IntegrityReport struct {
+	Violations  []Violation
+	PrimaryKeys []string
+}
+
+// OK reports whether no violations were found.
+func (r *IntegrityReport) OK() bool {
+	return len(r.Violations) == 0
+}
+
+type keyEntry struct {
+	typeName string
+	record   int
+}
+
+// keyStats holds the values of a key in the records of a database.
+type keyStats struct {
+	keys map[string]keyEntry
+	// values found in more than one record
+	dups []Violation
+	// whether the key holds lists, which reference records
+	lists bool
+}
+
+// primary reports whether the key can be the primary key of a
+// relationship: its values are unique scalars.
+func (k *keyStats) primary() bool {
+	return len(k.dups) == 0 && !k.lists
+}
+
+// primaryKeys collects the values of key in every record of dbname and
+// reports the values found in more than one record.
+func (jdb *JsonDB) primaryKeys(dbname, key string) (*keyStats, error) {
+
+	stats := &keyStats{keys: make(map[string]keyEntry)}
+	err := jdb.eachRecord(dbname, func(n int, rec interface{}) error {
+		v, ok := db.Find(key, rec)
+		if !ok {
+			return nil
+		}
+		if _, ok := v.([]interface{}); ok {
+			stats.lists = true
+		}
+		sval, ok := db.IndexValue(v)
+		if !ok {
+			return nil
+		}
+		if first, dup := stats.keys[sval]; dup {
+			stats.dups = append(stats.dups, Violation{
+				Kind:   DuplicateKey,
+				DB:     dbname,
+				Key:    key,
+				Value:  sval,
+				Record: n,
+				Detail: fmt.Sprintf("first seen in record %d", first.record),
+			})
+			return nil
+		}
+		stats.keys[sval] = keyEntry{typeName: db.TypeName(v), record: n}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// CheckIntegrity walks every relationship and reports foreign keys that
+// do not resolve, duplicate primary key values and type mismatches
+// between the two sides of a relationship. The primary key of a
+// relationship is the side holding unique scalar values, e.g. _id in
+// both users.organization_id:organizations._id and
+// organizations._id:users.organization_id. When both or neither side
+// qualifies the left side is the primary key. The direction of a one
+// way relationship is not considered.
+func (jdb *JsonDB) CheckIntegrity(relations []string) (*IntegrityReport, error) {
+
+	if jdb == nil || jdb.dbMap == nil {
+		return nil, ErrInvalidDatabase
+	}
+
+	report := &IntegrityReport{}
+	checked := make(map[string]*keyStats)
+	reported := make(map[string]bool)
+	stats := func(dbname, key string) (*keyStats, error) {
+		name := fmt.Sprintf("%s.%s", dbname, key)
+		if s, ok := checked[name]; ok {
+			return s, nil
+		}
+		s, err := jdb.primaryKeys(dbname, key)
+		if err != nil {
+			return nil, err
+		}
+		checked[name] = s
+		return s, nil
+	}
+
+	for _, reln := range relations {
+		rel, err := ParseRelationship(reln)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := jdb.dbMap[rel.FromDB]; !ok {
+			return nil, ErrInvalidDatabase
+		}
+		if _, ok := jdb.dbMap[rel.ToDB]; !ok {
+			return nil, ErrInvalidDatabase
+		}
+		from, err := stats(rel.FromDB, rel.FromKey)
+		if err != nil {
+			return nil, err
+		}
+		to, err := stats(rel.ToDB, rel.ToKey)
+		if err != nil {
+			return nil, err
+		}
+		pdb, pkey, fdb, fkey, keys := rel.FromDB, rel.FromKey, rel.ToDB, rel.ToKey, from
+		if to.primary() && !from.primary() {
+			pdb, pkey, fdb, fkey, keys = rel.ToDB, rel.ToKey, rel.FromDB, rel.FromKey, to
+		}
+		if name := fmt.Sprintf("%s.%s", pdb, pkey); !reported[name] {
+			reported[name] = true
+			report.PrimaryKeys = append(report.PrimaryKeys, name)
+			for _, dup := range keys.dups {
+				dup.Relationship = reln
+				report.Violations = append(report.Violations, dup)
+			}
+		}
+		err = jdb.eachRecord(fdb, func(n int, rec interface{}) error {
+			v, ok := db.Find(fkey, rec)
+			if !ok || v == nil {
+				return nil
+			}
+			// list values reference one record per element
+			values, ok := v.([]interface{})
+			if !ok {
+				values = []interface{}{v}
+			}
+			for _, fv := range values {
+				violation := Violation{
+					Relationship: reln,
+					DB:           fdb,
+					Key:          fkey,
+					Value:        fmt.Sprint(fv),
+					Record:       n,
+				}
+				sval, ok := db.IndexValue(fv)
+				if !ok {
+					violation.Kind = TypeMismatch
+					violation.Detail = fmt.Sprintf("%s value cannot reference %s.%s",
+						db.TypeName(fv), pdb, pkey)
+					report.Violations = append(report.Violations, violation)
+					continue
+				}
+				violation.Value = sval
+				pk, found := keys.keys[sval]
+				if !found {
+					violation.Kind = DanglingReference
+					violation.Detail = fmt.Sprintf("no match in %s.%s", pdb, pkey)
+					report.Violations = append(report.Violations, violation)
+					continue
+				}
+				if pk.typeName != db.TypeName(fv) {
+					violation.Kind = TypeMismatch
+					violation.Detail = fmt.Sprintf("%s value references %s %s.%s",
+						db.TypeName(fv), pk.typeName, pdb, pkey)
+					report.Violations = append(report.Violations, violation)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
+
+// DuplicateKeys reports the values of dbname.key that are shared by more
+// than one record.
+func (jdb *JsonDB) DuplicateKeys(dbname, key string) ([]Violation, error) {
+	if jdb == nil || jdb.dbMap == nil {
+		return nil, ErrInvalidDatabase
+	}
+	if _, ok := jdb.dbMap[dbname]; !ok {
+		return nil, ErrInvalidDatabase
+	}
+	stats, err := jdb.primaryKeys(dbname, key)
+	if err != nil {
+		return nil, err
+	}
+	return stats.dups, nil
+}
@@ -0,0 +1,375 @@
+package jsondb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gusaki/jsonsearch/internal/db"
+)
+
+var (
+	ErrUnknownAlias        = errors.New("unknown join alias")
+	ErrDuplicateAlias      = errors.New("join alias already in use")
+	ErrAmbiguousJoin       = errors.New("more than one relationship matches the join")
+	ErrMissingRelationship = errors.New("no relationship matches the join")
+	ErrInvalidJoin         = errors.New("invalid join format")
+)
+
+// JoinType selects what happens to rows without a related record.
+type JoinType int
+
+const (
+	// InnerJoin drops rows without a related record.
+	InnerJoin JoinType = iota
+	// LeftJoin keeps rows without a related record, the columns of
+	// the joined database are null.
+	LeftJoin
+)
+
+// JoinSpec joins a database to the rows built so far.
+type JoinSpec struct {
+	DB string
+	// Alias names the joined database in column paths, defaults
+	// to DB. Aliases allow joining the same database more than
+	// once, e.g. users as submitter and users as assignee.
+	Alias string
+	// Relationship relating DB to an already joined database. When
+	// empty the single relationship of JoinQuery.Relations relating
+	// DB to a joined database is used.
+	Relationship string
+	Type         JoinType
+}
+
+// Column is a projected column of a join. Path is in the form of
+// <alias>.<key path>, As renames the column.
+type Column struct {
+	Path string
+	As   string
+}
+
+// Name returns the column name used in the result header.
+func (c Column) Name() string {
+	if c.As != "" {
+		return c.As
+	}
+	return c.Path
+}
+
+// ParseColumn parses a "<alias>.<key path> [as <name>]" column.
+func ParseColumn(s string) Column {
+	s = strings.TrimSpace(s)
+	if i := strings.Index(strings.ToLower(s), " as "); i != -1 {
+		return Column{
+			Path: strings.TrimSpace(s[0:i]),
+			As:   strings.TrimSpace(s[i+4:]),
+		}
+	}
+	return Column{Path: s}
+}
+
+// ParseJoin parses a "[left] <db> [as <alias>] [on <relationship>]"
+// join.
+func ParseJoin(s string) (JoinSpec, error) {
+
+	var spec JoinSpec
+
+	fields := strings.Fields(s)
+	if len(fields) > 0 && strings.ToLower(fields[0]) == "left" {
+		spec.Type = LeftJoin
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return JoinSpec{}, ErrInvalidJoin
+	}
+	spec.DB = fields[0]
+	fields = fields[1:]
+	for len(fields) >= 2 {
+		switch strings.ToLower(fields[0]) {
+		case "as":
+			spec.Alias = fields[1]
+		case "on":
+			spec.Relationship = fields[1]
+		default:
+			return JoinSpec{}, ErrInvalidJoin
+		}
+		fields = fields[2:]
+	}
+	if len(fields) != 0 {
+		return JoinSpec{}, ErrInvalidJoin
+	}
+	return spec, nil
+}
+
+// JoinQuery describes a join starting from the records of From.
+type JoinQuery struct {
+	From      string
+	Joins     []JoinSpec
+	Columns   []Column
+	Relations []string
+}
+
+// JoinResult is a flat table of joined records.
+type JoinResult struct {
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// joinRow maps an alias to the record joined for it.
+type joinRow map[string]interface{}
+
+// joinOn resolves the relationship of a join into the alias and key of
+// the joined rows and the key of the joined database.
+func joinOn(spec JoinSpec, aliases map[string]string, order []string, relations []string) (string, string, string, error) {
+
+	candidates := relations
+	if spec.Relationship != "" {
+		candidates = []string{spec.Relationship}
+	}
+	var alias, parentKey, key string
+	matches := 0
+	for _, reln := range candidates {
+		rel, err := ParseRelationship(reln)
+		if err != nil {
+			return "", "", "", err
+		}
+		for _, a := range order {
+			adb := aliases[a]
+			switch {
+			case rel.ToDB == spec.DB && rel.FromDB == adb:
+				alias, parentKey, key = a, rel.FromKey, rel.ToKey
+			case rel.FromDB == spec.DB && rel.ToDB == adb:
+				alias, parentKey, key = a, rel.ToKey, rel.FromKey
+			default:
+				continue
+			}
+			matches++
+			break
+		}
+	}
+	switch {
+	case matches == 0:
+		return "", "", "", ErrMissingRelationship
+	case matches > 1:
+		return "", "", "", ErrAmbiguousJoin
+	}
+	return alias, parentKey, key, nil
+}
+
+// lookupTable maps the values of dbname.key to the records holding them.
+// An existing index is used when available.
+func (jdb *JsonDB) lookupTable(dbname, key string) (*valueIndex, error) {
+	if vIndex, ok := jdb.index(dbname, key); ok {
+		return vIndex, nil
+	}
+	table := make(map[string][]interface{})
+	err := jdb.eachRecord(dbname, func(n int, rec interface{}) error {
+		v, ok := db.Find(key, rec)
+		if !ok {
+			return nil
+		}
+		for _, sval := range keyValues(v) {
+			table[sval] = append(table[sval], rec)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &valueIndex{records: table}, nil
+}
+
+// keyValues returns the indexable values of a key, list values yield
+// one value per element.
+func keyValues(v interface{}) []string {
+	var values []string
+	list, ok := v.([]interface{})
+	if !ok {
+		list = []interface{}{v}
+	}
+	for _, elem := range list {
+		if sval, ok := db.IndexValue(elem); ok {
+			values = append(values, sval)
+		}
+	}
+	return values
+}
+
+// Join builds one row per record of q.From and joins the databases of
+// q.Joins in order through the relationships. Each row is projected on
+// q.Columns, without columns a row holds the whole record of each
+// alias.
+func (jdb *JsonDB) Join(q JoinQuery) (*JoinResult, error) {
+	return jdb.JoinContext(context.Background(), q)
+}
+
+// JoinContext joins like Join and stops with the error of the context
+// when it is done.
+func (jdb *JsonDB) JoinContext(ctx context.Context, q JoinQuery) (*JoinResult, error) {
+
+	if jdb == nil || jdb.dbMap == nil {
+		return nil, ErrInvalidDatabase
+	}
+	if _, ok := jdb.dbMap[q.From]; !ok {
+		return nil, ErrInvalidDatabase
+	}
+
+	aliases := map[string]string{q.From: q.From}
+	order := []string{q.From}
+	records, err := jdb.records(q.From)
+	if err != nil {
+		return nil, err
+	}
+	var rows []joinRow
+	for _, rec := range records {
+		rows = append(rows, joinRow{q.From: rec})
+	}
+
+	for _, spec := range q.Joins {
+		if _, ok := jdb.dbMap[spec.DB]; !ok {
+			return nil, ErrInvalidDatabase
+		}
+		if spec.Alias == "" {
+			spec.Alias = spec.DB
+		}
+		if _, ok := aliases[spec.Alias]; ok {
+			return nil, ErrDuplicateAlias
+		}
+		parent, parentKey, key, err := joinOn(spec, aliases, order, q.Relations)
+		if err != nil {
+			return nil, err
+		}
+		table, err := jdb.lookupTable(spec.DB, key)
+		if err != nil {
+			return nil, err
+		}
+		var joined []joinRow
+		for n, row := range rows {
+			if err := cancelled(ctx, n); err != nil {
+				return nil, err
+			}
+			var matches []interface{}
+			if v, ok := db.Find(parentKey, row[parent]); ok {
+				for _, sval := range keyValues(v) {
+					recs, _, err := table.lookup(sval)
+					if err != nil {
+						return nil, err
+					}
+					matches = append(matches, recs...)
+				}
+			}
+			if len(matches) == 0 {
+				if spec.Type == LeftJoin {
+					joined = append(joined, row.with(spec.Alias, nil))
+				}
+				continue
+			}
+			for _, m := range matches {
+				joined = append(joined, row.with(spec.Alias, m))
+			}
+		}
+		rows = joined
+		aliases[spec.Alias] = spec.DB
+		order = append(order, spec.Alias)
+	}
+
+	columns := q.Columns
+	if len(columns) == 0 {
+		for _, a := range order {
+			columns = append(columns, Column{Path: a})
+		}
+	}
+	result := &JoinResult{}
+	type source struct {
+		alias string
+		path  string
+	}
+	sources := make([]source, len(columns))
+	for n, c := range columns {
+		alias, path := c.Path, ""
+		if i := strings.Index(c.Path, "."); i != -1 {
+			alias, path = c.Path[0:i], c.Path[i+1:]
+		}
+		if _, ok := aliases[alias]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownAlias, alias)
+		}
+		sources[n] = source{alias, path}
+		result.Columns = append(result.Columns, c.Name())
+	}
+	for _, row := range rows {
+		values := make([]interface{}, len(sources))
+		for n, src := range sources {
+			if src.path == "" {
+				values[n] = row[src.alias]
+				continue
+			}
+			if v, ok := db.Lookup(src.path, row[src.alias]); ok {
+				values[n] = v
+			}
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	return result, nil
+}
+
+// RelatedRecords returns the records of toDB whose toKey holds a value
+// of the fromKey of the record, like the records joined to it.
+func (jdb *JsonDB) RelatedRecords(record interface{}, fromKey, toDB, toKey string) ([]interface{}, error) {
+	table, err := jdb.RelatedTable(toDB, toKey)
+	if err != nil {
+		return nil, err
+	}
+	return table.Records(record, fromKey)
+}
+
+// RelatedTable maps the values of a key of a database to the records
+// holding them. It finds the related records of many records with a
+// single scan of an unindexed key.
+type RelatedTable struct {
+	table *valueIndex
+}
+
+// RelatedTable returns the table of the values of toDB.toKey, the index
+// of the key when it is indexed.
+func (jdb *JsonDB) RelatedTable(toDB, toKey string) (*RelatedTable, error) {
+	if jdb == nil || jdb.dbMap == nil {
+		return nil, ErrInvalidDatabase
+	}
+	if _, ok := jdb.dbMap[toDB]; !ok {
+		return nil, ErrInvalidDatabase
+	}
+	table, err := jdb.lookupTable(toDB, toKey)
+	if err != nil {
+		return nil, err
+	}
+	return &RelatedTable{table: table}, nil
+}
+
+// Records returns the records of the table holding a value of the
+// fromKey of the record.
+func (t *RelatedTable) Records(record interface{}, fromKey string) ([]interface{}, error) {
+	related := make([]interface{}, 0)
+	v, ok := db.Find(fromKey, record)
+	if !ok {
+		return related, nil
+	}
+	for _, sval := range keyValues(v) {
+		recs, _, err := t.table.lookup(sval)
+		if err != nil {
+			return nil, err
+		}
+		related = append(related, recs...)
+	}
+	return related, nil
+}
+
+// with returns a copy of the row with the record joined for alias.
+func (r joinRow) with(alias string, rec interface{}) joinRow {
+	row := make(joinRow, len(r)+1)
+	for k, v := range r {
+		row[k] = v
+	}
+	row[alias] = rec
+	return row
+}
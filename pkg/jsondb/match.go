@@ -0,0 +1,40 @@
+package jsondb
+
+import (
+	"github.com/gusaki/jsonsearch/internal/db"
+)
+
+// MatchesKey is the field holding the match paths of an annotated
+// record.
+const MatchesKey = "_matches"
+
+// MatchPaths returns the paths of the fields of a record holding the
+// value at the key, e.g. "tags[1]". The paths of a search result are
+// those of the key it matched on, see Result.
+func MatchPaths(key, value string, record interface{}) []string {
+	paths := db.MatchPaths(key, value, record)
+	if paths == nil {
+		paths = make([]string, 0)
+	}
+	return paths
+}
+
+// Annotate returns a copy of an object record with its match paths
+// in the _matches field. Records that are not objects are returned
+// unchanged.
+func Annotate(record interface{}, paths []string) interface{} {
+	rec, ok := record.(map[string]interface{})
+	if !ok {
+		return record
+	}
+	out := make(map[string]interface{}, len(rec)+1)
+	for k, v := range rec {
+		out[k] = v
+	}
+	matches := make([]interface{}, len(paths))
+	for n, p := range paths {
+		matches[n] = p
+	}
+	out[MatchesKey] = matches
+	return out
+}
@@ -0,0 +1,18 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package jsondb
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// mmap reads the file on platforms without memory mapped files.
+func mmap(file *os.File, size int) ([]byte, func() error, error) {
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
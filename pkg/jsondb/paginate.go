@@ -0,0 +1,231 @@
+package jsondb
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gusaki/jsonsearch/internal/db"
+)
+
+var (
+	ErrInvalidSort   = errors.New("invalid sort key")
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidPage   = errors.New("invalid limit or offset")
+)
+
+// SortKey orders results by the value of a key path. Results without
+// the key sort as null values.
+type SortKey struct {
+	Path string
+	Desc bool
+}
+
+func (k SortKey) String() string {
+	if k.Desc {
+		return "-" + k.Path
+	}
+	return k.Path
+}
+
+// ParseSort parses a comma separated list of sort keys. A key prefixed
+// with - or suffixed with :desc is sorted in descending order, e.g.
+// "-priority,created_at" or "priority:desc,created_at:asc".
+func ParseSort(s string) ([]SortKey, error) {
+	var keys []SortKey
+	for _, k := range strings.Split(s, ",") {
+		k = strings.TrimSpace(k)
+		if k == "" {
+			continue
+		}
+		var key SortKey
+		switch {
+		case strings.HasPrefix(k, "-"):
+			key = SortKey{Path: k[1:], Desc: true}
+		case strings.HasSuffix(strings.ToLower(k), ":desc"):
+			key = SortKey{Path: k[0 : len(k)-5], Desc: true}
+		case strings.HasSuffix(strings.ToLower(k), ":asc"):
+			key = SortKey{Path: k[0 : len(k)-4]}
+		default:
+			key = SortKey{Path: k}
+		}
+		if key.Path == "" {
+			return nil, ErrInvalidSort
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// SortResults sorts the results in place by the sort keys. Results
+// comparing equal keep their order.
+func SortResults(results []interface{}, keys []SortKey) {
+	if len(keys) == 0 {
+		return
+	}
+	found := make([]Result, len(results))
+	for n, r := range results {
+		found[n].Record = r
+	}
+	sorted := make([]interface{}, len(results))
+	for n, i := range sortIndex(sortValues(found, keys), keys) {
+		sorted[n] = results[i]
+	}
+	copy(results, sorted)
+}
+
+// sortValues returns the values of the sort keys of each result.
+func sortValues(results []Result, keys []SortKey) [][]interface{} {
+	values := make([][]interface{}, len(results))
+	for n, r := range results {
+		values[n] = make([]interface{}, len(keys))
+		for k, key := range keys {
+			values[n][k], _ = db.Lookup(key.Path, r.Record)
+		}
+	}
+	return values
+}
+
+// sortIndex returns the order of the rows of sort key values, rows
+// comparing equal keep their order.
+func sortIndex(values [][]interface{}, keys []SortKey) []int {
+	idx := make([]int, len(values))
+	for n := range idx {
+		idx[n] = n
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		for k, key := range keys {
+			c := db.Compare(values[idx[i]][k], values[idx[j]][k])
+			if c == 0 {
+				continue
+			}
+			if key.Desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+	return idx
+}
+
+// SearchOptions orders, pages and shapes the results of a search. A
+// Limit of zero returns all the results from Offset. A Cursor returned
+// by a previous page continues from where that page stopped and takes
+// precedence over Offset. Fields projects the results of the page.
+// Matches records the paths of the matched fields of each result.
+// Workers is the number of goroutines scanning a database without an
+// index, GOMAXPROCS when zero and sequential when one.
+type SearchOptions struct {
+	Sort    []SortKey
+	Limit   int
+	Offset  int
+	Cursor  string
+	Fields  *Projection
+	Matches bool
+	Workers int
+}
+
+// Page is a page of search results. Total is the number of results
+// before paging, NextCursor is empty on the last page. Matches holds
+// the match paths of each result when requested by the options, the
+// paths refer to the projected result.
+type Page struct {
+	Results    []interface{}
+	Matches    [][]string
+	Total      int
+	Offset     int
+	NextCursor string
+}
+
+// queryHash ties a cursor to the search it was returned for.
+func queryHash(dbname, key, value string, relations []string, keys []SortKey) uint32 {
+	h := fnv.New32a()
+	fmt.Fprint(h, dbname, "\x00", key, "\x00", value, "\x00", relations, "\x00", keys)
+	return h.Sum32()
+}
+
+func encodeCursor(offset int, hash uint32) string {
+	c := fmt.Sprintf("%d:%08x", offset, hash)
+	return base64.RawURLEncoding.EncodeToString([]byte(c))
+}
+
+func decodeCursor(cursor string, hash uint32) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	c := strings.Split(string(b), ":")
+	if len(c) != 2 || c[1] != fmt.Sprintf("%08x", hash) {
+		return 0, ErrInvalidCursor
+	}
+	offset, err := strconv.Atoi(c[0])
+	if err != nil || offset < 0 {
+		return 0, ErrInvalidCursor
+	}
+	return offset, nil
+}
+
+// SearchPage searches like Search and returns the page of the sorted
+// results selected by the options, projected on the options fields.
+func (jdb *JsonDB) SearchPage(dbname, key, value string, relations []string, opts SearchOptions) (*Page, error) {
+	return jdb.SearchPageContext(context.Background(), dbname, key, value, relations, opts)
+}
+
+// SearchPageContext returns a page of results like SearchPage, the
+// search stops with the error of the context when it is done.
+func (jdb *JsonDB) SearchPageContext(ctx context.Context, dbname, key, value string, relations []string, opts SearchOptions) (*Page, error) {
+
+	hash := queryHash(dbname, key, value, relations, opts.Sort)
+	offset := opts.Offset
+	if opts.Cursor != "" {
+		var err error
+		if offset, err = decodeCursor(opts.Cursor, hash); err != nil {
+			return nil, err
+		}
+	}
+	if offset < 0 || opts.Limit < 0 {
+		return nil, ErrInvalidPage
+	}
+
+	var found []Result
+	err := jdb.each(ctx, dbname, key, value, relations, opts.Workers, func(r Result) bool {
+		found = append(found, r)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the results keep the key they matched on to report their matches
+	sorted := make([]Result, len(found))
+	for n, i := range sortIndex(sortValues(found, opts.Sort), opts.Sort) {
+		sorted[n] = found[i]
+	}
+	results := make([]interface{}, len(sorted))
+	for n, r := range sorted {
+		results[n] = r.Record
+	}
+
+	page := &Page{Total: len(results), Offset: offset}
+	if offset >= len(results) {
+		page.Results = make([]interface{}, 0)
+		return page, nil
+	}
+	end := len(results)
+	if opts.Limit > 0 && offset+opts.Limit < end {
+		end = offset + opts.Limit
+		page.NextCursor = encodeCursor(end, hash)
+	}
+	if opts.Matches {
+		for _, r := range sorted[offset:end] {
+			page.Matches = append(page.Matches, opts.Fields.ApplyPaths(MatchPaths(r.Key, value, r.Record)))
+		}
+	}
+	page.Results = Project(results[offset:end], opts.Fields)
+	return page, nil
+}
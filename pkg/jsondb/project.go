@@ -0,0 +1,282 @@
+package jsondb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gusaki/jsonsearch/internal/db"
+)
+
+var ErrInvalidField = errors.New("invalid field")
+
+// Field is an included key path of a projection, renamed to As when
+// set.
+type Field struct {
+	Path string
+	As   string
+}
+
+// ComputedField adds a field computed from the record.
+type ComputedField struct {
+	Name    string
+	Compute func(record interface{}) interface{}
+}
+
+// Projection shapes the records of search results. Records are reduced
+// to the Include fields, or are copied whole when no field is
+// included. The Exclude key paths are then removed and the Computed
+// fields added.
+type Projection struct {
+	Include  []Field
+	Exclude  []string
+	Computed []ComputedField
+}
+
+// Empty reports whether the projection leaves records unchanged.
+func (p *Projection) Empty() bool {
+	return p == nil || (len(p.Include) == 0 && len(p.Exclude) == 0 && len(p.Computed) == 0)
+}
+
+// computeFuncs are the functions available to computed fields parsed by
+// ParseProjection.
+var computeFuncs = map[string]func(v interface{}, ok bool) interface{}{
+	// numbers are float64 like unmarshalled JSON numbers
+	"len": func(v interface{}, ok bool) interface{} {
+		switch val := v.(type) {
+		case string:
+			return float64(len(val))
+		case []interface{}:
+			return float64(len(val))
+		case map[string]interface{}:
+			return float64(len(val))
+		}
+		return float64(0)
+	},
+	"exists": func(v interface{}, ok bool) interface{} {
+		return ok
+	},
+	"upper": func(v interface{}, ok bool) interface{} {
+		if s, ok := v.(string); ok {
+			return strings.ToUpper(s)
+		}
+		return v
+	},
+	"lower": func(v interface{}, ok bool) interface{} {
+		if s, ok := v.(string); ok {
+			return strings.ToLower(s)
+		}
+		return v
+	},
+	"type": func(v interface{}, ok bool) interface{} {
+		if !ok {
+			return "missing"
+		}
+		return db.TypeName(v)
+	},
+}
+
+// ParseProjection parses a comma separated list of fields:
+//
+//	<keypath>              include the key path
+//	<keypath> as <name>    include the key path renamed to name
+//	-<keypath>             exclude the key path
+//	<name>=<func>(<keypath>) add a computed field, func is one of
+//	                       len, exists, upper, lower and type
+func ParseProjection(s string) (*Projection, error) {
+
+	p := &Projection{}
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		switch {
+		case f == "":
+			continue
+		case strings.HasPrefix(f, "-"):
+			if len(f) == 1 {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidField, f)
+			}
+			p.Exclude = append(p.Exclude, f[1:])
+		case strings.Contains(f, "="):
+			cf, err := parseComputed(f)
+			if err != nil {
+				return nil, err
+			}
+			p.Computed = append(p.Computed, cf)
+		default:
+			col := ParseColumn(f)
+			p.Include = append(p.Include, Field{Path: col.Path, As: col.As})
+		}
+	}
+	return p, nil
+}
+
+func parseComputed(f string) (ComputedField, error) {
+	i := strings.Index(f, "=")
+	name, expr := strings.TrimSpace(f[0:i]), strings.TrimSpace(f[i+1:])
+	open := strings.Index(expr, "(")
+	if name == "" || open == -1 || !strings.HasSuffix(expr, ")") {
+		return ComputedField{}, fmt.Errorf("%w: %s", ErrInvalidField, f)
+	}
+	fn, ok := computeFuncs[strings.ToLower(strings.TrimSpace(expr[0:open]))]
+	path := strings.TrimSpace(expr[open+1 : len(expr)-1])
+	if !ok || path == "" {
+		return ComputedField{}, fmt.Errorf("%w: %s", ErrInvalidField, f)
+	}
+	return ComputedField{
+		Name: name,
+		Compute: func(record interface{}) interface{} {
+			v, ok := db.Lookup(path, record)
+			return fn(v, ok)
+		},
+	}, nil
+}
+
+// setPath sets the value at the dot separated key path, creating the
+// intermediate objects and copying the existing ones, which may be
+// those of the source record.
+func setPath(obj map[string]interface{}, path string, v interface{}) {
+	keys := strings.Split(path, ".")
+	for _, k := range keys[0 : len(keys)-1] {
+		child, _ := obj[k].(map[string]interface{})
+		cp := make(map[string]interface{}, len(child))
+		for ck, cv := range child {
+			cp[ck] = cv
+		}
+		obj[k] = cp
+		obj = cp
+	}
+	obj[keys[len(keys)-1]] = v
+}
+
+// deletePath removes the value at the dot separated key path, copying
+// the objects on the path so the source record is left unchanged.
+func deletePath(obj map[string]interface{}, path string) {
+	keys := strings.Split(path, ".")
+	for _, k := range keys[0 : len(keys)-1] {
+		child, ok := obj[k].(map[string]interface{})
+		if !ok {
+			return
+		}
+		cp := make(map[string]interface{}, len(child))
+		for ck, cv := range child {
+			cp[ck] = cv
+		}
+		obj[k] = cp
+		obj = cp
+	}
+	delete(obj, keys[len(keys)-1])
+}
+
+// Apply returns the projected record. Records that are not objects are
+// returned unchanged.
+func (p *Projection) Apply(record interface{}) interface{} {
+
+	if p.Empty() {
+		return record
+	}
+	rec, ok := record.(map[string]interface{})
+	if !ok {
+		return record
+	}
+
+	out := make(map[string]interface{})
+	if len(p.Include) == 0 {
+		for k, v := range rec {
+			out[k] = v
+		}
+	}
+	for _, f := range p.Include {
+		v, ok := db.Lookup(f.Path, rec)
+		if !ok {
+			continue
+		}
+		if f.As != "" {
+			out[f.As] = v
+			continue
+		}
+		setPath(out, f.Path, v)
+	}
+	for _, path := range p.Exclude {
+		deletePath(out, path)
+	}
+	for _, cf := range p.Computed {
+		out[cf.Name] = cf.Compute(rec)
+	}
+	return out
+}
+
+// ApplyPaths returns the paths of the fields of a record, like its
+// match paths, as paths of the projected record. Included fields are
+// renamed and the paths of the fields left out, excluded or replaced by
+// a computed field are dropped.
+func (p *Projection) ApplyPaths(paths []string) []string {
+
+	if p.Empty() {
+		return paths
+	}
+	out := make([]string, 0, len(paths))
+	for _, path := range paths {
+		var mapped []string
+		if len(p.Include) == 0 {
+			mapped = append(mapped, path)
+		}
+		for _, f := range p.Include {
+			rest, ok := underPath(path, f.Path)
+			if !ok {
+				continue
+			}
+			if f.As != "" {
+				mapped = append(mapped, f.As+rest)
+				continue
+			}
+			mapped = append(mapped, path)
+		}
+		for _, mp := range mapped {
+			if !p.removes(mp) {
+				out = append(out, mp)
+			}
+		}
+	}
+	return out
+}
+
+// removes reports whether the path of a projected record is excluded
+// or replaced by a computed field.
+func (p *Projection) removes(path string) bool {
+	for _, ex := range p.Exclude {
+		if _, ok := underPath(path, ex); ok {
+			return true
+		}
+	}
+	for _, cf := range p.Computed {
+		if _, ok := underPath(path, cf.Name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// underPath returns the rest of the path below the key path prefix,
+// e.g. ".name" or "[1]", and whether the path is at or below it.
+func underPath(path, prefix string) (string, bool) {
+	if !strings.HasPrefix(path, prefix) {
+		return "", false
+	}
+	rest := path[len(prefix):]
+	if rest != "" && rest[0] != '.' && rest[0] != '[' {
+		return "", false
+	}
+	return rest, true
+}
+
+// Project returns the projected results.
+func Project(results []interface{}, p *Projection) []interface{} {
+	if p.Empty() {
+		return results
+	}
+	projected := make([]interface{}, len(results))
+	for n, r := range results {
+		projected[n] = p.Apply(r)
+	}
+	return projected
+}
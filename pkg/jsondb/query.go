@@ -0,0 +1,368 @@
+package jsondb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidQuery = errors.New("invalid query")
+
+// Query is a search, join or aggregation parsed from the query
+// language. A query with Joins is a join, a query with GroupBy or
+// Aggregates an aggregation, and any other query a search of From,
+// of all its records when Key is empty. Workers is the number of
+// goroutines of the searches, like in SearchOptions.
+type Query struct {
+	From       string
+	Key        string
+	Value      string
+	Joins      []JoinSpec
+	Columns    []Column
+	Fields     *Projection
+	GroupBy    []string
+	Aggregates []Aggregate
+	Sort       []SortKey
+	Limit      int
+	Offset     int
+	Relations  []string
+	Workers    int
+}
+
+// QueryResult holds the page of records of a search, or the columns
+// and rows of a join or an aggregation.
+type QueryResult struct {
+	Page    *Page
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// queryClauses are the keywords starting a clause of the query
+// language.
+var queryClauses = map[string]bool{
+	"from": true, "where": true, "join": true, "left": true,
+	"select": true, "group": true, "agg": true, "sort": true,
+	"order": true, "limit": true, "offset": true,
+}
+
+type queryToken struct {
+	text   string
+	quoted bool
+}
+
+// tokenize splits the query on white space. Single or double quoted
+// text is a single token and is never read as a keyword.
+func tokenize(s string) ([]queryToken, error) {
+	var tokens []queryToken
+	var b strings.Builder
+	var quote rune
+	quoted, inToken := false, false
+	for _, c := range s {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			b.WriteRune(c)
+		case c == '"' || c == '\'':
+			quote, quoted, inToken = c, true, true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inToken {
+				tokens = append(tokens, queryToken{b.String(), quoted})
+				b.Reset()
+				quoted, inToken = false, false
+			}
+		default:
+			b.WriteRune(c)
+			inToken = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("%w: unterminated quote", ErrInvalidQuery)
+	}
+	if inToken {
+		tokens = append(tokens, queryToken{b.String(), quoted})
+	}
+	return tokens, nil
+}
+
+func isKeyword(tokens []queryToken, i int, word string) bool {
+	return i < len(tokens) && !tokens[i].quoted && strings.ToLower(tokens[i].text) == word
+}
+
+// ParseQuery parses a query of clauses:
+//
+//	from <db>                          the database to query, required
+//	where <keypath> = <value>          search the records by a key
+//	[left] join <db> [as <alias>] [on <relationship>]
+//	select <fields or columns>         like -fields, or -columns of a join
+//	group by <keypaths>                group the records
+//	agg <aggregates>                   like -agg
+//	sort by <keys>                     like -sort, also order by
+//	limit <n>
+//	offset <n>
+//
+// Values containing keywords are quoted, e.g.
+// from tickets where subject = "A problem in Japan" sort by -priority limit 5
+func ParseQuery(s string) (Query, error) {
+
+	var q Query
+	var selects string
+
+	tokens, err := tokenize(s)
+	if err != nil {
+		return Query{}, err
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < len(tokens); {
+		if tokens[i].quoted || !queryClauses[strings.ToLower(tokens[i].text)] {
+			return Query{}, fmt.Errorf("%w: unexpected %q", ErrInvalidQuery, tokens[i].text)
+		}
+		clause := strings.ToLower(tokens[i].text)
+		i++
+		switch clause {
+		case "left":
+			if !isKeyword(tokens, i, "join") {
+				return Query{}, fmt.Errorf("%w: expected join after left", ErrInvalidQuery)
+			}
+			i++
+		case "group", "sort", "order":
+			if isKeyword(tokens, i, "by") {
+				i++
+			}
+		}
+		// the clause extends to the next keyword
+		var words []string
+		for ; i < len(tokens); i++ {
+			if !tokens[i].quoted && queryClauses[strings.ToLower(tokens[i].text)] {
+				if strings.ToLower(tokens[i].text) != "left" || isKeyword(tokens, i+1, "join") {
+					break
+				}
+			}
+			words = append(words, tokens[i].text)
+		}
+		text := strings.Join(words, " ")
+		if clause == "order" {
+			clause = "sort"
+		}
+		if clause != "join" && clause != "left" {
+			if seen[clause] {
+				return Query{}, fmt.Errorf("%w: repeated %s", ErrInvalidQuery, clause)
+			}
+			seen[clause] = true
+		}
+		if len(words) == 0 {
+			return Query{}, fmt.Errorf("%w: empty %s", ErrInvalidQuery, clause)
+		}
+
+		switch clause {
+		case "from":
+			if len(words) != 1 {
+				return Query{}, fmt.Errorf("%w: from %s", ErrInvalidQuery, text)
+			}
+			q.From = words[0]
+		case "where":
+			eq := strings.Index(text, "=")
+			if eq == -1 || strings.TrimSpace(text[0:eq]) == "" {
+				return Query{}, fmt.Errorf("%w: where %s", ErrInvalidQuery, text)
+			}
+			q.Key = strings.TrimSpace(text[0:eq])
+			q.Value = strings.TrimSpace(text[eq+1:])
+		case "join", "left":
+			if clause == "left" {
+				text = "left " + text
+			}
+			spec, err := ParseJoin(text)
+			if err != nil {
+				return Query{}, err
+			}
+			q.Joins = append(q.Joins, spec)
+		case "select":
+			selects = text
+		case "group":
+			for _, path := range strings.Split(text, ",") {
+				if path = strings.TrimSpace(path); path != "" {
+					q.GroupBy = append(q.GroupBy, path)
+				}
+			}
+		case "agg":
+			for _, a := range strings.Split(text, ",") {
+				if strings.TrimSpace(a) == "" {
+					continue
+				}
+				agg, err := ParseAggregate(a)
+				if err != nil {
+					return Query{}, err
+				}
+				q.Aggregates = append(q.Aggregates, agg)
+			}
+		case "sort":
+			if q.Sort, err = ParseSort(text); err != nil {
+				return Query{}, err
+			}
+		case "limit", "offset":
+			n, err := strconv.Atoi(text)
+			if err != nil || n < 0 {
+				return Query{}, fmt.Errorf("%w: %s %s", ErrInvalidQuery, clause, text)
+			}
+			if clause == "limit" {
+				q.Limit = n
+			} else {
+				q.Offset = n
+			}
+		}
+	}
+
+	if q.From == "" {
+		return Query{}, fmt.Errorf("%w: missing from", ErrInvalidQuery)
+	}
+	aggregate := len(q.GroupBy) > 0 || len(q.Aggregates) > 0
+	switch {
+	case len(q.Joins) > 0 && aggregate:
+		return Query{}, fmt.Errorf("%w: cannot aggregate a join", ErrInvalidQuery)
+	case len(q.Joins) > 0 && q.Key != "":
+		return Query{}, fmt.Errorf("%w: where is not supported in joins", ErrInvalidQuery)
+	case aggregate && selects != "":
+		return Query{}, fmt.Errorf("%w: select is not supported in aggregations", ErrInvalidQuery)
+	case len(q.Joins) > 0:
+		for _, col := range strings.Split(selects, ",") {
+			if strings.TrimSpace(col) != "" {
+				q.Columns = append(q.Columns, ParseColumn(col))
+			}
+		}
+	case selects != "":
+		if q.Fields, err = ParseProjection(selects); err != nil {
+			return Query{}, err
+		}
+	}
+	return q, nil
+}
+
+// sortRows sorts the rows by the sort keys naming their columns.
+func sortRows(columns []string, rows [][]interface{}, keys []SortKey) error {
+	cols := make([]int, len(keys))
+	for k, key := range keys {
+		cols[k] = -1
+		for c, name := range columns {
+			if name == key.Path {
+				cols[k] = c
+			}
+		}
+		if cols[k] == -1 {
+			return fmt.Errorf("%w: unknown column %s", ErrInvalidSort, key.Path)
+		}
+	}
+	values := make([][]interface{}, len(rows))
+	for n, row := range rows {
+		values[n] = make([]interface{}, len(keys))
+		for k, c := range cols {
+			values[n][k] = row[c]
+		}
+	}
+	sorted := make([][]interface{}, len(rows))
+	for n, i := range sortIndex(values, keys) {
+		sorted[n] = rows[i]
+	}
+	copy(rows, sorted)
+	return nil
+}
+
+// Query runs the query.
+func (jdb *JsonDB) Query(q Query) (*QueryResult, error) {
+	return jdb.QueryContext(context.Background(), q)
+}
+
+// QueryContext runs the query, which stops with the error of the
+// context when it is done.
+func (jdb *JsonDB) QueryContext(ctx context.Context, q Query) (*QueryResult, error) {
+
+	var columns []string
+	var rows [][]interface{}
+
+	switch {
+	case len(q.Joins) > 0:
+		res, err := jdb.JoinContext(ctx, JoinQuery{
+			From:      q.From,
+			Joins:     q.Joins,
+			Columns:   q.Columns,
+			Relations: q.Relations,
+		})
+		if err != nil {
+			return nil, err
+		}
+		columns, rows = res.Columns, res.Rows
+	case len(q.GroupBy) > 0 || len(q.Aggregates) > 0:
+		res, err := jdb.AggregateContext(ctx, AggregateQuery{
+			DB:         q.From,
+			GroupBy:    q.GroupBy,
+			Aggregates: q.Aggregates,
+			Key:        q.Key,
+			Value:      q.Value,
+		})
+		if err != nil {
+			return nil, err
+		}
+		columns, rows = res.Columns(), res.Rows()
+	case q.Key != "":
+		page, err := jdb.SearchPageContext(ctx, q.From, q.Key, q.Value, q.Relations, SearchOptions{
+			Sort:    q.Sort,
+			Limit:   q.Limit,
+			Offset:  q.Offset,
+			Fields:  q.Fields,
+			Matches: true,
+			Workers: q.Workers,
+		})
+		if err != nil {
+			return nil, err
+		}
+		// queries are paged with offset, not cursors
+		page.NextCursor = ""
+		return &QueryResult{Page: page}, nil
+	default:
+		if jdb == nil || jdb.dbMap == nil {
+			return nil, ErrInvalidDatabase
+		}
+		if _, ok := jdb.dbMap[q.From]; !ok {
+			return nil, ErrInvalidDatabase
+		}
+		results := make([]interface{}, 0)
+		err := jdb.eachRecord(q.From, func(n int, rec interface{}) error {
+			if err := cancelled(ctx, n); err != nil {
+				return err
+			}
+			results = append(results, rec)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		SortResults(results, q.Sort)
+		start, end := pageBounds(len(results), q.Offset, q.Limit)
+		page := &Page{
+			Results: Project(results[start:end], q.Fields),
+			Total:   len(results),
+			Offset:  q.Offset,
+		}
+		return &QueryResult{Page: page}, nil
+	}
+
+	if err := sortRows(columns, rows, q.Sort); err != nil {
+		return nil, err
+	}
+	start, end := pageBounds(len(rows), q.Offset, q.Limit)
+	return &QueryResult{Columns: columns, Rows: rows[start:end]}, nil
+}
+
+// pageBounds returns the bounds of the page of n results starting at
+// offset, of up to limit results when limit is set.
+func pageBounds(n, offset, limit int) (int, int) {
+	if offset > n {
+		offset = n
+	}
+	end := n
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+	return offset, end
+}
@@ -0,0 +1,108 @@
+package jsondb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidRelationship = errors.New("invalid relationship format")
+
+const (
+	// separators between the two sides of a relationship
+	bidirectional = ":"
+	oneWay        = ">"
+)
+
+// Relationship relates the key of one database to the key of
+// another. Relationships are declared as <db.key>:<db.key> and are
+// followed both ways when searching. A relationship declared as
+// <db.key>><db.key> is one way and is only followed from the left
+// side to the right side.
+type Relationship struct {
+	FromDB  string
+	FromKey string
+	ToDB    string
+	ToKey   string
+	OneWay  bool
+}
+
+func (r Relationship) String() string {
+	sep := bidirectional
+	if r.OneWay {
+		sep = oneWay
+	}
+	return fmt.Sprintf("%s.%s%s%s.%s", r.FromDB, r.FromKey, sep, r.ToDB, r.ToKey)
+}
+
+// splitKeyPath splits <dbname.key> on the last dot.
+func splitKeyPath(path string) (string, string, error) {
+	path = strings.TrimSpace(path)
+	li := strings.LastIndex(path, ".")
+	if li <= 0 || li == len(path)-1 {
+		return "", "", ErrInvalidRelationship
+	}
+	return path[0:li], path[li+1:], nil
+}
+
+// ParseRelationship parses a <db.key>:<db.key> or a one way
+// <db.key>><db.key> relationship.
+func ParseRelationship(reln string) (Relationship, error) {
+
+	var rel Relationship
+
+	sep := bidirectional
+	if !strings.Contains(reln, bidirectional) {
+		sep = oneWay
+		rel.OneWay = true
+	}
+	r := strings.Split(strings.TrimSpace(reln), sep)
+	if len(r) != 2 {
+		return Relationship{}, ErrInvalidRelationship
+	}
+	var err error
+	if rel.FromDB, rel.FromKey, err = splitKeyPath(r[0]); err != nil {
+		return Relationship{}, err
+	}
+	if rel.ToDB, rel.ToKey, err = splitKeyPath(r[1]); err != nil {
+		return Relationship{}, err
+	}
+	return rel, nil
+}
+
+// Related returns the database and key related to dbname.key by the
+// relationship. Searching the right side of a one way relationship
+// does not relate to the left side.
+func (r Relationship) Related(dbname, key string) (string, string, bool) {
+	if r.FromDB == dbname && r.FromKey == key {
+		return r.ToDB, r.ToKey, true
+	}
+	if !r.OneWay && r.ToDB == dbname && r.ToKey == key {
+		return r.FromDB, r.FromKey, true
+	}
+	return "", "", false
+}
+
+// SetRelationships validates the relationships of the databases and
+// keeps them with the databases, they are saved in snapshots. The
+// search methods follow the relationships they are given.
+func (jdb *JsonDB) SetRelationships(relations []string) error {
+	if jdb == nil {
+		return ErrInvalidDatabase
+	}
+	for _, reln := range relations {
+		if _, err := ParseRelationship(reln); err != nil {
+			return fmt.Errorf("%w: %s", err, reln)
+		}
+	}
+	jdb.relations = append([]string{}, relations...)
+	return nil
+}
+
+// Relationships returns the relationships kept with the databases.
+func (jdb *JsonDB) Relationships() []string {
+	if jdb == nil {
+		return nil
+	}
+	return jdb.relations
+}
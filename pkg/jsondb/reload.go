@@ -0,0 +1,104 @@
+package jsondb
+
+import (
+	"fmt"
+	"log"
+	"sort"
+)
+
+// Reload loads a database again from its file, in a DiskStore when it
+// was kept on disk, validates its records against its schema and
+// rebuilds its indexes, then replaces the database and its indexes at
+// once. It returns the keys of the rebuilt indexes, an index whose key
+// cannot be indexed anymore is dropped. On failure, including records
+// failing the validation in ValidateStrict mode, the loaded database is
+// kept.
+//
+// Searches running during a reload see each database before or after
+// the reload. A replaced DiskStore is unmapped once no search uses it.
+func (jdb *JsonDB) Reload(dbname string) ([]string, error) {
+
+	if jdb == nil || jdb.dbMap == nil {
+		return nil, ErrInvalidDatabase
+	}
+	cur, ok := jdb.jsonType(dbname)
+	if !ok {
+		return nil, ErrInvalidDatabase
+	}
+	if cur.source == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoSourceFile, dbname)
+	}
+	next, err := openFile(cur.source.Path, cur.store != nil)
+	if err != nil {
+		return nil, err
+	}
+	root := next.root()
+
+	var verrs []ValidationError
+	if schema, ok := jdb.schemas[dbname]; ok {
+		verrs, err = validateRecords(dbname, root, schema)
+		if err == nil && len(verrs) > 0 && jdb.validate == ValidateStrict {
+			err = SchemaErrors(verrs)
+		}
+		if err != nil {
+			if next.store != nil {
+				next.store.Close()
+			}
+			return nil, err
+		}
+	}
+
+	jdb.mu.RLock()
+	keys := make([]string, 0, len(jdb.dbIndex[dbname]))
+	rebuilt := make(map[string]bool, len(jdb.dbIndex[dbname]))
+	for key := range jdb.dbIndex[dbname] {
+		keys = append(keys, key)
+		rebuilt[key] = true
+	}
+	jdb.mu.RUnlock()
+	sort.Strings(keys)
+	kIndex := make(keyIndex, len(keys))
+	built := make([]string, 0, len(keys))
+	for _, key := range keys {
+		vIndex, err := createIndex(root, dbname, key)
+		if err != nil {
+			log.Printf("Error %v, cannot create index on database %v key %v", err, dbname, key)
+			continue
+		}
+		kIndex[key] = vIndex
+		built = append(built, key)
+	}
+
+	jdb.mu.Lock()
+	var missed []string
+	for key := range jdb.dbIndex[dbname] {
+		if !rebuilt[key] {
+			missed = append(missed, key)
+		}
+	}
+	*jdb.dbMap[dbname] = *next
+	if jdb.dbIndex == nil {
+		jdb.dbIndex = make(DBIndex)
+	}
+	jdb.dbIndex[dbname] = kIndex
+	if _, ok := jdb.schemas[dbname]; ok {
+		kept := make([]ValidationError, 0, len(jdb.validationErrors))
+		for _, e := range jdb.validationErrors {
+			if e.DB != dbname {
+				kept = append(kept, e)
+			}
+		}
+		jdb.validationErrors = append(kept, verrs...)
+	}
+	jdb.mu.Unlock()
+
+	// indexes built while the database was reloaded
+	sort.Strings(missed)
+	for _, key := range missed {
+		if err := jdb.BuildIndex(dbname, key); err == nil {
+			built = append(built, key)
+		}
+	}
+	sort.Strings(built)
+	return built, nil
+}
@@ -0,0 +1,165 @@
+package jsondb
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/gusaki/jsonsearch/internal/db"
+)
+
+// maxSamples is the number of sample values kept per key path.
+const maxSamples = 3
+
+// FieldSchema describes the values found at a key path. Types counts
+// the values of each JSON type and Items the types of the elements of
+// list values. Present is the number of records holding the key path
+// and Presence their ratio, Nullable reports null values, not missing
+// ones. Distinct is the number of distinct scalar values, including the
+// scalar elements of lists.
+type FieldSchema struct {
+	Path     string         `json:"path"`
+	Types    map[string]int `json:"types"`
+	Items    map[string]int `json:"items,omitempty"`
+	Present  int            `json:"present"`
+	Presence float64        `json:"presence"`
+	Nullable bool           `json:"nullable"`
+	Distinct int            `json:"distinct"`
+	Samples  []interface{}  `json:"samples"`
+}
+
+// Type returns the most frequent type of the values other than null,
+// or null when all the values are null.
+func (f FieldSchema) Type() string {
+	best, count := "null", 0
+	for t, n := range f.Types {
+		if t != "null" && (n > count || (n == count && t < best)) {
+			best, count = t, n
+		}
+	}
+	return best
+}
+
+// Schema is the schema inferred from the records of a database, its
+// fields are sorted by key path. The objects in lists share the key
+// path of the list, like in Keys.
+type Schema struct {
+	DB      string        `json:"db"`
+	Records int           `json:"records"`
+	Fields  []FieldSchema `json:"fields"`
+}
+
+// Field returns the schema of the key path.
+func (s *Schema) Field(path string) (FieldSchema, bool) {
+	for _, f := range s.Fields {
+		if f.Path == path {
+			return f, true
+		}
+	}
+	return FieldSchema{}, false
+}
+
+type fieldStats struct {
+	schema FieldSchema
+	values map[string]bool
+}
+
+type schemaBuilder struct {
+	fields map[string]*fieldStats
+}
+
+func (b *schemaBuilder) add(path string, v interface{}, seen map[string]bool) {
+	f, ok := b.fields[path]
+	if !ok {
+		f = &fieldStats{
+			schema: FieldSchema{Path: path, Types: make(map[string]int), Samples: make([]interface{}, 0)},
+			values: make(map[string]bool),
+		}
+		b.fields[path] = f
+	}
+	typ := db.TypeName(v)
+	f.schema.Types[typ]++
+	if !seen[path] {
+		seen[path] = true
+		f.schema.Present++
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		b.walk(val, path, seen)
+	case []interface{}:
+		for _, e := range val {
+			if f.schema.Items == nil {
+				f.schema.Items = make(map[string]int)
+			}
+			f.schema.Items[db.TypeName(e)]++
+			switch elem := e.(type) {
+			case map[string]interface{}:
+				b.walk(elem, path, seen)
+			case []interface{}, nil:
+			default:
+				f.sample(elem)
+			}
+		}
+	case nil:
+	default:
+		f.sample(val)
+	}
+}
+
+// sample counts a distinct scalar value and keeps it as a sample.
+func (f *fieldStats) sample(v interface{}) {
+	key := db.TypeName(v) + ":" + fmt.Sprint(v)
+	if f.values[key] {
+		return
+	}
+	f.values[key] = true
+	if len(f.schema.Samples) < maxSamples {
+		f.schema.Samples = append(f.schema.Samples, v)
+	}
+}
+
+func (b *schemaBuilder) walk(obj map[string]interface{}, prefix string, seen map[string]bool) {
+	for k, v := range obj {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		b.add(path, v, seen)
+	}
+}
+
+// Schema infers the schema of the database from its records.
+func (jdb *JsonDB) Schema(dbname string) (*Schema, error) {
+
+	if jdb == nil || jdb.dbMap == nil {
+		return nil, ErrInvalidDatabase
+	}
+	if _, ok := jdb.dbMap[dbname]; !ok {
+		return nil, ErrInvalidDatabase
+	}
+	b := &schemaBuilder{fields: make(map[string]*fieldStats)}
+	records := 0
+	err := jdb.eachRecord(dbname, func(n int, r interface{}) error {
+		records++
+		if obj, ok := r.(map[string]interface{}); ok {
+			b.walk(obj, "", make(map[string]bool))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Schema{DB: dbname, Records: records, Fields: make([]FieldSchema, 0, len(b.fields))}
+	for _, f := range b.fields {
+		f.schema.Distinct = len(f.values)
+		if s.Records > 0 {
+			f.schema.Presence = float64(f.schema.Present) / float64(s.Records)
+		}
+		f.schema.Nullable = f.schema.Types["null"] > 0
+		s.Fields = append(s.Fields, f.schema)
+	}
+	sort.Slice(s.Fields, func(i, j int) bool {
+		return s.Fields[i].Path < s.Fields[j].Path
+	})
+	return s, nil
+}
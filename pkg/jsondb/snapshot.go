@@ -0,0 +1,546 @@
+package jsondb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+var (
+	ErrInvalidSnapshot = errors.New("invalid snapshot")
+	ErrSnapshotVersion = errors.New("unsupported snapshot version")
+)
+
+// snapshotMagic starts a snapshot, followed by the snapshot version.
+const snapshotMagic = "JSDB"
+
+// snapshotVersion is the version of the snapshot format written by
+// Save. Open reads this version only.
+const snapshotVersion = 1
+
+// Tags of the encoded JSON values.
+const (
+	tagNull byte = iota
+	tagFalse
+	tagTrue
+	// a number holding an integer, encoded as a varint
+	tagIntegral
+	tagNumber
+	tagInt
+	tagString
+	tagList
+	tagObject
+)
+
+// A snapshot holds the version header followed by the relationships and
+// the databases in name order. Each database holds its source file, its
+// indexes and its root value. Values are encoded as a tag followed by
+// their content, lengths and counts as uvarints. Object keys are
+// interned: a key is written once and referred to by its number after
+// that. The postings of the indexes refer to the objects and lists of
+// the root value by their number in the order they are encoded.
+
+type snapshotWriter struct {
+	w    *bufio.Writer
+	buf  [binary.MaxVarintLen64]byte
+	keys map[string]uint64
+	err  error
+}
+
+func (sw *snapshotWriter) uvarint(v uint64) {
+	if sw.err != nil {
+		return
+	}
+	n := binary.PutUvarint(sw.buf[:], v)
+	_, sw.err = sw.w.Write(sw.buf[:n])
+}
+
+func (sw *snapshotWriter) varint(v int64) {
+	if sw.err != nil {
+		return
+	}
+	n := binary.PutVarint(sw.buf[:], v)
+	_, sw.err = sw.w.Write(sw.buf[:n])
+}
+
+func (sw *snapshotWriter) byte(b byte) {
+	if sw.err != nil {
+		return
+	}
+	sw.err = sw.w.WriteByte(b)
+}
+
+func (sw *snapshotWriter) string(s string) {
+	sw.uvarint(uint64(len(s)))
+	if sw.err != nil {
+		return
+	}
+	_, sw.err = sw.w.WriteString(s)
+}
+
+// key writes an object key, 0 and the key the first time it is seen
+// and its number plus one after that.
+func (sw *snapshotWriter) key(k string) {
+	if n, ok := sw.keys[k]; ok {
+		sw.uvarint(n + 1)
+		return
+	}
+	sw.keys[k] = uint64(len(sw.keys))
+	sw.uvarint(0)
+	sw.string(k)
+}
+
+func (sw *snapshotWriter) value(v interface{}) {
+	switch val := v.(type) {
+	case nil:
+		sw.byte(tagNull)
+	case bool:
+		if val {
+			sw.byte(tagTrue)
+		} else {
+			sw.byte(tagFalse)
+		}
+	case float64:
+		if i := int64(val); float64(i) == val && math.Abs(val) < 1<<53 {
+			sw.byte(tagIntegral)
+			sw.varint(i)
+			return
+		}
+		sw.byte(tagNumber)
+		if sw.err == nil {
+			binary.LittleEndian.PutUint64(sw.buf[:8], math.Float64bits(val))
+			_, sw.err = sw.w.Write(sw.buf[:8])
+		}
+	case int:
+		sw.byte(tagInt)
+		sw.varint(int64(val))
+	case string:
+		sw.byte(tagString)
+		sw.string(val)
+	case []interface{}:
+		sw.byte(tagList)
+		sw.uvarint(uint64(len(val)))
+		for _, e := range val {
+			sw.value(e)
+		}
+	case map[string]interface{}:
+		sw.byte(tagObject)
+		sw.uvarint(uint64(len(val)))
+		for _, k := range sortedKeys(val) {
+			sw.key(k)
+			sw.value(val[k])
+		}
+	default:
+		if sw.err == nil {
+			sw.err = fmt.Errorf("%w: cannot encode %T", ErrInvalidSnapshot, v)
+		}
+	}
+}
+
+func sortedKeys(obj map[string]interface{}) []string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// nodeIDs numbers the objects and lists of a value in the order they
+// are encoded, keeping the numbers of the wanted ones.
+func nodeIDs(v interface{}, wanted map[uintptr]bool, ids map[uintptr]uint64, next *uint64) {
+	switch val := v.(type) {
+	case []interface{}, map[string]interface{}:
+		if id, _ := recordID(val); wanted[id] {
+			ids[id] = *next
+		}
+		*next++
+	}
+	switch val := v.(type) {
+	case []interface{}:
+		for _, e := range val {
+			nodeIDs(e, wanted, ids, next)
+		}
+	case map[string]interface{}:
+		for _, k := range sortedKeys(val) {
+			nodeIDs(val[k], wanted, ids, next)
+		}
+	}
+}
+
+// Save writes a snapshot of the loaded databases, their sources, their
+// indexes and the relationships to w. Open restores the snapshot
+// without parsing JSON or building indexes. Databases kept on disk
+// cannot be saved.
+func (jdb *JsonDB) Save(w io.Writer) error {
+
+	if jdb == nil || jdb.dbMap == nil {
+		return ErrInvalidDatabase
+	}
+	for _, name := range jdb.Names() {
+		if jsonType, _ := jdb.jsonType(name); jsonType.store != nil {
+			return fmt.Errorf("%w: %s", ErrUnsupportedStore, name)
+		}
+	}
+	sw := &snapshotWriter{w: bufio.NewWriter(w), keys: make(map[string]uint64)}
+	if _, err := sw.w.WriteString(snapshotMagic); err != nil {
+		return err
+	}
+	sw.uvarint(snapshotVersion)
+
+	relations := jdb.Relationships()
+	sw.uvarint(uint64(len(relations)))
+	for _, r := range relations {
+		sw.string(r)
+	}
+
+	names := jdb.Names()
+	sw.uvarint(uint64(len(names)))
+	for _, name := range names {
+		// the database and its indexes are read together, a reload
+		// replaces both
+		jdb.mu.RLock()
+		jsonType := *jdb.dbMap[name]
+		kIndex := make(keyIndex, len(jdb.dbIndex[name]))
+		for key, vIndex := range jdb.dbIndex[name] {
+			kIndex[key] = vIndex
+		}
+		jdb.mu.RUnlock()
+		root := jsonType.root()
+		sw.string(name)
+
+		if s := jsonType.source; s != nil {
+			sw.byte(1)
+			sw.string(s.Path)
+			sw.varint(s.Size)
+			sw.varint(s.ModTime.UnixNano())
+			sw.string(s.Hash)
+		} else {
+			sw.byte(0)
+		}
+
+		wanted := make(map[uintptr]bool)
+		for _, vIndex := range kIndex {
+			for _, recs := range vIndex.records {
+				for _, rec := range recs {
+					if id, ok := recordID(rec); ok {
+						wanted[id] = true
+					}
+				}
+			}
+		}
+		ids := make(map[uintptr]uint64, len(wanted))
+		var next uint64
+		nodeIDs(root, wanted, ids, &next)
+
+		keys := make([]string, 0, len(kIndex))
+		for key := range kIndex {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		sw.uvarint(uint64(len(keys)))
+		for _, key := range keys {
+			vIndex := kIndex[key]
+			sw.string(key)
+			sw.uvarint(uint64(len(vIndex.records)))
+			for sval, recs := range vIndex.records {
+				sw.string(sval)
+				sw.uvarint(uint64(len(recs)))
+				for _, rec := range recs {
+					id, _ := recordID(rec)
+					sw.uvarint(ids[id])
+				}
+			}
+		}
+
+		sw.value(root)
+	}
+	if sw.err != nil {
+		return sw.err
+	}
+	return sw.w.Flush()
+}
+
+type snapshotReader struct {
+	r    *bufio.Reader
+	keys []string
+	// node numbers the decoded objects and lists, the ones in the
+	// sorted wanted numbers are kept in nodes
+	node   uint64
+	wanted []uint64
+	nodes  map[uint64]interface{}
+	err    error
+}
+
+func (sr *snapshotReader) fail(err error) {
+	if sr.err != nil {
+		return
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	sr.err = fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+}
+
+func (sr *snapshotReader) uvarint() uint64 {
+	if sr.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(sr.r)
+	if err != nil {
+		sr.fail(err)
+	}
+	return v
+}
+
+func (sr *snapshotReader) varint() int64 {
+	if sr.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(sr.r)
+	if err != nil {
+		sr.fail(err)
+	}
+	return v
+}
+
+// count reads a length, failing on lengths that cannot be allocated.
+func (sr *snapshotReader) count() int {
+	n := sr.uvarint()
+	if n > math.MaxInt32 {
+		sr.fail(fmt.Errorf("length %d", n))
+		return 0
+	}
+	return int(n)
+}
+
+func (sr *snapshotReader) byte() byte {
+	if sr.err != nil {
+		return 0
+	}
+	b, err := sr.r.ReadByte()
+	if err != nil {
+		sr.fail(err)
+	}
+	return b
+}
+
+func (sr *snapshotReader) string() string {
+	n := sr.count()
+	if sr.err != nil {
+		return ""
+	}
+	if n > maxPrealloc {
+		var buf bytes.Buffer
+		if _, err := io.CopyN(&buf, sr.r, int64(n)); err != nil {
+			sr.fail(err)
+			return ""
+		}
+		return buf.String()
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(sr.r, b); err != nil {
+		sr.fail(err)
+		return ""
+	}
+	return string(b)
+}
+
+func (sr *snapshotReader) key() string {
+	n := sr.uvarint()
+	if sr.err != nil {
+		return ""
+	}
+	if n == 0 {
+		k := sr.string()
+		sr.keys = append(sr.keys, k)
+		return k
+	}
+	if n > uint64(len(sr.keys)) {
+		sr.fail(fmt.Errorf("unknown key %d", n))
+		return ""
+	}
+	return sr.keys[n-1]
+}
+
+// nextNode numbers a decoded object or list.
+func (sr *snapshotReader) nextNode() uint64 {
+	sr.node++
+	return sr.node - 1
+}
+
+// keep records a decoded object or list when it is wanted.
+func (sr *snapshotReader) keep(id uint64, v interface{}) {
+	if len(sr.wanted) > 0 && sr.wanted[0] == id {
+		sr.nodes[id] = v
+		sr.wanted = sr.wanted[1:]
+	}
+}
+
+// maxPrealloc bounds the space allocated ahead for the lengths read from
+// a snapshot, so that a corrupted length fails on the missing data.
+const maxPrealloc = 1 << 16
+
+func prealloc(n int) int {
+	if n > maxPrealloc {
+		return maxPrealloc
+	}
+	return n
+}
+
+func (sr *snapshotReader) value() interface{} {
+	switch tag := sr.byte(); tag {
+	case tagNull:
+		return nil
+	case tagFalse:
+		return false
+	case tagTrue:
+		return true
+	case tagIntegral:
+		return float64(sr.varint())
+	case tagNumber:
+		var b [8]byte
+		if _, err := io.ReadFull(sr.r, b[:]); err != nil {
+			sr.fail(err)
+			return nil
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b[:]))
+	case tagInt:
+		return int(sr.varint())
+	case tagString:
+		return sr.string()
+	case tagList:
+		id := sr.nextNode()
+		n := sr.count()
+		list := make([]interface{}, 0, prealloc(n))
+		for ; n > 0 && sr.err == nil; n-- {
+			list = append(list, sr.value())
+		}
+		sr.keep(id, list)
+		return list
+	case tagObject:
+		id := sr.nextNode()
+		n := sr.count()
+		obj := make(map[string]interface{}, prealloc(n))
+		sr.keep(id, obj)
+		for ; n > 0 && sr.err == nil; n-- {
+			k := sr.key()
+			obj[k] = sr.value()
+		}
+		return obj
+	default:
+		sr.fail(fmt.Errorf("unknown tag %d", tag))
+	}
+	return nil
+}
+
+// Open restores the databases, indexes and relationships of a snapshot
+// written by Save. It returns ErrSnapshotVersion for snapshots of other
+// versions and ErrInvalidSnapshot for corrupted snapshots.
+func Open(r io.Reader) (*JsonDB, error) {
+
+	sr := &snapshotReader{r: bufio.NewReader(r)}
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(sr.r, magic); err != nil || string(magic) != snapshotMagic {
+		return nil, fmt.Errorf("%w: not a snapshot", ErrInvalidSnapshot)
+	}
+	if v := sr.uvarint(); sr.err == nil && v != snapshotVersion {
+		return nil, fmt.Errorf("%w %d", ErrSnapshotVersion, v)
+	}
+
+	jdb := &JsonDB{dbMap: make(DBMap)}
+	for n := sr.count(); n > 0 && sr.err == nil; n-- {
+		jdb.relations = append(jdb.relations, sr.string())
+	}
+
+	for dbs := sr.count(); dbs > 0 && sr.err == nil; dbs-- {
+		name := sr.string()
+		jsonType := &JSONType{}
+		if sr.byte() == 1 {
+			jsonType.source = &Source{
+				Path:    sr.string(),
+				Size:    sr.varint(),
+				ModTime: time.Unix(0, sr.varint()),
+				Hash:    sr.string(),
+			}
+		}
+
+		// the postings are resolved once the root value is decoded
+		postings := make(map[string]map[string][]uint64)
+		sr.node = 0
+		sr.wanted = sr.wanted[:0]
+		for keys := sr.count(); keys > 0 && sr.err == nil; keys-- {
+			key := sr.string()
+			values := sr.count()
+			vIndex := make(map[string][]uint64, prealloc(values))
+			for ; values > 0 && sr.err == nil; values-- {
+				sval := sr.string()
+				var ids []uint64
+				for n := sr.count(); n > 0 && sr.err == nil; n-- {
+					id := sr.uvarint()
+					sr.wanted = append(sr.wanted, id)
+					ids = append(ids, id)
+				}
+				vIndex[sval] = ids
+			}
+			postings[key] = vIndex
+		}
+
+		sort.Slice(sr.wanted, func(i, j int) bool { return sr.wanted[i] < sr.wanted[j] })
+		unique := sr.wanted[:0]
+		for n, id := range sr.wanted {
+			if n == 0 || id != sr.wanted[n-1] {
+				unique = append(unique, id)
+			}
+		}
+		sr.wanted = unique
+		sr.nodes = make(map[uint64]interface{}, len(unique))
+
+		switch root := sr.value().(type) {
+		case []interface{}:
+			jsonType.list = root
+		case map[string]interface{}:
+			jsonType.dict = root
+		default:
+			sr.fail(fmt.Errorf("database %s is not a list or an object", name))
+		}
+		if sr.err != nil {
+			return nil, sr.err
+		}
+		jdb.dbMap[name] = jsonType
+
+		if len(postings) == 0 {
+			continue
+		}
+		if jdb.dbIndex == nil {
+			jdb.dbIndex = make(DBIndex)
+		}
+		kIndex := make(keyIndex, len(postings))
+		for key, vIndex := range postings {
+			values := make(map[string][]interface{}, len(vIndex))
+			for sval, ids := range vIndex {
+				recs := make([]interface{}, len(ids))
+				for n, id := range ids {
+					rec := sr.nodes[id]
+					if rec == nil {
+						return nil, fmt.Errorf("%w: unknown record %d", ErrInvalidSnapshot, id)
+					}
+					recs[n] = rec
+				}
+				values[sval] = recs
+			}
+			kIndex[key] = &valueIndex{records: values}
+		}
+		jdb.dbIndex[name] = kIndex
+	}
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	return jdb, nil
+}
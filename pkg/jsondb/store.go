@@ -0,0 +1,150 @@
+package jsondb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"unicode"
+
+	"github.com/gusaki/jsonsearch/internal/db"
+)
+
+var (
+	ErrNotList          = errors.New("database file does not hold a list of records")
+	ErrUnsupportedStore = errors.New("not supported for databases kept on disk")
+	ErrStoreChanged     = errors.New("database file changed while it is open")
+)
+
+// Store holds the records of a database outside of the in-memory
+// databases. A store validates its records when it is opened, Record
+// decodes a valid record and is safe for concurrent use. The records
+// returned by a store must not be modified.
+type Store interface {
+	// Len returns the number of records.
+	Len() int
+	// Record returns the record at a position, or an error when the
+	// record cannot be read anymore.
+	Record(n int) (interface{}, error)
+	// Close releases the resources of the store.
+	Close() error
+}
+
+// DiskStore is a Store of the records of a JSON file holding a list of
+// records. The file is memory mapped and only the offsets of the
+// records are kept in memory, a record is decoded each time it is
+// accessed. The file must not be modified in place while it is open,
+// replace it with a new file instead: the records of a file truncated
+// or rewritten in place fail with ErrStoreChanged, or may decode to the
+// new content of the file.
+type DiskStore struct {
+	data []byte
+	// start and end offsets of each record
+	offsets []int64
+	unmap   func() error
+	source  *Source
+}
+
+// OpenDiskStore memory maps a JSON file and indexes the offsets of its
+// records. It returns ErrNotList when the file does not hold a list.
+func OpenDiskStore(filename string) (*DiskStore, error) {
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	source, err := newSource(filename, file)
+	if err != nil {
+		return nil, err
+	}
+	data, unmap, err := mmap(file, int(source.Size))
+	if err != nil {
+		return nil, err
+	}
+	s := &DiskStore{data: data, unmap: unmap, source: source}
+	if err := s.index(); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	// a store replaced by a reload is unmapped once no search uses it
+	runtime.SetFinalizer(s, (*DiskStore).Close)
+	h := sha256.Sum256(data)
+	source.Hash = hex.EncodeToString(h[:])
+	return s, nil
+}
+
+// index validates the records of the file and records their offsets.
+func (s *DiskStore) index() error {
+	trimmed := bytes.TrimLeftFunc(s.data, unicode.IsSpace)
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		return ErrNotList
+	}
+	dec := json.NewDecoder(bytes.NewReader(s.data))
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		end := dec.InputOffset()
+		s.offsets = append(s.offsets, end-int64(len(raw)), end)
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("%w: data after the list", db.ErrInvalidJson)
+	}
+	return nil
+}
+
+// Len returns the number of records.
+func (s *DiskStore) Len() int {
+	return len(s.offsets) / 2
+}
+
+// Record decodes the record at a position. It returns ErrStoreChanged
+// when the file was truncated or rewritten in place and the record
+// cannot be read or decoded anymore.
+func (s *DiskStore) Record(n int) (rec interface{}, err error) {
+	// reading the mapping of a truncated file faults, the fault panics
+	// instead of killing the process
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(interface{ Addr() uintptr }); !ok {
+				panic(r)
+			}
+			rec, err = nil, fmt.Errorf("%w: %s", ErrStoreChanged, s.source.Path)
+		}
+	}()
+	var v interface{}
+	err = json.Unmarshal(s.data[s.offsets[2*n]:s.offsets[2*n+1]], &v)
+	// the file stays mapped while it is decoded
+	runtime.KeepAlive(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: record %d: %v", ErrStoreChanged, s.source.Path, n, err)
+	}
+	return v, nil
+}
+
+// Close unmaps the file, the store cannot be used afterwards.
+func (s *DiskStore) Close() error {
+	if s.unmap == nil {
+		return nil
+	}
+	err := s.unmap()
+	runtime.SetFinalizer(s, nil)
+	s.unmap = nil
+	s.data = nil
+	return err
+}
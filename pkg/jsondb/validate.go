@@ -0,0 +1,175 @@
+package jsondb
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+var (
+	ErrValidation    = errors.New("schema validation failed")
+	ErrInvalidSchema = errors.New("invalid JSON schema")
+)
+
+// ValidationMode selects what Load does with records failing the
+// validation against their database schema.
+type ValidationMode int
+
+const (
+	// ValidateStrict fails the load.
+	ValidateStrict ValidationMode = iota
+	// ValidateWarn keeps the records and reports the errors in
+	// ValidationErrors.
+	ValidateWarn
+)
+
+// LoadOptions configures LoadWithOptions. Schemas maps database names
+// to the path or URL of their JSON Schema, schemas without $schema are
+// read as draft 2020-12. DiskStore keeps the databases holding a list
+// of records on disk in a DiskStore instead of loading them in memory.
+type LoadOptions struct {
+	Schemas   map[string]string
+	Validate  ValidationMode
+	DiskStore bool
+}
+
+// ValidationError is a record failing the validation. Record is the
+// index of the record in its database and Pointer the JSON pointer of
+// the failing value within the record. Keyword is the location of the
+// failing keyword in the schema.
+type ValidationError struct {
+	DB      string `json:"db"`
+	Record  int    `json:"record"`
+	Pointer string `json:"pointer"`
+	Keyword string `json:"keyword"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) String() string {
+	pointer := e.Pointer
+	if pointer == "" {
+		pointer = "/"
+	}
+	return fmt.Sprintf("%s[%d] %s: %s", e.DB, e.Record, pointer, e.Message)
+}
+
+// SchemaErrors is the error returned by LoadWithOptions when records
+// fail the validation in strict mode. It matches ErrValidation.
+type SchemaErrors []ValidationError
+
+func (e SchemaErrors) Error() string {
+	if len(e) == 1 {
+		return fmt.Sprintf("%v: %v", ErrValidation, e[0])
+	}
+	return fmt.Sprintf("%v: %v and %d more errors", ErrValidation, e[0], len(e)-1)
+}
+
+func (e SchemaErrors) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// LoadWithOptions loads the databases like Load and validates the
+// records of the databases having a schema.
+func LoadWithOptions(filenames []string, opts LoadOptions) (*JsonDB, error) {
+
+	jdb, err := load(filenames, opts.DiskStore)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(opts.Schemas))
+	for name := range opts.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var all []ValidationError
+	for _, name := range names {
+		errs, err := jdb.Validate(name, opts.Schemas[name])
+		if err == ErrInvalidDatabase {
+			jdb.Close()
+			return nil, fmt.Errorf("%w %s with schema %s", err, name, opts.Schemas[name])
+		}
+		if err != nil {
+			jdb.Close()
+			return nil, err
+		}
+		all = append(all, errs...)
+	}
+	if len(all) > 0 && opts.Validate == ValidateStrict {
+		jdb.Close()
+		return nil, SchemaErrors(all)
+	}
+	jdb.validationErrors = all
+	jdb.schemas = opts.Schemas
+	jdb.validate = opts.Validate
+	return jdb, nil
+}
+
+// ValidationErrors returns the errors of the records kept by a load, or
+// a later Reload, in ValidateWarn mode.
+func (jdb *JsonDB) ValidationErrors() []ValidationError {
+	jdb.mu.RLock()
+	defer jdb.mu.RUnlock()
+	return jdb.validationErrors
+}
+
+// Validate validates each record of the database against the JSON
+// Schema at the path or URL and returns the errors of the failing
+// records.
+func (jdb *JsonDB) Validate(dbname, schemaURL string) ([]ValidationError, error) {
+
+	if jdb == nil || jdb.dbMap == nil {
+		return nil, ErrInvalidDatabase
+	}
+	if _, ok := jdb.dbMap[dbname]; !ok {
+		return nil, ErrInvalidDatabase
+	}
+	return validateRecords(dbname, jdb.getDB(dbname), schemaURL)
+}
+
+// validateRecords validates the records of the root, or of the Store,
+// of a database like Validate.
+func validateRecords(dbname string, root interface{}, schemaURL string) ([]ValidationError, error) {
+	c := jsonschema.NewCompiler()
+	c.Draft = jsonschema.Draft2020
+	schema, err := c.Compile(schemaURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %v", ErrInvalidSchema, schemaURL, err)
+	}
+
+	var errs []ValidationError
+	err = eachRecord(root, func(n int, r interface{}) error {
+		err := schema.Validate(r)
+		if err == nil {
+			return nil
+		}
+		var ve *jsonschema.ValidationError
+		if !errors.As(err, &ve) {
+			return err
+		}
+		// the causes of an error are more precise than the error
+		var leaves func(e *jsonschema.ValidationError)
+		leaves = func(e *jsonschema.ValidationError) {
+			if len(e.Causes) == 0 {
+				errs = append(errs, ValidationError{
+					DB:      dbname,
+					Record:  n,
+					Pointer: e.InstanceLocation,
+					Keyword: e.KeywordLocation,
+					Message: e.Message,
+				})
+			}
+			for _, cause := range e.Causes {
+				leaves(cause)
+			}
+		}
+		leaves(ve)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
@@ -0,0 +1,138 @@
+package jsondb
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ReloadEvent reports a reload of a database by Watch. Indexes are the
+// keys of the rebuilt indexes, Err the error of a failed reload after
+// which the loaded database is kept.
+type ReloadEvent struct {
+	DB       string
+	Path     string
+	Indexes  []string
+	Duration time.Duration
+	Err      error
+}
+
+// fileState is the state of a database file compared between checks.
+type fileState struct {
+	size    int64
+	modTime int64
+	err     string
+}
+
+func statFile(path string) fileState {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileState{err: err.Error()}
+	}
+	return fileState{size: fi.Size(), modTime: fi.ModTime().UnixNano()}
+}
+
+// Watch reloads the databases whose file changed, see Reload, until the
+// context is done and calls report with each reload. The directories of
+// the files are watched with file system events where supported and
+// the files are polled every interval otherwise. A database is reloaded
+// once its file is unchanged between two checks an interval apart, so
+// that a file still being written is not loaded, and a file failing to
+// reload is retried once it changes again.
+func (jdb *JsonDB) Watch(ctx context.Context, interval time.Duration, report func(ReloadEvent)) {
+
+	var names []string
+	dirs := make(map[string]bool)
+	for _, name := range jdb.Names() {
+		if source, ok := jdb.Source(name); ok {
+			names = append(names, name)
+			dirs[filepath.Dir(source.Path)] = true
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+	watched := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		watched = append(watched, dir)
+	}
+	sort.Strings(watched)
+
+	var tick <-chan time.Time
+	poll := func() {
+		ticker := time.NewTicker(interval)
+		go func() {
+			<-ctx.Done()
+			ticker.Stop()
+		}()
+		tick = ticker.C
+	}
+	events, err := watchDirs(ctx, watched)
+	if err != nil {
+		poll()
+	}
+	settle := time.NewTimer(interval)
+	settle.Stop()
+	defer settle.Stop()
+
+	pending := make(map[string]fileState)
+	failed := make(map[string]fileState)
+	check := func() {
+		for _, name := range names {
+			source, _ := jdb.Source(name)
+			state := statFile(source.Path)
+			loaded := fileState{size: source.Size, modTime: source.ModTime.UnixNano()}
+			if state == loaded || state == failed[name] {
+				delete(pending, name)
+				continue
+			}
+			if p, ok := pending[name]; !ok || p != state {
+				pending[name] = state
+				continue
+			}
+			delete(pending, name)
+			start := time.Now()
+			keys, err := jdb.Reload(name)
+			if err != nil {
+				failed[name] = state
+			} else {
+				delete(failed, name)
+			}
+			report(ReloadEvent{
+				DB:       name,
+				Path:     source.Path,
+				Indexes:  keys,
+				Duration: time.Since(start),
+				Err:      err,
+			})
+		}
+		// without polling the pending files are checked again
+		if len(pending) > 0 && tick == nil {
+			if !settle.Stop() {
+				select {
+				case <-settle.C:
+				default:
+				}
+			}
+			settle.Reset(interval)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-events:
+			if !ok {
+				// the file events stopped, fall back to polling
+				events = nil
+				poll()
+			}
+		case <-tick:
+		case <-settle.C:
+		}
+		check()
+	}
+}
@@ -0,0 +1,48 @@
+package jsondb
+
+import (
+	"context"
+	"os"
+	"syscall"
+)
+
+// watchDirs returns a channel receiving a value when files of the
+// directories are written, created, renamed or removed, until the
+// context is done. The channel is closed when the events stop.
+func watchDirs(ctx context.Context, dirs []string) (<-chan struct{}, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	// files are usually replaced by renaming a new file over them
+	const mask = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE |
+		syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+	for _, dir := range dirs {
+		if _, err := syscall.InotifyAddWatch(fd, dir, mask); err != nil {
+			syscall.Close(fd)
+			return nil, err
+		}
+	}
+	// the non blocking descriptor is read through the poller so that
+	// closing it stops the read
+	file := os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		<-ctx.Done()
+		file.Close()
+	}()
+	events := make(chan struct{}, 1)
+	go func() {
+		defer close(events)
+		buf := make([]byte, 64*1024)
+		for {
+			if _, err := file.Read(buf); err != nil {
+				return
+			}
+			select {
+			case events <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return events, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package jsondb
+
+import (
+	"context"
+	"errors"
+)
+
+// watchDirs is not supported, the files are polled.
+func watchDirs(ctx context.Context, dirs []string) (<-chan struct{}, error) {
+	return nil, errors.New("file events not supported")
+}
@@ -0,0 +1,418 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/gusaki/jsonsearch/pkg/jsondb"
+)
+
+// JSON is the GraphQL scalar of the values without a single inferred
+// type, they are returned as is.
+var JSON = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "JSON",
+	Description: "A JSON value of any type.",
+	Serialize:   func(v interface{}) interface{} { return v },
+	ParseValue:  func(v interface{}) interface{} { return v },
+	ParseLiteral: func(v ast.Value) interface{} {
+		return v.GetValue()
+	},
+})
+
+// graphqlName returns the GraphQL name of a database or key, the
+// characters GraphQL names cannot hold are replaced with _.
+func graphqlName(s string) string {
+	var b strings.Builder
+	for n, c := range s {
+		switch {
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+			b.WriteRune(c)
+		case c >= '0' && c <= '9':
+			if n == 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(c)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	name := b.String()
+	// names starting with __ are reserved for introspection
+	if strings.HasPrefix(name, "__") {
+		name = "f" + name
+	}
+	if name == "" {
+		name = "_"
+	}
+	return name
+}
+
+// typeName returns the GraphQL type name of a database.
+func typeName(dbname string) string {
+	name := graphqlName(dbname)
+	return strings.ToUpper(name[0:1]) + name[1:]
+}
+
+// link is a field of a database type following a relationship from
+// its key to the records of another database.
+type link struct {
+	name    string
+	fromDB  string
+	fromKey string
+	toDB    string
+	toKey   string
+}
+
+// relatedTables holds the related tables of the links resolved during
+// a request, each table is built once however many records link to it.
+type relatedTables struct {
+	mu     sync.Mutex
+	tables map[link]*jsondb.RelatedTable
+}
+
+type relatedTablesKey struct{}
+
+// withRelatedTables returns a context caching the related tables of the
+// links resolved with it.
+func withRelatedTables(ctx context.Context) context.Context {
+	return context.WithValue(ctx, relatedTablesKey{}, &relatedTables{tables: make(map[link]*jsondb.RelatedTable)})
+}
+
+// related returns the records linked to the record, with the related
+// table cached in the context when there is one.
+func related(ctx context.Context, jdb *jsondb.JsonDB, l link, record interface{}) ([]interface{}, error) {
+	cache, ok := ctx.Value(relatedTablesKey{}).(*relatedTables)
+	if !ok {
+		return jdb.RelatedRecords(record, l.fromKey, l.toDB, l.toKey)
+	}
+	cache.mu.Lock()
+	table, ok := cache.tables[l]
+	if !ok {
+		var err error
+		table, err = jdb.RelatedTable(l.toDB, l.toKey)
+		if err != nil {
+			cache.mu.Unlock()
+			return nil, err
+		}
+		cache.tables[l] = table
+	}
+	cache.mu.Unlock()
+	return table.Records(record, l.fromKey)
+}
+
+// schemaBuilder builds the GraphQL types of the databases.
+type schemaBuilder struct {
+	db    *jsondb.JsonDB
+	types map[string]*graphql.Object
+	links map[string][]link
+}
+
+// scalarType returns the GraphQL scalar of a JSON type name.
+func scalarType(typ string) graphql.Output {
+	switch typ {
+	case "string":
+		return graphql.String
+	case "number":
+		return graphql.Float
+	case "boolean":
+		return graphql.Boolean
+	}
+	return nil
+}
+
+// single returns the type other than null of the type counts, or
+// false when there are none or several.
+func single(counts map[string]int) (string, bool) {
+	var found []string
+	for typ := range counts {
+		if typ != "null" {
+			found = append(found, typ)
+		}
+	}
+	if len(found) != 1 {
+		return "", false
+	}
+	return found[0], true
+}
+
+// fieldType returns the GraphQL type of the values of a key path, a
+// nested object type for objects and lists of objects, and JSON for
+// the values of several types.
+func (b *schemaBuilder) fieldType(s *jsondb.Schema, f jsondb.FieldSchema, name string) graphql.Output {
+	typ, ok := single(f.Types)
+	if !ok {
+		return JSON
+	}
+	if t := scalarType(typ); t != nil {
+		return t
+	}
+	switch typ {
+	case "object":
+		if obj := b.object(s, f.Path, name); obj != nil {
+			return obj
+		}
+	case "array":
+		item, ok := single(f.Items)
+		if !ok {
+			break
+		}
+		if t := scalarType(item); t != nil {
+			return graphql.NewList(t)
+		}
+		if item == "object" {
+			if obj := b.object(s, f.Path, name); obj != nil {
+				return graphql.NewList(obj)
+			}
+		}
+	}
+	return JSON
+}
+
+// fields returns the fields of the key paths directly under prefix,
+// the top level key paths when prefix is empty.
+func (b *schemaBuilder) fields(s *jsondb.Schema, prefix, name string) graphql.Fields {
+	fields := make(graphql.Fields)
+	for _, f := range s.Fields {
+		key := f.Path
+		if prefix != "" {
+			if !strings.HasPrefix(f.Path, prefix+".") {
+				continue
+			}
+			key = f.Path[len(prefix)+1:]
+		}
+		if strings.Contains(key, ".") {
+			continue
+		}
+		fname := graphqlName(key)
+		if _, ok := fields[fname]; ok {
+			continue
+		}
+		fields[fname] = &graphql.Field{
+			Type: b.fieldType(s, f, name+"_"+fname),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if obj, ok := p.Source.(map[string]interface{}); ok {
+					return obj[key], nil
+				}
+				return nil, nil
+			},
+		}
+	}
+	return fields
+}
+
+// object returns the type of the objects at a key path, or nil when
+// they have no fields.
+func (b *schemaBuilder) object(s *jsondb.Schema, path, name string) *graphql.Object {
+	fields := b.fields(s, path, name)
+	if len(fields) == 0 {
+		return nil
+	}
+	return graphql.NewObject(graphql.ObjectConfig{Name: name, Fields: fields})
+}
+
+// dbType returns the type of the records of a database, with the
+// fields of its inferred schema and of its links.
+func (b *schemaBuilder) dbType(dbname string) (*graphql.Object, error) {
+	s, err := b.db.Schema(dbname)
+	if err != nil {
+		return nil, err
+	}
+	name := typeName(dbname)
+	fields := b.fields(s, "", name)
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name:        name,
+		Description: fmt.Sprintf("A record of the %s database.", dbname),
+		// the links of the databases may refer to each other
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			for _, l := range b.links[dbname] {
+				if _, ok := fields[l.name]; ok {
+					continue
+				}
+				l := l
+				fields[l.name] = &graphql.Field{
+					Type:        graphql.NewList(b.types[l.toDB]),
+					Description: fmt.Sprintf("The %s records whose %s is the %s.", l.toDB, l.toKey, l.fromKey),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return related(p.Context, b.db, l, p.Source)
+					},
+				}
+			}
+			return fields
+		}),
+	}), nil
+}
+
+// buildLinks adds the links of the relationships, both ways unless
+// one way. A link is named after the related database. When several
+// links of a database lead to it, the name is suffixed with _by_ and
+// the related key, or the key of the database when the related keys
+// are the same.
+func (b *schemaBuilder) buildLinks(relations []string) error {
+	var all []link
+	counts := make(map[string]int)
+	add := func(fromDB, fromKey, toDB, toKey string) {
+		all = append(all, link{fromDB: fromDB, fromKey: fromKey, toDB: toDB, toKey: toKey})
+		counts[fromDB+"\x00"+toDB]++
+		counts[fromDB+"\x00"+toDB+"\x00"+toKey]++
+	}
+	for _, reln := range relations {
+		rel, err := jsondb.ParseRelationship(reln)
+		if err != nil {
+			return err
+		}
+		for _, dbname := range []string{rel.FromDB, rel.ToDB} {
+			if _, ok := b.types[dbname]; !ok {
+				return fmt.Errorf("%w %s in relationship %s", jsondb.ErrInvalidDatabase, dbname, reln)
+			}
+		}
+		add(rel.FromDB, rel.FromKey, rel.ToDB, rel.ToKey)
+		if !rel.OneWay {
+			add(rel.ToDB, rel.ToKey, rel.FromDB, rel.FromKey)
+		}
+	}
+	for _, l := range all {
+		switch {
+		case counts[l.fromDB+"\x00"+l.toDB] == 1:
+			l.name = graphqlName(l.toDB)
+		case counts[l.fromDB+"\x00"+l.toDB+"\x00"+l.toKey] == 1:
+			l.name = graphqlName(l.toDB + "_by_" + l.toKey)
+		default:
+			l.name = graphqlName(l.toDB + "_by_" + l.fromKey)
+		}
+		b.links[l.fromDB] = append(b.links[l.fromDB], l)
+	}
+	return nil
+}
+
+// lookup returns the records of the database matching all the
+// indexed key arguments, all the records without arguments. The search
+// stops with the error of the context when it is done.
+func lookup(ctx context.Context, jdb *jsondb.JsonDB, dbname string, keys map[string]string, args map[string]interface{}) ([]interface{}, error) {
+	var names []string
+	for arg := range keys {
+		if _, ok := args[arg]; ok {
+			names = append(names, arg)
+		}
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return jdb.Records(dbname)
+	}
+	first := names[0]
+	records, err := jdb.SearchContext(ctx, dbname, keys[first], args[first].(string), nil)
+	if err == jsondb.ErrKeyValueNotFound {
+		return make([]interface{}, 0), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	results := make([]interface{}, 0, len(records))
+	for _, r := range records {
+		match := true
+		for _, arg := range names[1:] {
+			if len(jsondb.MatchPaths(keys[arg], args[arg].(string), r)) == 0 {
+				match = false
+				break
+			}
+		}
+		if match {
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+// queryField returns the root field of a database, its indexed keys
+// are lookup arguments.
+func (b *schemaBuilder) queryField(dbname string, indexed []string) *graphql.Field {
+	args := graphql.FieldConfigArgument{}
+	keys := make(map[string]string)
+	for _, key := range indexed {
+		arg := graphqlName(key)
+		if _, ok := args[arg]; ok {
+			continue
+		}
+		keys[arg] = key
+		args[arg] = &graphql.ArgumentConfig{
+			Type:        graphql.String,
+			Description: fmt.Sprintf("The value of the indexed key %s.", key),
+		}
+	}
+	for _, arg := range []string{"limit", "offset"} {
+		if _, ok := args[arg]; !ok {
+			args[arg] = &graphql.ArgumentConfig{Type: graphql.Int}
+		}
+	}
+	return &graphql.Field{
+		Type:        graphql.NewList(b.types[dbname]),
+		Args:        args,
+		Description: fmt.Sprintf("The records of the %s database matching the indexed keys.", dbname),
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			records, err := lookup(p.Context, b.db, dbname, keys, p.Args)
+			if err != nil {
+				return nil, err
+			}
+			offset, _ := p.Args["offset"].(int)
+			limit, _ := p.Args["limit"].(int)
+			if offset < 0 || limit < 0 {
+				return nil, jsondb.ErrInvalidPage
+			}
+			if offset > len(records) {
+				offset = len(records)
+			}
+			end := len(records)
+			if limit > 0 && offset+limit < end {
+				end = offset + limit
+			}
+			return records[offset:end], nil
+		},
+	}
+}
+
+// NewSchema generates the GraphQL schema of the databases. Each
+// database is a type with the fields of its inferred schema, and a
+// field of the root query taking its indexed keys as lookup arguments.
+// The relationships are fields of the types resolving to the related
+// records.
+func NewSchema(jdb *jsondb.JsonDB, relations []string) (graphql.Schema, error) {
+
+	b := &schemaBuilder{
+		db:    jdb,
+		types: make(map[string]*graphql.Object),
+		links: make(map[string][]link),
+	}
+	for _, dbname := range jdb.Names() {
+		t, err := b.dbType(dbname)
+		if err != nil {
+			return graphql.Schema{}, err
+		}
+		b.types[dbname] = t
+	}
+	if err := b.buildLinks(relations); err != nil {
+		return graphql.Schema{}, err
+	}
+
+	indexed := make(map[string][]string)
+	for _, idx := range jdb.Indexes() {
+		indexed[idx.DB] = append(indexed[idx.DB], idx.Key)
+	}
+	fields := make(graphql.Fields)
+	for _, dbname := range jdb.Names() {
+		name := graphqlName(dbname)
+		if _, ok := fields[name]; ok {
+			return graphql.Schema{}, fmt.Errorf("databases with the same GraphQL name %s", name)
+		}
+		fields[name] = b.queryField(dbname, indexed[dbname])
+	}
+	if len(fields) == 0 {
+		return graphql.Schema{}, jsondb.ErrUninitializedDB
+	}
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: fields}),
+	})
+}
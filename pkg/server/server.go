@@ -0,0 +1,328 @@
+// Package server serves the databases of a JsonDB over HTTP with a
+// JSON REST API:
+//
+//	GET  /dbs                                the loaded databases
+//	GET  /dbs/{db}/search?key=&value=        a page of search results
+//	GET  /dbs/{db}/schema                    the inferred schema
+//	POST /query                              a query of the query language
+//	GET  /graphql?query=, POST /graphql      a GraphQL query, see NewSchema
+//
+// Errors are returned as {"error": "..."} with the status code of the
+// error: 404 for unknown databases and searches finding nothing, 400
+// for invalid requests and 500 otherwise. GraphQL errors are returned
+// in the errors of the GraphQL response.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/graphql-go/graphql"
+	"github.com/gusaki/jsonsearch/pkg/jsondb"
+)
+
+var errNotFound = errors.New("not found")
+
+// Server is the http.Handler of the REST API.
+type Server struct {
+	db        *jsondb.JsonDB
+	relations []string
+	// mu guards the GraphQL schema rebuilt by Refresh
+	mu        sync.RWMutex
+	gqlSchema graphql.Schema
+	gqlErr    error
+}
+
+// New returns the server of the database, searches and queries follow
+// the relationships. The GraphQL schema is generated from the indexes
+// built at this point.
+func New(db *jsondb.JsonDB, relations []string) *Server {
+	s := &Server{db: db, relations: relations}
+	s.gqlSchema, s.gqlErr = NewSchema(db, relations)
+	return s
+}
+
+// Refresh rebuilds the GraphQL schema from the databases, whose keys
+// may have changed since a reload.
+func (s *Server) Refresh() {
+	schema, err := NewSchema(s.db, s.relations)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gqlSchema, s.gqlErr = schema, err
+}
+
+// DBInfo describes a database in the response of GET /dbs.
+type DBInfo struct {
+	Name    string   `json:"name"`
+	Records int      `json:"records"`
+	Indexes []string `json:"indexes"`
+}
+
+// PageResponse is a page of search results.
+type PageResponse struct {
+	Results    []interface{} `json:"results"`
+	Matches    [][]string    `json:"matches,omitempty"`
+	Total      int           `json:"total"`
+	Offset     int           `json:"offset"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// RowsResponse holds the columns and rows of a join or an aggregation.
+type RowsResponse struct {
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+// QueryRequest is the body of POST /query.
+type QueryRequest struct {
+	Query string `json:"query"`
+}
+
+// GraphQLRequest is the body of POST /graphql.
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// ErrorResponse is the body of the responses of failed requests.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// statusCode maps the errors of the database to HTTP status codes.
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, errNotFound),
+		errors.Is(err, jsondb.ErrInvalidDatabase),
+		errors.Is(err, jsondb.ErrKeyValueNotFound),
+		errors.Is(err, jsondb.ErrKeyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, jsondb.ErrInvalidQuery),
+		errors.Is(err, jsondb.ErrInvalidSort),
+		errors.Is(err, jsondb.ErrInvalidCursor),
+		errors.Is(err, jsondb.ErrInvalidPage),
+		errors.Is(err, jsondb.ErrInvalidField),
+		errors.Is(err, jsondb.ErrInvalidJoin),
+		errors.Is(err, jsondb.ErrInvalidAggregate),
+		errors.Is(err, jsondb.ErrInvalidRelationship),
+		errors.Is(err, jsondb.ErrUnknownAlias),
+		errors.Is(err, jsondb.ErrDuplicateAlias),
+		errors.Is(err, jsondb.ErrAmbiguousJoin),
+		errors.Is(err, jsondb.ErrMissingRelationship):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	writeJSON(w, statusCode(err), ErrorResponse{Error: err.Error()})
+}
+
+// allow answers the requests of other methods with 405 and returns
+// whether the method is allowed.
+func allow(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method || (method == http.MethodGet && r.Method == http.MethodHead) {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeJSON(w, http.StatusMethodNotAllowed, ErrorResponse{
+		Error: fmt.Sprintf("method %s not allowed", r.Method),
+	})
+	return false
+}
+
+// ServeHTTP routes the requests of the API.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "dbs":
+		if allow(w, r, http.MethodGet) {
+			s.dbs(w)
+		}
+	case len(parts) == 3 && parts[0] == "dbs" && parts[2] == "search":
+		if allow(w, r, http.MethodGet) {
+			s.search(w, r, parts[1])
+		}
+	case len(parts) == 3 && parts[0] == "dbs" && parts[2] == "schema":
+		if allow(w, r, http.MethodGet) {
+			s.schema(w, parts[1])
+		}
+	case len(parts) == 1 && parts[0] == "query":
+		if allow(w, r, http.MethodPost) {
+			s.query(w, r)
+		}
+	case len(parts) == 1 && parts[0] == "graphql":
+		if allow(w, r, http.MethodGet, http.MethodPost) {
+			s.graphql(w, r)
+		}
+	default:
+		writeError(w, fmt.Errorf("%w: %s", errNotFound, r.URL.Path))
+	}
+}
+
+func (s *Server) dbs(w http.ResponseWriter) {
+	indexes := make(map[string][]string)
+	for _, idx := range s.db.Indexes() {
+		indexes[idx.DB] = append(indexes[idx.DB], idx.Key)
+	}
+	dbs := make([]DBInfo, 0)
+	for _, name := range s.db.Names() {
+		records, _ := s.db.Len(name)
+		keys := indexes[name]
+		if keys == nil {
+			keys = make([]string, 0)
+		}
+		dbs = append(dbs, DBInfo{Name: name, Records: records, Indexes: keys})
+	}
+	writeJSON(w, http.StatusOK, dbs)
+}
+
+// searchOptions reads the sort, limit, offset, cursor and fields
+// parameters of a search.
+func searchOptions(r *http.Request) (jsondb.SearchOptions, error) {
+	var opts jsondb.SearchOptions
+	var err error
+	params := r.URL.Query()
+	if opts.Sort, err = jsondb.ParseSort(params.Get("sort")); err != nil {
+		return opts, err
+	}
+	for _, p := range []struct {
+		name string
+		n    *int
+	}{{"limit", &opts.Limit}, {"offset", &opts.Offset}} {
+		if v := params.Get(p.name); v != "" {
+			if *p.n, err = strconv.Atoi(v); err != nil {
+				return opts, fmt.Errorf("%w: %s %s", jsondb.ErrInvalidPage, p.name, v)
+			}
+		}
+	}
+	opts.Cursor = params.Get("cursor")
+	if f := params.Get("fields"); f != "" {
+		if opts.Fields, err = jsondb.ParseProjection(f); err != nil {
+			return opts, err
+		}
+	}
+	opts.Matches = params.Get("matches") == "true"
+	return opts, nil
+}
+
+func pageResponse(page *jsondb.Page) PageResponse {
+	return PageResponse{
+		Results:    page.Results,
+		Matches:    page.Matches,
+		Total:      page.Total,
+		Offset:     page.Offset,
+		NextCursor: page.NextCursor,
+	}
+}
+
+func (s *Server) search(w http.ResponseWriter, r *http.Request, dbname string) {
+	params := r.URL.Query()
+	key := params.Get("key")
+	if key == "" {
+		writeError(w, fmt.Errorf("%w: missing key", jsondb.ErrInvalidQuery))
+		return
+	}
+	opts, err := searchOptions(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	page, err := s.db.SearchPageContext(r.Context(), dbname, key, params.Get("value"), s.relations, opts)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, pageResponse(page))
+}
+
+func (s *Server) schema(w http.ResponseWriter, dbname string) {
+	schema, err := s.db.Schema(dbname)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, schema)
+}
+
+func (s *Server) query(w http.ResponseWriter, r *http.Request) {
+	var req QueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, fmt.Errorf("%w: %v", jsondb.ErrInvalidQuery, err))
+		return
+	}
+	q, err := jsondb.ParseQuery(req.Query)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	q.Relations = s.relations
+	result, err := s.db.QueryContext(r.Context(), q)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if result.Page != nil {
+		writeJSON(w, http.StatusOK, pageResponse(result.Page))
+		return
+	}
+	rows := result.Rows
+	if rows == nil {
+		rows = make([][]interface{}, 0)
+	}
+	writeJSON(w, http.StatusOK, RowsResponse{Columns: result.Columns, Rows: rows})
+}
+
+func (s *Server) graphql(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	schema, err := s.gqlSchema, s.gqlErr
+	s.mu.RUnlock()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var req GraphQLRequest
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, fmt.Errorf("%w: %v", jsondb.ErrInvalidQuery, err))
+			return
+		}
+	} else {
+		params := r.URL.Query()
+		req.Query = params.Get("query")
+		req.OperationName = params.Get("operationName")
+		if v := params.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				writeError(w, fmt.Errorf("%w: variables: %v", jsondb.ErrInvalidQuery, err))
+				return
+			}
+		}
+	}
+	if req.Query == "" {
+		writeError(w, fmt.Errorf("%w: missing query", jsondb.ErrInvalidQuery))
+		return
+	}
+	result := graphql.Do(graphql.Params{
+		Schema:         schema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        withRelatedTables(r.Context()),
+	})
+	writeJSON(w, http.StatusOK, result)
+}
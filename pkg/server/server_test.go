@@ -0,0 +1,369 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/graphql-go/graphql"
+	"github.com/gusaki/jsonsearch/pkg/jsondb"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	files := []string{
+		"../jsondb/testdata/organizations.json",
+		"../jsondb/testdata/tickets.json",
+		"../jsondb/testdata/users.json",
+	}
+	jsonDb, err := jsondb.Load(files)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, jsonDb.BuildIndex("organizations", "_id"), nil)
+	assert.Equal(t, jsonDb.BuildIndex("tickets", "organization_id"), nil)
+	assert.Equal(t, jsonDb.BuildIndex("users", "_id"), nil)
+	assert.Equal(t, jsonDb.BuildIndex("users", "organization_id"), nil)
+	relations := []string{
+		"organizations._id:tickets.organization_id",
+		"organizations._id:users.organization_id",
+		"users._id:tickets.submitter_id",
+		"users._id:tickets.assignee_id",
+	}
+	ts := httptest.NewServer(New(jsonDb, relations))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestServer(t *testing.T) {
+	ts := newTestServer(t)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"List the databases", "GET", "/dbs", "", http.StatusOK},
+		{"Search", "GET", "/dbs/tickets/search?key=status&value=open&limit=5", "", http.StatusOK},
+		{"Search an index", "GET", "/dbs/organizations/search?key=_id&value=101", "", http.StatusOK},
+		{"Search unknown database", "GET", "/dbs/nodb/search?key=_id&value=101", "", http.StatusNotFound},
+		{"Search value not found", "GET", "/dbs/tickets/search?key=status&value=none", "", http.StatusNotFound},
+		{"Search without key", "GET", "/dbs/tickets/search?value=open", "", http.StatusBadRequest},
+		{"Search invalid limit", "GET", "/dbs/tickets/search?key=status&value=open&limit=x", "", http.StatusBadRequest},
+		{"Search invalid cursor", "GET", "/dbs/tickets/search?key=status&value=open&cursor=x", "", http.StatusBadRequest},
+		{"Schema", "GET", "/dbs/organizations/schema", "", http.StatusOK},
+		{"Schema unknown database", "GET", "/dbs/nodb/schema", "", http.StatusNotFound},
+		{"Query", "POST", "/query", `{"query": "from tickets group by status agg count"}`, http.StatusOK},
+		{"Invalid query", "POST", "/query", `{"query": "where status = open"}`, http.StatusBadRequest},
+		{"Invalid body", "POST", "/query", `from tickets`, http.StatusBadRequest},
+		{"Query unknown database", "POST", "/query", `{"query": "from nodb"}`, http.StatusNotFound},
+		{"Query with GET", "GET", "/query", "", http.StatusMethodNotAllowed},
+		{"Search with POST", "POST", "/dbs/tickets/search", "", http.StatusMethodNotAllowed},
+		{"Unknown path", "GET", "/tickets", "", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		log.Println("Test: ", test.name)
+		req, err := http.NewRequest(test.method, ts.URL+test.path, strings.NewReader(test.body))
+		assert.Equal(t, err, nil)
+		resp, err := http.DefaultClient.Do(req)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, resp.StatusCode, test.status)
+		assert.Equal(t, resp.Header.Get("Content-Type"), "application/json")
+		if test.status != http.StatusOK {
+			var e ErrorResponse
+			assert.Equal(t, json.NewDecoder(resp.Body).Decode(&e), nil)
+			assert.NotEqual(t, e.Error, "")
+		}
+		resp.Body.Close()
+	}
+}
+
+func TestServerResponses(t *testing.T) {
+	ts := newTestServer(t)
+
+	get := func(path string, v interface{}) {
+		resp, err := http.Get(ts.URL + path)
+		assert.Equal(t, err, nil)
+		defer resp.Body.Close()
+		assert.Equal(t, resp.StatusCode, http.StatusOK)
+		assert.Equal(t, json.NewDecoder(resp.Body).Decode(v), nil)
+	}
+
+	var dbs []DBInfo
+	get("/dbs", &dbs)
+	assert.Equal(t, dbs, []DBInfo{
+		{Name: "organizations", Records: 25, Indexes: []string{"_id"}},
+		{Name: "tickets", Records: 200, Indexes: []string{"organization_id"}},
+		{Name: "users", Records: 75, Indexes: []string{"_id", "organization_id"}},
+	})
+
+	// the search follows the relationship to the tickets
+	var page PageResponse
+	get("/dbs/organizations/search?key=_id&value=101&limit=2&fields=_id", &page)
+	assert.Equal(t, len(page.Results), 2)
+	assert.NotEqual(t, page.NextCursor, "")
+	total := page.Total
+
+	var next PageResponse
+	get("/dbs/organizations/search?key=_id&value=101&limit=2&fields=_id&cursor="+page.NextCursor, &next)
+	assert.Equal(t, next.Offset, 2)
+	assert.Equal(t, next.Total, total)
+
+	var schema jsondb.Schema
+	get("/dbs/organizations/schema", &schema)
+	assert.Equal(t, schema.Records, 25)
+	f, ok := schema.Field("name")
+	assert.Equal(t, ok, true)
+	assert.Equal(t, f.Type(), "string")
+
+	resp, err := http.Post(ts.URL+"/query", "application/json",
+		strings.NewReader(`{"query": "from tickets group by status agg count sort status"}`))
+	assert.Equal(t, err, nil)
+	defer resp.Body.Close()
+	var rows RowsResponse
+	assert.Equal(t, json.NewDecoder(resp.Body).Decode(&rows), nil)
+	assert.Equal(t, rows.Columns, []string{"status", "count"})
+	assert.Equal(t, len(rows.Rows), 5)
+}
+
+func TestServerConcurrentRequests(t *testing.T) {
+	ts := newTestServer(t)
+
+	paths := []string{
+		"/dbs/tickets/search?key=status&value=open&sort=-priority&limit=5",
+		"/dbs/organizations/search?key=_id&value=101",
+		"/dbs/users/schema",
+		"/graphql?query=%7B%20organizations%20%7B%20name%20tickets%20%7B%20subject%20%7D%20%7D%20%7D",
+	}
+	var wg sync.WaitGroup
+	for n := 0; n < 16; n++ {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			resp, err := http.Get(ts.URL + path)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("GET %s: %d", path, resp.StatusCode)
+			}
+		}(paths[n%len(paths)])
+	}
+	wg.Wait()
+}
+
+func TestGraphQL(t *testing.T) {
+	ts := newTestServer(t)
+
+	post := func(query string) map[string]interface{} {
+		body, _ := json.Marshal(GraphQLRequest{Query: query})
+		resp, err := http.Post(ts.URL+"/graphql", "application/json", strings.NewReader(string(body)))
+		assert.Equal(t, err, nil)
+		defer resp.Body.Close()
+		assert.Equal(t, resp.StatusCode, http.StatusOK)
+		var result map[string]interface{}
+		assert.Equal(t, json.NewDecoder(resp.Body).Decode(&result), nil)
+		return result
+	}
+
+	tests := []struct {
+		name   string
+		query  string
+		dbname string
+		count  int
+		errors bool
+	}{
+		{
+			"Lookup an organization",
+			`{ organizations(_id: "101") { _id name domain_names } }`,
+			"organizations",
+			1,
+			false,
+		},
+		{
+			"Lookup a missing value",
+			`{ organizations(_id: "1") { name } }`,
+			"organizations",
+			0,
+			false,
+		},
+		{
+			"All the records",
+			`{ users(offset: 70) { name } }`,
+			"users",
+			5,
+			false,
+		},
+		{
+			"Several lookup arguments",
+			`{ users(organization_id: "101", _id: "23") { name role } }`,
+			"users",
+			1,
+			false,
+		},
+		{
+			"Limit",
+			`{ tickets(organization_id: "101", limit: 2) { subject } }`,
+			"tickets",
+			2,
+			false,
+		},
+		{
+			"Unknown field",
+			`{ organizations { nofield } }`,
+			"",
+			0,
+			true,
+		},
+		{
+			"Unindexed key",
+			`{ tickets(status: "open") { subject } }`,
+			"",
+			0,
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		log.Println("Test: ", test.name)
+		result := post(test.query)
+		_, hasErrors := result["errors"]
+		assert.Equal(t, hasErrors, test.errors)
+		if test.dbname != "" {
+			data := result["data"].(map[string]interface{})
+			assert.Equal(t, len(data[test.dbname].([]interface{})), test.count)
+		}
+	}
+
+	// an organization with its users and tickets, and the submitters
+	// of the tickets
+	result := post(`{
+		organizations(_id: "101") {
+			name
+			users { name }
+			tickets { subject users_by_submitter_id { _id } }
+		}
+	}`)
+	assert.Equal(t, result["errors"], nil)
+	org := result["data"].(map[string]interface{})["organizations"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, org["name"], "Enthaze")
+	assert.Equal(t, len(org["users"].([]interface{})), 4)
+	tickets := org["tickets"].([]interface{})
+	assert.Equal(t, len(tickets), 4)
+	submitters := tickets[0].(map[string]interface{})["users_by_submitter_id"].([]interface{})
+	assert.Equal(t, len(submitters), 1)
+
+	// the types and fields generated from the inferred schemas
+	result = post(`{ __type(name: "Tickets") { fields { name type { name kind } } } }`)
+	fields := make(map[string]string)
+	for _, f := range result["data"].(map[string]interface{})["__type"].(map[string]interface{})["fields"].([]interface{}) {
+		field := f.(map[string]interface{})
+		typ := field["type"].(map[string]interface{})
+		name, _ := typ["name"].(string)
+		fields[field["name"].(string)] = name + typ["kind"].(string)
+	}
+	assert.Equal(t, fields["subject"], "StringSCALAR")
+	assert.Equal(t, fields["priority"], "StringSCALAR")
+	assert.Equal(t, fields["has_incidents"], "BooleanSCALAR")
+	assert.Equal(t, fields["via"], "StringSCALAR")
+	assert.Equal(t, fields["tags"], "LIST")
+	assert.Equal(t, fields["organizations"], "LIST")
+	assert.Equal(t, fields["users_by_assignee_id"], "LIST")
+
+	resp, err := http.Get(ts.URL + "/graphql?query=" + "%7B%20users(_id%3A%20%221%22)%20%7B%20name%20%7D%20%7D")
+	assert.Equal(t, err, nil)
+	defer resp.Body.Close()
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+}
+
+func TestGraphQLNestedTypes(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "orders.json")
+	data := `[
+		{"id": 1, "via": {"channel": "web", "source": {"from": "a"}}, "lines": [{"sku": "a", "qty": 2}], "mixed": 1},
+		{"id": 2, "via": {"channel": "api", "source": {"from": null}}, "lines": [], "mixed": "b"}
+	]`
+	assert.Equal(t, ioutil.WriteFile(file, []byte(data), 0644), nil)
+	jsonDb, err := jsondb.Load([]string{file})
+	assert.Equal(t, err, nil)
+	schema, err := NewSchema(jsonDb, nil)
+	assert.Equal(t, err, nil)
+
+	result := graphql.Do(graphql.Params{
+		Schema:        schema,
+		RequestString: `{ orders { id via { channel source { from } } lines { sku qty } mixed } }`,
+	})
+	assert.Equal(t, len(result.Errors), 0)
+	out, _ := json.Marshal(result.Data)
+	assert.Equal(t, string(out), `{"orders":[`+
+		`{"id":1,"lines":[{"qty":2,"sku":"a"}],"mixed":1,"via":{"channel":"web","source":{"from":"a"}}},`+
+		`{"id":2,"lines":[],"mixed":"b","via":{"channel":"api","source":{"from":null}}}]}`)
+
+	_, err = NewSchema(jsonDb, []string{"orders.id:nodb.id"})
+	assert.Equal(t, errors.Is(err, jsondb.ErrInvalidDatabase), true)
+}
+
+func TestGraphQLRelatedTables(t *testing.T) {
+	// the unindexed related key is scanned once per request
+	jsonDb, err := jsondb.Load([]string{
+		"../jsondb/testdata/organizations.json",
+		"../jsondb/testdata/tickets.json",
+	})
+	assert.Equal(t, err, nil)
+	schema, err := NewSchema(jsonDb, []string{"organizations._id:tickets.organization_id"})
+	assert.Equal(t, err, nil)
+
+	ctx := withRelatedTables(context.Background())
+	result := graphql.Do(graphql.Params{
+		Schema:        schema,
+		RequestString: `{ organizations { _id tickets { _id } } }`,
+		Context:       ctx,
+	})
+	assert.Equal(t, len(result.Errors), 0)
+	cache := ctx.Value(relatedTablesKey{}).(*relatedTables)
+	assert.Equal(t, len(cache.tables), 1)
+	total := 0
+	for _, org := range result.Data.(map[string]interface{})["organizations"].([]interface{}) {
+		total += len(org.(map[string]interface{})["tickets"].([]interface{}))
+	}
+	assert.Equal(t, total, 195)
+}
+
+func TestServerRefresh(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "orders.json")
+	assert.Equal(t, ioutil.WriteFile(file, []byte(`[{"id": 1}]`), 0644), nil)
+	jsonDb, err := jsondb.Load([]string{file})
+	assert.Equal(t, err, nil)
+	s := New(jsonDb, nil)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	query := func() []interface{} {
+		resp, err := http.Post(ts.URL+"/graphql", "application/json",
+			strings.NewReader(`{"query": "{ orders { id status } }"}`))
+		assert.Equal(t, err, nil)
+		defer resp.Body.Close()
+		var body struct {
+			Errors []interface{} `json:"errors"`
+		}
+		assert.Equal(t, json.NewDecoder(resp.Body).Decode(&body), nil)
+		return body.Errors
+	}
+	// the key added by the reload is queried once the schema is refreshed
+	assert.Equal(t, len(query()), 1)
+	assert.Equal(t, ioutil.WriteFile(file, []byte(`[{"id": 1, "status": "open"}]`), 0644), nil)
+	_, err = jsonDb.Reload("orders")
+	assert.Equal(t, err, nil)
+	s.Refresh()
+	assert.Equal(t, len(query()), 0)
+}